@@ -6,6 +6,9 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -14,10 +17,15 @@ import (
 
 	"github.com/mackerelio-labs/sabatrafficd/internal/config"
 	"github.com/mackerelio-labs/sabatrafficd/internal/diskcache"
+	"github.com/mackerelio-labs/sabatrafficd/internal/exporter"
+	"github.com/mackerelio-labs/sabatrafficd/internal/journal"
 	"github.com/mackerelio-labs/sabatrafficd/internal/mackerel"
+	"github.com/mackerelio-labs/sabatrafficd/internal/selfmetric"
 	"github.com/mackerelio-labs/sabatrafficd/internal/sender"
 	"github.com/mackerelio-labs/sabatrafficd/internal/sendqueue"
+	"github.com/mackerelio-labs/sabatrafficd/internal/status"
 	"github.com/mackerelio-labs/sabatrafficd/internal/ticker"
+	"github.com/mackerelio-labs/sabatrafficd/internal/trap"
 	"github.com/mackerelio-labs/sabatrafficd/internal/worker"
 )
 
@@ -30,9 +38,18 @@ type serveAndShutdown interface {
 	Alive() bool
 }
 
+// 設定から削除された際に後始末をする worker が実装する
+type remover interface {
+	Remove(ctx context.Context) error
+}
+
 var (
-	srvs []serveAndShutdown
-	conf *config.Config
+	// SIGHUP による追加と status の参照を保護する
+	srvsMu sync.RWMutex
+	srvs   []serveAndShutdown
+	conf   *config.Config
+
+	configChecksum atomic.Value
 
 	configFilename string
 
@@ -41,8 +58,17 @@ var (
 
 	client        *mackerel.Mackerel
 	sendQueue     *sendqueue.Queue
+	expiry        *sendqueue.Expiry
 	senderHandler *sender.Sender
 	dc            *diskcache.DiskCache
+
+	// http-listen が無指定の場合は nil
+	metricsExporter *exporter.Exporter
+	// metric の取得開始時刻を揃え、metric と metadata の同時実行数を制限する
+	pollScheduler *worker.Scheduler
+	// trap が無指定の場合は nil
+	trapReceiver *trap.Receiver
+	pollStats    = selfmetric.NewRegistry()
 )
 
 func main() {
@@ -58,22 +84,73 @@ func main() {
 	}
 
 	slog.Info("initialize...")
+	configChecksum.Store(conf.Checksum)
 
 	client = mackerel.New(conf.ApiKey)
 	conf.Collector = resolveCollectorHostIDs(ctx, conf.Collector, client)
 	sendQueue = sendqueue.New()
+	if conf.DiskCache != nil && conf.DiskCache.WAL {
+		j, replay, err := journal.Open(filepath.Join(conf.DiskCache.Directory, config.WALDirectory))
+		if err != nil {
+			slog.Warn("failed open journal", slog.String("error", err.Error()))
+		} else {
+			slog.Info("replay journal", slog.Int("items", len(replay)))
+			sendQueue = sendqueue.NewJournaled(j, replay)
+			defer j.Close() // nolint
+		}
+	}
+	expiry = sendqueue.NewExpiry(conf.MaxAge)
+	sendQueue.SetExpiry(expiry)
+	sendQueue.SetLimit(conf.SendQueue)
 	senderHandler = sender.New(client, sendQueue)
+	senderHandler.SetExpiry(expiry)
+	senderHandler.SetBatchSize(conf.PostBatchSize)
 
 	srvs = append(srvs, senderHandler)
 
+	var diskSenderHandler *sender.Sender
 	dc, err = diskcache.New(sendQueue, conf.DiskCache)
 	if err != nil {
 		slog.Warn("failed init diskcache", slog.String("error", err.Error()))
 	} else {
-		srvs = append(srvs, worker.New(dc, time.Second), sender.New(client, dc))
+		dc.SetExpiry(expiry)
+		sendQueue.SetSpiller(dc)
+		diskSenderHandler = sender.New(client, dc)
+		diskSenderHandler.SetExpiry(expiry)
+		diskSenderHandler.SetBatchSize(conf.PostBatchSize)
+		srvs = append(srvs, worker.New(dc, time.Second), diskSenderHandler)
 		defer dc.Close() // nolint
 	}
 
+	if conf.SelfMonitoringHostID != "" {
+		if err = client.CreateGraphDefs(ctx, selfmetric.GraphDefs); err != nil {
+			slog.WarnContext(ctx, "failed CreateGraphDefs", slog.String("error", err.Error()))
+		}
+		var selfTicker *selfmetric.Ticker
+		if diskSenderHandler != nil {
+			selfTicker = selfmetric.NewTicker(conf.SelfMonitoringHostID, pollStats, sendQueue, sendQueue, dc, expiry, senderHandler, diskSenderHandler)
+		} else {
+			selfTicker = selfmetric.NewTicker(conf.SelfMonitoringHostID, pollStats, sendQueue, sendQueue, nil, expiry, senderHandler)
+		}
+		srvs = append(srvs, worker.New(selfTicker, time.Minute))
+	}
+
+	if conf.StatusListen != "" {
+		srvs = append(srvs, status.New(conf.StatusListen, currentStatus))
+	}
+
+	if conf.HTTPListen != "" {
+		metricsExporter = exporter.New(conf.HTTPListen)
+		srvs = append(srvs, metricsExporter)
+	}
+
+	if conf.Trap != nil {
+		trapReceiver = trap.New(conf.Trap, client)
+		trapReceiver.SetTargets(ctx, conf.Collector)
+		srvs = append(srvs, trapReceiver)
+	}
+
+	pollScheduler = worker.NewScheduler(conf.MaxConcurrentPolls, pollStats)
 	for idx := range conf.Collector {
 		if len(conf.Collector[idx].CustomMIBsGraphDefs) > 0 {
 			if err = client.CreateGraphDefs(ctx, conf.Collector[idx].CustomMIBsGraphDefs); err != nil {
@@ -82,8 +159,8 @@ func main() {
 		}
 
 		srvs = append(srvs,
-			worker.New(ticker.MetadataNew(conf.Collector[idx], client), 3*time.Hour),
-			worker.New(ticker.New(conf.Collector[idx], sendQueue), time.Minute),
+			pollScheduler.NewLimited(ticker.MetadataNew(conf.Collector[idx], client), conf.Collector[idx].MetadataInterval),
+			pollScheduler.New(ticker.New(conf.Collector[idx], sendQueue, client, metricsExporter, pollStats), conf.Collector[idx].Interval),
 		)
 	}
 
@@ -97,6 +174,11 @@ func main() {
 }
 
 func runServe() {
+	// 起動中に SIGHUP で追加されたものは、reload 側で起動される
+	srvsMu.RLock()
+	initial := slices.Clone(srvs)
+	srvsMu.RUnlock()
+
 	var (
 		limit       = 55 * time.Second
 		maxInterval = 300 * time.Millisecond
@@ -107,7 +189,7 @@ func runServe() {
 		multiple  = 1
 		remainder = 0
 
-		srvsNum = len(srvs)
+		srvsNum = len(initial)
 	)
 
 	// limit 以内に全ての処理が起動状態となることを期待する
@@ -126,7 +208,7 @@ func runServe() {
 	}
 
 	var current int
-	for _, s := range srvs {
+	for _, s := range initial {
 		go func(s serveAndShutdown) {
 			if err := s.Serve(); err != nil {
 				slog.Error("failed Serve", slog.String("error", err.Error()))
@@ -150,6 +232,14 @@ func runServe() {
 	}
 }
 
+// trapConfigChanged は trap の設定が起動時から変わったかを返す。trap の設定は再起動するまで反映されない
+func trapConfigChanged(conf *config.TrapConfig) bool {
+	if trapReceiver == nil {
+		return conf != nil
+	}
+	return !reflect.DeepEqual(trapReceiver.Config(), conf)
+}
+
 func trapSignalInterrupt() {
 	go func() {
 		quit := make(chan os.Signal, 1)
@@ -179,8 +269,12 @@ func shutdown() {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
 
+	srvsMu.RLock()
+	current := slices.Clone(srvs)
+	srvsMu.RUnlock()
+
 	var wg sync.WaitGroup
-	for _, s := range srvs {
+	for _, s := range current {
 		wg.Add(1)
 		go func(ctx context.Context, s serveAndShutdown) {
 			defer wg.Done()
@@ -9,14 +9,12 @@ import (
 	"os/signal"
 	"slices"
 	"syscall"
-	"time"
 
 	"github.com/coreos/go-systemd/v22/daemon"
 
 	"github.com/mackerelio-labs/sabatrafficd/internal/config"
 	"github.com/mackerelio-labs/sabatrafficd/internal/sdnotify"
 	"github.com/mackerelio-labs/sabatrafficd/internal/ticker"
-	"github.com/mackerelio-labs/sabatrafficd/internal/worker"
 )
 
 func trapSignals() {
@@ -49,6 +47,13 @@ func trapSignals() {
 					continue
 				}
 				newConf.Collector = resolveCollectorHostIDs(context.Background(), newConf.Collector, client)
+				configChecksum.Store(newConf.Checksum)
+				if trapReceiver != nil {
+					trapReceiver.SetTargets(context.Background(), newConf.Collector)
+				}
+				if trapConfigChanged(newConf.Trap) {
+					slog.Warn("trap config is changed but not applied until restart")
+				}
 
 				var (
 					oldCollectorID []string
@@ -57,26 +62,30 @@ func trapSignals() {
 				for _, conf := range newConf.Collector {
 					newCollectorID = append(newCollectorID, conf.CollectorID())
 				}
+				srvsMu.RLock()
 				for _, conf := range srvs {
 					if conf.Alive() {
 						oldCollectorID = append(oldCollectorID, conf.CollectorID())
 					}
 				}
+				srvsMu.RUnlock()
 
 				for idx := range newConf.Collector {
 					// when exist, reload
 					if slices.Contains(oldCollectorID, newConf.Collector[idx].CollectorID()) {
+						srvsMu.RLock()
 						for oldIdx := range srvs {
 							if newConf.Collector[idx].CollectorID() == srvs[oldIdx].CollectorID() && srvs[oldIdx].Alive() {
 								slog.Info("Reload", slog.String("detail", newConf.Collector[idx].CollectorID()))
 								srvs[oldIdx].Reload(newConf.Collector[idx])
 							}
 						}
+						srvsMu.RUnlock()
 					} else {
 						// create
 						var workers = []serveAndShutdown{
-							worker.New(ticker.MetadataNew(newConf.Collector[idx], client), 3*time.Hour),
-							worker.New(ticker.New(newConf.Collector[idx], sendQueue), time.Minute),
+							pollScheduler.NewLimited(ticker.MetadataNew(newConf.Collector[idx], client), newConf.Collector[idx].MetadataInterval),
+							pollScheduler.New(ticker.New(newConf.Collector[idx], sendQueue, client, metricsExporter, pollStats), newConf.Collector[idx].Interval),
 						}
 						for idx := range workers {
 							go func() {
@@ -84,25 +93,33 @@ func trapSignals() {
 									slog.Warn("failed Serve", slog.String("error", err.Error()))
 								}
 							}()
+							srvsMu.Lock()
 							srvs = append(srvs, workers[idx])
+							srvsMu.Unlock()
 						}
 						slog.Info("Serve by reload", slog.String("detail", newConf.Collector[idx].CollectorID()))
 					}
 				}
 
-				for current := range srvs {
-					if srvs[current].Alive() &&
-						srvs[current].CollectorID() != "" &&
-						!slices.Contains(newCollectorID, srvs[current].CollectorID()) {
-						slog.Info("Shutdown by reload", slog.String("detail", srvs[current].CollectorID()))
+				srvsMu.RLock()
+				for _, s := range srvs {
+					if s.Alive() &&
+						s.CollectorID() != "" &&
+						!slices.Contains(newCollectorID, s.CollectorID()) {
+						slog.Info("Shutdown by reload", slog.String("detail", s.CollectorID()))
+						pollStats.Remove(s.CollectorID())
 						go func() {
-
-							if err := srvs[current].Shutdown(context.Background()); err != nil {
+							shutdown := s.Shutdown
+							if r, ok := s.(remover); ok {
+								shutdown = r.Remove
+							}
+							if err := shutdown(context.Background()); err != nil {
 								slog.Warn("failed Shutdown", slog.String("error", err.Error()))
 							}
 						}()
 					}
 				}
+				srvsMu.RUnlock()
 
 				// if err == nil {
 				// 	diff := cmp.Diff(conf, newConf, cmp.Comparer(func(x, y *regexp.Regexp) bool {
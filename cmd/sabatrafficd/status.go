@@ -0,0 +1,55 @@
+package main
+
+import (
+	"time"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/status"
+)
+
+type workerStatus interface {
+	LastTick() time.Time
+	Name() string
+}
+
+func currentStatus() *status.Status {
+	st := &status.Status{
+		Queue: status.Queue{Memory: sendQueue.Len(), Discarded: expiry.Discarded(), Dropped: sendQueue.Dropped()},
+	}
+	if checksum, ok := configChecksum.Load().(string); ok {
+		st.ConfigChecksum = checksum
+	}
+	if dc != nil {
+		disk, diskBytes := dc.Len(), dc.Bytes()
+		st.Queue.Disk, st.Queue.DiskBytes = &disk, &diskBytes
+	}
+
+	stats := pollStats.PollStats()
+
+	srvsMu.RLock()
+	defer srvsMu.RUnlock()
+	for _, s := range srvs {
+		ws, ok := s.(workerStatus)
+		if !ok {
+			continue
+		}
+		w := status.Worker{
+			CollectorID: s.CollectorID(),
+			Name:        ws.Name(),
+			Alive:       s.Alive(),
+		}
+		if tick := ws.LastTick(); !tick.IsZero() {
+			w.LastTick = &tick
+		}
+		if stat, ok := stats[w.CollectorID]; ok && w.Name == "metric" {
+			duration, metrics, overruns := stat.LastDuration.Seconds(), stat.Metrics, stat.Overruns
+			w.LastError = stat.LastError
+			w.LastPollDuration = &duration
+			w.Metrics = &metrics
+			w.Overruns = &overruns
+			latency := stat.QueueLatency.Seconds()
+			w.QueueLatency = &latency
+		}
+		st.Workers = append(st.Workers, w)
+	}
+	return st
+}
@@ -2,6 +2,10 @@ package collector
 
 import (
 	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/mackerelio-labs/sabatrafficd/internal/config"
 	"github.com/mackerelio-labs/sabatrafficd/internal/mib"
@@ -10,47 +14,129 @@ import (
 
 type snmpClient interface {
 	BulkWalk(oid string, length uint64) (map[uint64]uint64, error)
-	BulkWalkGetInterfaceName(length uint64) (map[uint64]string, error)
+	BulkWalkGetInterfaceName(oid string, length uint64) (map[uint64]string, error)
 	BulkWalkGetInterfaceState(length uint64) (map[uint64]bool, error)
+	BulkWalkGetInterfaceSpeed(length uint64) (map[uint64]uint64, error)
 	BulkWalkGetInterfaceIPAddress() (map[uint64][]string, error)
 	BulkWalkGetInterfacePhysAddress(length uint64) (map[uint64]string, error)
+	BulkWalkValues(oid string) (map[string]snmp.Value, error)
+	BulkWalkStrings(oid string) (map[string]string, error)
 	Close() error
 	GetInterfaceNumber() (uint64, error)
-	GetValues(mibs []string) ([]float64, error)
+	GetInterfaceNumberAndUpTime() (uint64, uint64, error)
+	GetValues(mibs []string) ([]snmp.Value, error)
 }
 
 type collector struct {
-	conf    *config.CollectorConfig
-	handler snmp.Handler
+	conf *config.CollectorConfig
+
+	mu sync.Mutex
+	// ifIndex:インターフェイス名 のキャッシュ。ifNumber が変化するか、sysUpTime が戻る（再起動）まで使い回す
+	ifDescr   map[uint64]string
+	ifNumber  uint64
+	sysUpTime uint64
 }
 
 func New(conf *config.CollectorConfig) *collector {
 	return &collector{
-		conf:    conf,
-		handler: snmp.NewHandler(conf.SNMP),
+		conf: conf,
 	}
 }
 
-func (c *collector) Do(ctx context.Context) ([]MetricsDutum, error) {
-	client, err := snmp.Connect(ctx, c.conf.SNMP, c.handler)
+// Poll は1つのセッションでインターフェイスのメトリックとカスタム MIB をまとめて取得する
+// セッションの確保や機器の状態の取得に失敗した場合はエラーを返す
+func (c *collector) Poll(ctx context.Context) (*Result, error) {
+	client, err := snmp.Connect(ctx, c.conf.SNMP)
 	if err != nil {
 		return nil, err
 	}
 	defer client.Close() // nolint
-	return do(ctx, client, c.conf)
+	return c.poll(ctx, client)
 }
 
-func do(_ context.Context, client snmpClient, conf *config.CollectorConfig) ([]MetricsDutum, error) {
-	ifNumber, err := client.GetInterfaceNumber()
+func (c *collector) poll(ctx context.Context, client snmpClient) (*Result, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ifNumber, sysUpTime, err := client.GetInterfaceNumberAndUpTime()
+	result := &Result{Time: time.Now(), SysUpTime: sysUpTime}
 	if err != nil {
-		return nil, err
+		// ifNumber が分からないためインターフェイスのメトリックは取得しないが、custom-mibs は取得する
+		result.SysUpTimeErr = err
+		result.MetricsErr = err
+	} else if err := c.interfaces(client, ifNumber, sysUpTime); err != nil {
+		result.MetricsErr = err
+	} else {
+		result.IfNames = c.ifDescr
+		result.Metrics, result.MetricsErr = do(ctx, client, c.conf, c.ifDescr)
+	}
+
+	if len(c.conf.CustomMIBs) > 0 {
+		result.Custom, result.CustomErr = doCustomMIBs(ctx, client, c.conf)
+	}
+	if len(c.conf.CustomMIBTables) > 0 {
+		result.Tables, result.TablesErr = doCustomMIBTables(ctx, client, c.conf)
+	}
+
+	// 何も取得できなかった場合は、機器が応答しないものとしてエラーを返す
+	if result.SysUpTimeErr != nil &&
+		(len(c.conf.CustomMIBs) == 0 || result.CustomErr != nil) &&
+		(len(c.conf.CustomMIBTables) == 0 || result.TablesErr != nil) {
+		return nil, result.SysUpTimeErr
 	}
-	ifDescr, err := client.BulkWalkGetInterfaceName(ifNumber)
+	return result, nil
+}
+
+func (c *collector) interfaces(client snmpClient, ifNumber, sysUpTime uint64) error {
+	if c.ifDescr != nil && c.ifNumber == ifNumber && c.sysUpTime <= sysUpTime {
+		c.sysUpTime = sysUpTime
+		return nil
+	}
+
+	ifDescr, err := interfaceNames(client, c.conf, ifNumber)
 	if err != nil {
-		return nil, err
+		c.ifDescr = nil
+		return err
+	}
+	c.ifDescr = ifDescr
+	c.ifNumber = ifNumber
+	c.sysUpTime = sysUpTime
+	return nil
+}
+
+// interfaceNames は conf.InterfaceNameSources の順に取得し、空でない最初の値をインターフェイス名とする
+func interfaceNames(client snmpClient, conf *config.CollectorConfig, ifNumber uint64) (map[uint64]string, error) {
+	sources := conf.InterfaceNameSources
+	if len(sources) == 0 {
+		sources = []string{"ifDescr"}
 	}
 
-	var ifOperStatus map[uint64]bool
+	names := make(map[uint64]string, ifNumber)
+	for _, source := range sources {
+		values, err := client.BulkWalkGetInterfaceName(mib.InterfaceNameMapping()[source], ifNumber)
+		if err != nil {
+			return nil, err
+		}
+		for ifIndex, name := range values {
+			if names[ifIndex] == "" {
+				names[ifIndex] = name
+			}
+		}
+		// ifNumber 個すべてのインターフェイスの名前が決まれば、残りの取得元は問い合わせない
+		if uint64(len(names)) >= ifNumber && !slices.Contains(slices.Collect(maps.Values(names)), "") {
+			break
+		}
+	}
+	return names, nil
+}
+
+func do(_ context.Context, client snmpClient, conf *config.CollectorConfig, ifDescr map[uint64]string) ([]MetricsDutum, error) {
+	ifNumber := uint64(len(ifDescr))
+
+	var (
+		ifOperStatus map[uint64]bool
+		err          error
+	)
 	if conf.SkipDownLinkState {
 		ifOperStatus, err = client.BulkWalkGetInterfaceState(ifNumber)
 		if err != nil {
@@ -58,6 +144,24 @@ func do(_ context.Context, client snmpClient, conf *config.CollectorConfig) ([]M
 		}
 	}
 
+	// 利用率の計算に使う。速度は運用中に変わりうるため、オクテット数とあわせて毎回取得する
+	var ifSpeed map[uint64]uint64
+	if slices.ContainsFunc(conf.MIBs, octetsMIB) {
+		ifSpeed, err = client.BulkWalkGetInterfaceSpeed(ifNumber)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// カウンタの再初期化を検知するために、毎回取得する
+	var discontinuity map[uint64]uint64
+	if len(conf.MIBs) > 0 {
+		discontinuity, err = client.BulkWalk(snmp.MIBifCounterDiscontinuityTime, ifNumber)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	metrics := make([]MetricsDutum, 0)
 
 	for _, mibName := range conf.MIBs {
@@ -77,18 +181,31 @@ func do(_ context.Context, client snmpClient, conf *config.CollectorConfig) ([]M
 			}
 
 			// skip when down(2)
-			if conf.SkipDownLinkState && !ifOperStatus[ifIndex] {
+			// リンク状態そのものを表す MIB は、down を検知するために除外しない
+			if conf.SkipDownLinkState && !ifOperStatus[ifIndex] && !linkStateMIB(mibName) {
 				continue
 			}
 
-			metrics = append(metrics, MetricsDutum{IfIndex: ifIndex, Mib: mibName, IfName: ifName, Value: value})
+			dutum := MetricsDutum{IfIndex: ifIndex, Mib: mibName, IfName: ifName, Value: value, Discontinuity: discontinuity[ifIndex]}
+			if octetsMIB(mibName) {
+				dutum.Speed = ifSpeed[ifIndex]
+			}
+			metrics = append(metrics, dutum)
 		}
 	}
 	return metrics, nil
 }
 
+func linkStateMIB(mibName string) bool {
+	return mibName == "ifOperStatus" || mibName == "ifAdminStatus" || mibName == "ifLastChange"
+}
+
+func octetsMIB(mibName string) bool {
+	return mibName == "ifInOctets" || mibName == "ifOutOctets" || mibName == "ifHCInOctets" || mibName == "ifHCOutOctets"
+}
+
 func (c *collector) DoInterfaceIPAddress(ctx context.Context) ([]Interface, error) {
-	client, err := snmp.Connect(ctx, c.conf.SNMP, c.handler)
+	client, err := snmp.Connect(ctx, c.conf.SNMP)
 	if err != nil {
 		return nil, err
 	}
@@ -96,12 +213,12 @@ func (c *collector) DoInterfaceIPAddress(ctx context.Context) ([]Interface, erro
 	return doInterfaceIPAddress(ctx, client, c.conf)
 }
 
-func doInterfaceIPAddress(_ context.Context, client snmpClient, _ *config.CollectorConfig) ([]Interface, error) {
+func doInterfaceIPAddress(_ context.Context, client snmpClient, conf *config.CollectorConfig) ([]Interface, error) {
 	ifNumber, err := client.GetInterfaceNumber()
 	if err != nil {
 		return nil, err
 	}
-	ifDescr, err := client.BulkWalkGetInterfaceName(ifNumber)
+	ifDescr, err := interfaceNames(client, conf, ifNumber)
 	if err != nil {
 		return nil, err
 	}
@@ -131,25 +248,98 @@ func doInterfaceIPAddress(_ context.Context, client snmpClient, _ *config.Collec
 	return interfaces, nil
 }
 
-// mib:value
-func (c *collector) DoCustomMIBs(ctx context.Context) (map[string]float64, error) {
-	client, err := snmp.Connect(ctx, c.conf.SNMP, c.handler)
+func (c *collector) DoInterfaceAliases(ctx context.Context) (map[uint64]string, map[uint64]string, error) {
+	client, err := snmp.Connect(ctx, c.conf.SNMP)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer client.Close() // nolint
-	return doCustomMIBs(ctx, client, c.conf)
+	return doInterfaceAliases(ctx, client, c.conf)
+}
+
+// doInterfaceAliases は絞り込み前のすべての ifIndex:インターフェイス名 と、取り込むインターフェイスの ifIndex:ifAlias を返す
+// ifAlias が空のインターフェイスは含めない
+func doInterfaceAliases(_ context.Context, client snmpClient, conf *config.CollectorConfig) (map[uint64]string, map[uint64]string, error) {
+	ifNumber, err := client.GetInterfaceNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	ifDescr, err := interfaceNames(client, conf, ifNumber)
+	if err != nil {
+		return nil, nil, err
+	}
+	ifAlias, err := client.BulkWalkGetInterfaceName(snmp.MIBifAlias, ifNumber)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aliases := make(map[uint64]string, len(ifAlias))
+	for ifIndex, alias := range ifAlias {
+		name, ok := ifDescr[ifIndex]
+		if !ok || alias == "" {
+			continue
+		}
+		if conf.IncludeRegexp != nil && !conf.IncludeRegexp.MatchString(name) {
+			continue
+		}
+		if conf.ExcludeRegexp != nil && conf.ExcludeRegexp.MatchString(name) {
+			continue
+		}
+		aliases[ifIndex] = alias
+	}
+	return ifDescr, aliases, nil
 }
 
 // mib:value
-func doCustomMIBs(_ context.Context, client snmpClient, conf *config.CollectorConfig) (map[string]float64, error) {
+func doCustomMIBs(_ context.Context, client snmpClient, conf *config.CollectorConfig) (map[string]snmp.Value, error) {
 	values, err := client.GetValues(conf.CustomMIBs)
 	if err != nil {
 		return nil, err
 	}
-	var result = make(map[string]float64, 0)
+	var result = make(map[string]snmp.Value, 0)
 	for idx := range values {
 		result[conf.CustomMIBs[idx]] = values[idx]
 	}
 	return result, nil
 }
+
+func doCustomMIBTables(_ context.Context, client snmpClient, conf *config.CollectorConfig) ([]CustomTableDutum, error) {
+	type tableKey struct {
+		mib, nameMIB string
+	}
+	var rows []CustomTableDutum
+	// 同じ列を参照する table があっても、列ごとに1回だけ取得する
+	walkedTables := make(map[tableKey]struct{}, len(conf.CustomMIBTables))
+	walkedValues := make(map[string]map[string]snmp.Value)
+	walkedNames := make(map[string]map[string]string)
+	for _, table := range conf.CustomMIBTables {
+		key := tableKey{mib: table.MIB, nameMIB: table.NameMIB}
+		if _, walked := walkedTables[key]; walked {
+			continue
+		}
+		walkedTables[key] = struct{}{}
+
+		values, walked := walkedValues[table.MIB]
+		if !walked {
+			var err error
+			if values, err = client.BulkWalkValues(table.MIB); err != nil {
+				return nil, err
+			}
+			walkedValues[table.MIB] = values
+		}
+
+		names, walked := walkedNames[table.NameMIB]
+		if table.NameMIB != "" && !walked {
+			var err error
+			if names, err = client.BulkWalkStrings(table.NameMIB); err != nil {
+				return nil, err
+			}
+			walkedNames[table.NameMIB] = names
+		}
+
+		for index, value := range values {
+			rows = append(rows, CustomTableDutum{MIB: table.MIB, NameMIB: table.NameMIB, Index: index, Name: names[index], Value: value})
+		}
+	}
+	return rows, nil
+}
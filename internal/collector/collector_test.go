@@ -11,9 +11,18 @@ import (
 	"github.com/google/go-cmp/cmp/cmpopts"
 
 	"github.com/mackerelio-labs/sabatrafficd/internal/config"
+	"github.com/mackerelio-labs/sabatrafficd/internal/snmp"
 )
 
 type mockSnmpClient struct {
+	ifNumber  uint64
+	sysUpTime uint64
+	// GetInterfaceNumberAndUpTime が返すエラー
+	headerErr  error
+	nameWalks  int
+	speedWalks int
+	// oid:BulkWalkValues, BulkWalkStrings の呼び出し回数
+	tableWalks map[string]int
 }
 
 var errInvalid = errors.New("invalid error")
@@ -34,17 +43,46 @@ func (m *mockSnmpClient) BulkWalk(oid string, length uint64) (map[uint64]uint64,
 			3: 120,
 			4: 120,
 		}, nil
+	case "1.3.6.1.2.1.31.1.1.1.19":
+		return map[uint64]uint64{
+			4: 500,
+		}, nil
+	case "1.3.6.1.2.1.2.2.1.8":
+		return map[uint64]uint64{
+			1: 1,
+			2: 2,
+			3: 1,
+			4: 1,
+		}, nil
 	default:
 		return nil, errInvalid
 	}
 }
-func (m *mockSnmpClient) BulkWalkGetInterfaceName(length uint64) (map[uint64]string, error) {
-	return map[uint64]string{
-		1: "lo0",
-		2: "eth0",
-		3: "eth1",
-		4: "eth2",
-	}, nil
+func (m *mockSnmpClient) BulkWalkGetInterfaceName(oid string, length uint64) (map[uint64]string, error) {
+	m.nameWalks++
+	switch oid {
+	case "1.3.6.1.2.1.2.2.1.2":
+		return map[uint64]string{
+			1: "lo0",
+			2: "eth0",
+			3: "eth1",
+			4: "eth2",
+		}, nil
+	case "1.3.6.1.2.1.31.1.1.1.1":
+		return map[uint64]string{
+			2: "Gi1/0/1",
+			3: "Gi1/0/2",
+			4: "Gi1/0/3",
+		}, nil
+	case "1.3.6.1.2.1.31.1.1.1.18":
+		return map[uint64]string{
+			2: "uplink",
+			3: "",
+			4: "server-a",
+		}, nil
+	default:
+		return nil, errInvalid
+	}
 }
 func (m *mockSnmpClient) BulkWalkGetInterfaceState(length uint64) (map[uint64]bool, error) {
 	return map[uint64]bool{
@@ -54,12 +92,28 @@ func (m *mockSnmpClient) BulkWalkGetInterfaceState(length uint64) (map[uint64]bo
 		4: true,
 	}, nil
 }
+func (m *mockSnmpClient) BulkWalkGetInterfaceSpeed(length uint64) (map[uint64]uint64, error) {
+	m.speedWalks++
+	return map[uint64]uint64{
+		2: 1_000_000_000,
+		3: 10_000_000_000,
+	}, nil
+}
 func (m *mockSnmpClient) Close() error {
 	return nil
 }
 func (m *mockSnmpClient) GetInterfaceNumber() (uint64, error) {
 	return 4, nil
 }
+func (m *mockSnmpClient) GetInterfaceNumberAndUpTime() (uint64, uint64, error) {
+	if m.headerErr != nil {
+		return 0, 0, m.headerErr
+	}
+	if m.ifNumber == 0 {
+		return 4, m.sysUpTime, nil
+	}
+	return m.ifNumber, m.sysUpTime, nil
+}
 
 func (m *mockSnmpClient) BulkWalkGetInterfaceIPAddress() (map[uint64][]string, error) {
 	return map[uint64][]string{
@@ -78,34 +132,202 @@ func (m *mockSnmpClient) BulkWalkGetInterfacePhysAddress(length uint64) (map[uin
 	}, nil
 }
 
-func (m *mockSnmpClient) GetValues(mibs []string) ([]float64, error) {
-	var values []float64
+func (m *mockSnmpClient) GetValues(mibs []string) ([]snmp.Value, error) {
+	var values []snmp.Value
 	for idx := range mibs {
 		sp := strings.Split(mibs[idx], ".")
 		v, _ := strconv.ParseFloat(sp[len(sp)-1], 64)
-		values = append(values, v)
+		values = append(values, snmp.Value{Float: v})
 	}
 	return values, nil
 }
 
+func (m *mockSnmpClient) BulkWalkValues(oid string) (map[string]snmp.Value, error) {
+	m.countTableWalk(oid)
+	switch oid {
+	case "1.3.6.1.2.1.25.3.3.1.2":
+		return map[string]snmp.Value{
+			"196608": {Float: 10},
+			"196609": {Float: 20},
+		}, nil
+	default:
+		return nil, errInvalid
+	}
+}
+
+func (m *mockSnmpClient) BulkWalkStrings(oid string) (map[string]string, error) {
+	m.countTableWalk(oid)
+	switch oid {
+	case "1.3.6.1.2.1.25.3.2.1.3":
+		return map[string]string{
+			"196608": "CPU 0",
+		}, nil
+	default:
+		return nil, errInvalid
+	}
+}
+
+func (m *mockSnmpClient) countTableWalk(oid string) {
+	if m.tableWalks == nil {
+		m.tableWalks = make(map[string]int)
+	}
+	m.tableWalks[oid]++
+}
+
+func mockIfDescr() map[uint64]string {
+	ifDescr, _ := (&mockSnmpClient{}).BulkWalkGetInterfaceName("1.3.6.1.2.1.2.2.1.2", 4)
+	return ifDescr
+}
+
+func TestPoll(t *testing.T) {
+	conf := &config.CollectorConfig{
+		MIBs:       []string{"ifHCInOctets"},
+		CustomMIBs: []string{"1.2.3.4.5.678901"},
+	}
+	c := New(conf)
+	client := &mockSnmpClient{sysUpTime: 100}
+
+	actual, err := c.poll(t.Context(), client)
+	if err != nil {
+		t.Fatal("invalid raised error")
+	}
+	expected := &Result{
+		SysUpTime: 100,
+		Metrics: []MetricsDutum{
+			{IfIndex: 1, Mib: "ifHCInOctets", IfName: "lo0", Value: 60},
+			{IfIndex: 2, Mib: "ifHCInOctets", IfName: "eth0", Value: 60, Speed: 1_000_000_000},
+			{IfIndex: 3, Mib: "ifHCInOctets", IfName: "eth1", Value: 60, Speed: 10_000_000_000},
+			{IfIndex: 4, Mib: "ifHCInOctets", IfName: "eth2", Value: 60, Discontinuity: 500},
+		},
+		IfNames: map[uint64]string{1: "lo0", 2: "eth0", 3: "eth1", 4: "eth2"},
+		Custom:  map[string]snmp.Value{"1.2.3.4.5.678901": {Float: 678901}},
+	}
+	if d := cmp.Diff(
+		actual,
+		expected,
+		cmpopts.SortSlices(func(i, j MetricsDutum) bool { return i.String() < j.String() }),
+		cmpopts.IgnoreFields(Result{}, "Time"),
+	); d != "" {
+		t.Errorf("invalid result %s", d)
+	}
+	if actual.Time.IsZero() {
+		t.Error("sampled time is not set")
+	}
+
+	// ifIndex:ifDescr は ifNumber の変化か sysUpTime の巻き戻りでのみ取り直し、速度は毎回取得する
+	for _, tc := range []struct {
+		ifNumber   uint64
+		sysUpTime  uint64
+		nameWalks  int
+		speedWalks int
+	}{
+		{ifNumber: 4, sysUpTime: 6100, nameWalks: 1, speedWalks: 2},
+		{ifNumber: 5, sysUpTime: 12100, nameWalks: 2, speedWalks: 3},
+		{ifNumber: 5, sysUpTime: 18100, nameWalks: 2, speedWalks: 4},
+		{ifNumber: 5, sysUpTime: 50, nameWalks: 3, speedWalks: 5},
+	} {
+		client.ifNumber, client.sysUpTime = tc.ifNumber, tc.sysUpTime
+		if _, err := c.poll(t.Context(), client); err != nil {
+			t.Fatal("invalid raised error")
+		}
+		if client.nameWalks != tc.nameWalks {
+			t.Errorf("ifNumber=%d sysUpTime=%d: ifDescr walked %d times, want %d", tc.ifNumber, tc.sysUpTime, client.nameWalks, tc.nameWalks)
+		}
+		if client.speedWalks != tc.speedWalks {
+			t.Errorf("ifNumber=%d sysUpTime=%d: speed walked %d times, want %d", tc.ifNumber, tc.sysUpTime, client.speedWalks, tc.speedWalks)
+		}
+	}
+}
+
+func TestPollWithoutInterfaceNumber(t *testing.T) {
+	conf := &config.CollectorConfig{
+		MIBs:       []string{"ifHCInOctets"},
+		CustomMIBs: []string{"1.2.3.4.5.678901"},
+	}
+	client := &mockSnmpClient{headerErr: errInvalid}
+
+	// ifNumber と sysUpTime が取得できなくても、custom-mibs は取得する
+	actual, err := New(conf).poll(t.Context(), client)
+	if err != nil {
+		t.Fatal("invalid raised error")
+	}
+	expected := &Result{
+		Custom:       map[string]snmp.Value{"1.2.3.4.5.678901": {Float: 678901}},
+		SysUpTimeErr: errInvalid,
+		MetricsErr:   errInvalid,
+	}
+	if d := cmp.Diff(
+		actual,
+		expected,
+		cmpopts.IgnoreFields(Result{}, "Time"),
+		cmpopts.EquateErrors(),
+	); d != "" {
+		t.Errorf("invalid result %s", d)
+	}
+
+	// 何も取得しない場合はエラーとする
+	conf.CustomMIBs = nil
+	if _, err := New(conf).poll(t.Context(), client); !errors.Is(err, errInvalid) {
+		t.Errorf("err = %v, want %v", err, errInvalid)
+	}
+}
+
+func TestInterfaceNames(t *testing.T) {
+	tests := []struct {
+		sources   []string
+		expected  map[uint64]string
+		nameWalks int
+	}{
+		{
+			sources:   []string{"ifDescr"},
+			expected:  map[uint64]string{1: "lo0", 2: "eth0", 3: "eth1", 4: "eth2"},
+			nameWalks: 1,
+		},
+		{
+			sources:   []string{"ifName", "ifDescr"},
+			expected:  map[uint64]string{1: "lo0", 2: "Gi1/0/1", 3: "Gi1/0/2", 4: "Gi1/0/3"},
+			nameWalks: 2,
+		},
+		{
+			sources:   []string{"ifDescr", "ifName"},
+			expected:  map[uint64]string{1: "lo0", 2: "eth0", 3: "eth1", 4: "eth2"},
+			nameWalks: 1,
+		},
+	}
+	for _, tc := range tests {
+		client := &mockSnmpClient{}
+		conf := &config.CollectorConfig{InterfaceNameSources: tc.sources}
+		actual, err := interfaceNames(client, conf, 4)
+		if err != nil {
+			t.Fatal("invalid raised error")
+		}
+		if d := cmp.Diff(actual, tc.expected); d != "" {
+			t.Errorf("%v: invalid result %s", tc.sources, d)
+		}
+		if client.nameWalks != tc.nameWalks {
+			t.Errorf("%v: walked %d times, want %d", tc.sources, client.nameWalks, tc.nameWalks)
+		}
+	}
+}
+
 func TestDo(t *testing.T) {
 	t.Run("non skip", func(t *testing.T) {
 		conf := &config.CollectorConfig{
 			MIBs: []string{"ifHCInOctets", "ifHCOutOctets"},
 		}
-		actual, err := do(t.Context(), &mockSnmpClient{}, conf)
+		actual, err := do(t.Context(), &mockSnmpClient{}, conf, mockIfDescr())
 		if err != nil {
 			t.Error("invalid raised error")
 		}
 		expected := []MetricsDutum{
 			{IfIndex: 1, Mib: "ifHCInOctets", IfName: "lo0", Value: 60},
-			{IfIndex: 2, Mib: "ifHCInOctets", IfName: "eth0", Value: 60},
-			{IfIndex: 3, Mib: "ifHCInOctets", IfName: "eth1", Value: 60},
-			{IfIndex: 4, Mib: "ifHCInOctets", IfName: "eth2", Value: 60},
+			{IfIndex: 2, Mib: "ifHCInOctets", IfName: "eth0", Value: 60, Speed: 1_000_000_000},
+			{IfIndex: 3, Mib: "ifHCInOctets", IfName: "eth1", Value: 60, Speed: 10_000_000_000},
+			{IfIndex: 4, Mib: "ifHCInOctets", IfName: "eth2", Value: 60, Discontinuity: 500},
 			{IfIndex: 1, Mib: "ifHCOutOctets", IfName: "lo0", Value: 120},
-			{IfIndex: 2, Mib: "ifHCOutOctets", IfName: "eth0", Value: 120},
-			{IfIndex: 3, Mib: "ifHCOutOctets", IfName: "eth1", Value: 120},
-			{IfIndex: 4, Mib: "ifHCOutOctets", IfName: "eth2", Value: 120},
+			{IfIndex: 2, Mib: "ifHCOutOctets", IfName: "eth0", Value: 120, Speed: 1_000_000_000},
+			{IfIndex: 3, Mib: "ifHCOutOctets", IfName: "eth1", Value: 120, Speed: 10_000_000_000},
+			{IfIndex: 4, Mib: "ifHCOutOctets", IfName: "eth2", Value: 120, Discontinuity: 500},
 		}
 		if d := cmp.Diff(
 			actual,
@@ -121,7 +343,7 @@ func TestDo(t *testing.T) {
 			MIBs:          []string{"ifHCInOctets", "ifHCOutOctets"},
 			IncludeRegexp: regexp.MustCompile("lo?"),
 		}
-		actual, err := do(t.Context(), &mockSnmpClient{}, conf)
+		actual, err := do(t.Context(), &mockSnmpClient{}, conf, mockIfDescr())
 		if err != nil {
 			t.Error("invalid raised error")
 		}
@@ -142,15 +364,15 @@ func TestDo(t *testing.T) {
 			MIBs:          []string{"ifHCInOctets", "ifHCOutOctets"},
 			ExcludeRegexp: regexp.MustCompile("0$"),
 		}
-		actual, err := do(t.Context(), &mockSnmpClient{}, conf)
+		actual, err := do(t.Context(), &mockSnmpClient{}, conf, mockIfDescr())
 		if err != nil {
 			t.Error("invalid raised error")
 		}
 		expected := []MetricsDutum{
-			{IfIndex: 3, Mib: "ifHCInOctets", IfName: "eth1", Value: 60},
-			{IfIndex: 4, Mib: "ifHCInOctets", IfName: "eth2", Value: 60},
-			{IfIndex: 3, Mib: "ifHCOutOctets", IfName: "eth1", Value: 120},
-			{IfIndex: 4, Mib: "ifHCOutOctets", IfName: "eth2", Value: 120},
+			{IfIndex: 3, Mib: "ifHCInOctets", IfName: "eth1", Value: 60, Speed: 10_000_000_000},
+			{IfIndex: 4, Mib: "ifHCInOctets", IfName: "eth2", Value: 60, Discontinuity: 500},
+			{IfIndex: 3, Mib: "ifHCOutOctets", IfName: "eth1", Value: 120, Speed: 10_000_000_000},
+			{IfIndex: 4, Mib: "ifHCOutOctets", IfName: "eth2", Value: 120, Discontinuity: 500},
 		}
 		if d := cmp.Diff(
 			actual,
@@ -166,17 +388,17 @@ func TestDo(t *testing.T) {
 			MIBs:              []string{"ifHCInOctets", "ifHCOutOctets"},
 			SkipDownLinkState: true,
 		}
-		actual, err := do(t.Context(), &mockSnmpClient{}, conf)
+		actual, err := do(t.Context(), &mockSnmpClient{}, conf, mockIfDescr())
 		if err != nil {
 			t.Error("invalid raised error")
 		}
 		expected := []MetricsDutum{
 			{IfIndex: 1, Mib: "ifHCInOctets", IfName: "lo0", Value: 60},
-			{IfIndex: 3, Mib: "ifHCInOctets", IfName: "eth1", Value: 60},
-			{IfIndex: 4, Mib: "ifHCInOctets", IfName: "eth2", Value: 60},
+			{IfIndex: 3, Mib: "ifHCInOctets", IfName: "eth1", Value: 60, Speed: 10_000_000_000},
+			{IfIndex: 4, Mib: "ifHCInOctets", IfName: "eth2", Value: 60, Discontinuity: 500},
 			{IfIndex: 1, Mib: "ifHCOutOctets", IfName: "lo0", Value: 120},
-			{IfIndex: 3, Mib: "ifHCOutOctets", IfName: "eth1", Value: 120},
-			{IfIndex: 4, Mib: "ifHCOutOctets", IfName: "eth2", Value: 120},
+			{IfIndex: 3, Mib: "ifHCOutOctets", IfName: "eth1", Value: 120, Speed: 10_000_000_000},
+			{IfIndex: 4, Mib: "ifHCOutOctets", IfName: "eth2", Value: 120, Discontinuity: 500},
 		}
 		if d := cmp.Diff(
 			actual,
@@ -187,6 +409,29 @@ func TestDo(t *testing.T) {
 		}
 	})
 
+	t.Run("skip down-linkstate keeps link state", func(t *testing.T) {
+		conf := &config.CollectorConfig{
+			MIBs:              []string{"ifOperStatus"},
+			SkipDownLinkState: true,
+		}
+		actual, err := do(t.Context(), &mockSnmpClient{}, conf, mockIfDescr())
+		if err != nil {
+			t.Error("invalid raised error")
+		}
+		expected := []MetricsDutum{
+			{IfIndex: 1, Mib: "ifOperStatus", IfName: "lo0", Value: 1},
+			{IfIndex: 2, Mib: "ifOperStatus", IfName: "eth0", Value: 2},
+			{IfIndex: 3, Mib: "ifOperStatus", IfName: "eth1", Value: 1},
+			{IfIndex: 4, Mib: "ifOperStatus", IfName: "eth2", Value: 1, Discontinuity: 500},
+		}
+		if d := cmp.Diff(
+			actual,
+			expected,
+			cmpopts.SortSlices(func(i, j MetricsDutum) bool { return i.String() < j.String() }),
+		); d != "" {
+			t.Errorf("invalid result %s", d)
+		}
+	})
 }
 
 func TestDoInterfaceIPAddress(t *testing.T) {
@@ -225,6 +470,34 @@ func TestDoInterfaceIPAddress(t *testing.T) {
 	}
 }
 
+func TestDoInterfaceAliases(t *testing.T) {
+	conf := &config.CollectorConfig{
+		InterfaceNameSources: []string{"ifName", "ifDescr"},
+		ExcludeRegexp:        regexp.MustCompile(`^Gi1/0/3$`),
+	}
+	ifNames, aliases, err := doInterfaceAliases(t.Context(), &mockSnmpClient{}, conf)
+	if err != nil {
+		t.Error("invalid raised error")
+	}
+	// 名前は絞り込む前のすべてのインターフェイスを返す
+	expectedNames := map[uint64]string{
+		1: "lo0",
+		2: "Gi1/0/1",
+		3: "Gi1/0/2",
+		4: "Gi1/0/3",
+	}
+	if d := cmp.Diff(ifNames, expectedNames); d != "" {
+		t.Errorf("invalid result %s", d)
+	}
+	// ifAlias が空のインターフェイスと、除外したインターフェイスは含めない
+	expectedAliases := map[uint64]string{
+		2: "uplink",
+	}
+	if d := cmp.Diff(aliases, expectedAliases); d != "" {
+		t.Errorf("invalid result %s", d)
+	}
+}
+
 func TestDoCustomMIBs(t *testing.T) {
 	conf := &config.CollectorConfig{
 		CustomMIBs: []string{"1.2.3.4.5.678901", "1.2.3.4.6.789012"},
@@ -233,13 +506,59 @@ func TestDoCustomMIBs(t *testing.T) {
 	if err != nil {
 		t.Error("invalid raised error")
 	}
-	expected := map[string]float64{
-		"1.2.3.4.5.678901": 678901,
-		"1.2.3.4.6.789012": 789012,
+	expected := map[string]snmp.Value{
+		"1.2.3.4.5.678901": {Float: 678901},
+		"1.2.3.4.6.789012": {Float: 789012},
+	}
+	if d := cmp.Diff(
+		actual,
+		expected,
+	); d != "" {
+		t.Errorf("invalid result %s", d)
+	}
+}
+
+func TestDoCustomMIBTables(t *testing.T) {
+	conf := &config.CollectorConfig{
+		CustomMIBTables: []*config.CustomMIBTable{
+			{
+				MetricNamePrefix: "custom.custommibs.cpu",
+				MIB:              "1.3.6.1.2.1.25.3.3.1.2",
+				NameMIB:          "1.3.6.1.2.1.25.3.2.1.3",
+			},
+			// 同じ列を参照する table は、行を重複させない
+			{
+				MetricNamePrefix: "custom.custommibs.cpu2",
+				MIB:              "1.3.6.1.2.1.25.3.3.1.2",
+				NameMIB:          "1.3.6.1.2.1.25.3.2.1.3",
+			},
+			{
+				MetricNamePrefix: "custom.custommibs.cpuindex",
+				MIB:              "1.3.6.1.2.1.25.3.3.1.2",
+			},
+		},
+	}
+	client := &mockSnmpClient{}
+	actual, err := doCustomMIBTables(t.Context(), client, conf)
+	if err != nil {
+		t.Error("invalid raised error")
+	}
+	expected := []CustomTableDutum{
+		{MIB: "1.3.6.1.2.1.25.3.3.1.2", NameMIB: "1.3.6.1.2.1.25.3.2.1.3", Index: "196608", Name: "CPU 0", Value: snmp.Value{Float: 10}},
+		{MIB: "1.3.6.1.2.1.25.3.3.1.2", NameMIB: "1.3.6.1.2.1.25.3.2.1.3", Index: "196609", Value: snmp.Value{Float: 20}},
+		{MIB: "1.3.6.1.2.1.25.3.3.1.2", Index: "196608", Value: snmp.Value{Float: 10}},
+		{MIB: "1.3.6.1.2.1.25.3.3.1.2", Index: "196609", Value: snmp.Value{Float: 20}},
+	}
+	// 列ごとに1回だけ取得する
+	if d := cmp.Diff(client.tableWalks, map[string]int{"1.3.6.1.2.1.25.3.3.1.2": 1, "1.3.6.1.2.1.25.3.2.1.3": 1}); d != "" {
+		t.Errorf("invalid walks %s", d)
 	}
 	if d := cmp.Diff(
 		actual,
 		expected,
+		cmpopts.SortSlices(func(i, j CustomTableDutum) bool {
+			return i.NameMIB+"/"+i.Index < j.NameMIB+"/"+j.Index
+		}),
 	); d != "" {
 		t.Errorf("invalid result %s", d)
 	}
@@ -1,20 +1,60 @@
 package collector
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/snmp"
+)
 
 type MetricsDutum struct {
 	IfIndex uint64 `json:"ifIndex"`
 	Mib     string `json:"mib"`
 	IfName  string `json:"ifName"`
 	Value   uint64 `json:"value"`
+	// オクテット数の場合のみ、インターフェイスの速度 (bps)。不明な場合は 0
+	Speed uint64 `json:"speed,omitempty"`
+	// ifCounterDiscontinuityTime (sysUpTime の値)。変化した場合はカウンタが連続していない
+	Discontinuity uint64 `json:"discontinuity,omitempty"`
 }
 
 func (m *MetricsDutum) String() string {
 	return fmt.Sprintf("%d\t%s\t%s\t%d", m.IfIndex, m.IfName, m.Mib, m.Value)
 }
 
+type CustomTableDutum struct {
+	MIB string `json:"mib"`
+	// 行の名前を取得した列。同じ MIB でも NameMIB が異なれば別の table とする
+	NameMIB string     `json:"nameMib,omitempty"`
+	Index   string     `json:"index"`
+	Name    string     `json:"name"`
+	Value   snmp.Value `json:"value"`
+}
+
 type Interface struct {
 	IfName     string
 	IpAddress  []string
 	MacAddress string
 }
+
+// Result は1回のポーリングで取得した値
+type Result struct {
+	// 値を取得した時刻
+	Time time.Time
+	// 1/100秒単位
+	SysUpTime uint64
+
+	Metrics []MetricsDutum
+	// ifIndex:インターフェイス名。include/exclude などで絞り込む前のすべてのインターフェイス
+	IfNames map[uint64]string
+	// mib:value
+	Custom map[string]snmp.Value
+	Tables []CustomTableDutum
+
+	// それぞれの取得に失敗した場合のエラー
+	// SysUpTimeErr が nil でない場合、SysUpTime は取得できておらず、インターフェイスのメトリックも取得していない
+	SysUpTimeErr error
+	MetricsErr   error
+	CustomErr    error
+	TablesErr    error
+}
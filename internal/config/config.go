@@ -2,6 +2,8 @@ package config
 
 import (
 	"cmp"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
@@ -10,6 +12,8 @@ import (
 
 	"github.com/mackerelio/mackerel-client-go"
 	"gopkg.in/yaml.v3"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/mib"
 )
 
 type yamlCollectorConfig struct {
@@ -25,6 +29,8 @@ type yamlCollectorConfig struct {
 	Timeout   string `yaml:"timeout"`
 	Retry     int    `yaml:"retry"`
 
+	MaxSessions int `yaml:"max-sessions,omitempty"`
+
 	SNMPv3 *yamlCollectorConfigSNMPv3 `yaml:"snmpv3"`
 
 	// for snmp/rule
@@ -32,36 +38,81 @@ type yamlCollectorConfig struct {
 	Mibs         []string       `yaml:"mibs,omitempty"`
 	SkipLinkdown bool           `yaml:"skip-linkdown,omitempty"`
 	CustomMibs   []*customMIB   `yaml:"custom-mibs,omitempty"`
+
+	// エラー数や破棄数も秒間の値として投稿する
+	CounterPerSecond bool `yaml:"counter-per-second,omitempty"`
+	// SNMP で応答があるかをチェック監視として投稿する
+	ReachabilityCheck bool `yaml:"reachability-check,omitempty"`
+
+	// 無指定の場合は全体の設定を使う
+	Interval         string `yaml:"interval,omitempty"`
+	MetadataInterval string `yaml:"metadata-interval,omitempty"`
 }
 
 type yamlDiskCache struct {
 	Directory string `yaml:"directory"`
 	Size      Size   `yaml:"size"`
+	// 送信キューに追加した値を、投稿が完了するまで directory/wal に記録する
+	WAL bool `yaml:"wal,omitempty"`
 }
 
 type yamlConfig struct {
 	ApiKey string `yaml:"x-api-key"`
 
+	MIBDirectory string `yaml:"mib-directory,omitempty"`
+	HTTPListen   string `yaml:"http-listen,omitempty"`
+	StatusListen string `yaml:"status-listen,omitempty"`
+
+	Interval         string `yaml:"interval,omitempty"`
+	MetadataInterval string `yaml:"metadata-interval,omitempty"`
+	// 同時に取得する collector 数の上限。0 の場合は上限なし
+	MaxConcurrentPolls int `yaml:"max-concurrent-polls,omitempty"`
+	// これより古いメトリックは投稿せずに破棄する
+	MaxAge string `yaml:"max-age,omitempty"`
+	// 1回の投稿にまとめるメトリック数の上限。無指定時は 500
+	PostBatchSize int `yaml:"post-batch-size,omitempty"`
+
 	Collector []*yamlCollectorConfig `yaml:"collector"`
 
 	DiskCache *yamlDiskCache `yaml:"disk-cache"`
+	SendQueue *yamlSendQueue `yaml:"send-queue,omitempty"`
+
+	SelfMonitoring *yamlSelfMonitoring `yaml:"self-monitoring,omitempty"`
+
+	Trap *yamlTrap `yaml:"trap,omitempty"`
+}
+
+type yamlSelfMonitoring struct {
+	HostID string `yaml:"host-id"`
 }
 
 type yamlInterface struct {
 	Include *string `yaml:"include,omitempty"`
 	Exclude *string `yaml:"exclude,omitempty"`
+	// インターフェイス名の取得元。先頭から順に、空でない値を使う
+	Name []string `yaml:"name,omitempty"`
+	// ifAlias をグラフの凡例に使う
+	Alias bool `yaml:"alias,omitempty"`
 }
 
 type customMIB struct {
 	DisplayName string                `yaml:"display-name"`
 	Unit        string                `yaml:"unit"`
 	Mibs        []*mibWithDisplayName `yaml:"mibs,omitempty"`
+	Table       *customMIBTable       `yaml:"table,omitempty"`
+}
+
+type customMIBTable struct {
+	MIB     string `yaml:"mib"`
+	NameMIB string `yaml:"name-mib,omitempty"`
+	Type    string `yaml:"type,omitempty"` // gauge, counter
 }
 
 type mibWithDisplayName struct {
 	DisplayName string `yaml:"display-name,omitempty"`
 	MetricName  string `yaml:"metric-name"`
 	MIB         string `yaml:"mib"`
+	Type        string `yaml:"type,omitempty"` // gauge, counter
 }
 
 type collectorSNMPConfigV2c struct {
@@ -73,6 +124,8 @@ type CollectorSNMPConfig struct {
 	Port    uint16
 	Timeout time.Duration
 	Retry   int
+	// 同じ機器に対して同時に張るセッションの上限
+	MaxSessions int
 
 	V2c *collectorSNMPConfigV2c
 	V3  *collectorSNMPConfigV3
@@ -87,15 +140,39 @@ type CollectorConfig struct {
 	SNMP CollectorSNMPConfig
 
 	// for snmp/rule
-	MIBs              []string
+	MIBs []string
+	// ifDescr, ifName, ifAlias のうち、インターフェイス名として優先して使うものから順に並べる
+	InterfaceNameSources []string
+	// ifAlias をグラフの凡例 (DisplayName) に使う
+	InterfaceAlias    bool
 	IncludeRegexp     *regexp.Regexp
 	ExcludeRegexp     *regexp.Regexp
 	SkipDownLinkState bool
+	// ifInErrors などのカウンタを custom.interface.<mib>PerSec.<インターフェイス名> に秒間の値として投稿する
+	CounterPerSecond bool
+	// 機器が SNMP に応答するかを snmp.reachability というチェック監視として投稿する
+	ReachabilityCheck bool
+
+	// メトリックを取得する間隔
+	Interval time.Duration
+	// ホスト情報を更新する間隔
+	MetadataInterval time.Duration
 
 	CustomMIBs          []string
 	CustomMIBsGraphDefs []*mackerel.GraphDefsParam
 	// metricName:mib
 	CustomMIBmetricNameMappedMIBs map[string]string
+	// counter として差分を秒間の値に変換する metricName
+	CustomMIBCounters []string
+	CustomMIBTables   []*CustomMIBTable
+}
+
+type CustomMIBTable struct {
+	// 行ごとに ".<行の名前>" を付与して Mackerel のメトリック名とする
+	MetricNamePrefix string
+	MIB              string
+	NameMIB          string
+	Counter          bool
 }
 
 func (conf *CollectorConfig) CollectorID() string {
@@ -105,13 +182,60 @@ func (conf *CollectorConfig) CollectorID() string {
 type DiskCache struct {
 	Directory string
 	Size      Size
+	// 有効な場合は Directory 以下の WALDirectory に記録する
+	WAL bool
 }
 
+const (
+	WALDirectory = "wal"
+	// 読み込めなかったキャッシュファイルの移動先
+	QuarantineDirectory = "quarantine"
+	// キャッシュファイルの拡張子
+	CacheFileSuffix = ".dat.gz"
+)
+
 type Config struct {
 	ApiKey string
 
+	// Prometheus 形式でメトリックを公開する場合のアドレス
+	HTTPListen string
+	// 稼働状況を JSON で公開する場合のアドレス
+	StatusListen string
+	// 設定ファイルの sha256
+	Checksum string
+
 	Collector []*CollectorConfig
 	DiskCache *DiskCache
+	// nil の場合は上限なし
+	SendQueue *SendQueue
+
+	// 同時に取得する collector 数の上限。0 の場合は上限なし
+	MaxConcurrentPolls int
+	// これより古いメトリックは投稿せずに破棄する。0 の場合は破棄しない
+	MaxAge time.Duration
+	// 同じホストの値を1回の投稿にまとめるメトリック数の上限
+	PostBatchSize int
+
+	// sabatrafficd 自身のメトリックを投稿するホストID。空なら投稿しない
+	SelfMonitoringHostID string
+
+	// トラップを受信しない場合は nil
+	Trap *TrapConfig
+}
+
+// parseInterval は間隔を解釈する。Mackerel のメトリックは1分単位のため、1分未満は指定できない
+func parseInterval(name, v string, defaultValue time.Duration) (time.Duration, error) {
+	if v == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s is invalid: %w", name, err)
+	}
+	if d < time.Minute {
+		return 0, fmt.Errorf("%s must be 1m or more : %s", name, v)
+	}
+	return d, nil
 }
 
 func Init(filename string) (*Config, error) {
@@ -124,7 +248,12 @@ func Init(filename string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	return convert(t)
+	conf, err := convert(t)
+	if err != nil {
+		return nil, err
+	}
+	conf.Checksum = fmt.Sprintf("%x", sha256.Sum256(f))
+	return conf, nil
 }
 
 func convert(t yamlConfig) (*Config, error) {
@@ -133,13 +262,56 @@ func convert(t yamlConfig) (*Config, error) {
 		return nil, fmt.Errorf("x-api-key is needed")
 	}
 
+	var modules *mib.Modules
+	if t.MIBDirectory != "" {
+		var err error
+		modules, err = mib.LoadModules(t.MIBDirectory)
+		if err != nil {
+			return nil, fmt.Errorf("failed load mib-directory: %w", err)
+		}
+	}
+
+	interval, err := parseInterval("interval", t.Interval, time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	metadataInterval, err := parseInterval("metadata-interval", t.MetadataInterval, 3*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.MaxConcurrentPolls < 0 {
+		return nil, fmt.Errorf("max-concurrent-polls must not be negative")
+	}
+	if t.PostBatchSize < 0 {
+		return nil, fmt.Errorf("post-batch-size must not be negative")
+	}
+
+	var maxAge time.Duration
+	if t.MaxAge != "" {
+		maxAge, err = time.ParseDuration(t.MaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("max-age is invalid: %w", err)
+		}
+		if maxAge < 0 {
+			return nil, fmt.Errorf("max-age must not be negative")
+		}
+	}
+
 	var cs []*CollectorConfig
 	for i := range t.Collector {
-		conf, err := convertCollector(t.Collector[i])
+		conf, err := convertCollector(t.Collector[i], modules)
+		// シンボル名の誤りは設定ファイルを直すまで解消しないため、起動しない
+		var unresolved *mib.UnresolvedError
+		if errors.As(err, &unresolved) {
+			return nil, fmt.Errorf("collector[%d]: module %q symbol %q: %w", i, unresolved.Module, unresolved.Symbol, err)
+		}
 		if err != nil {
 			slog.Warn("skipped because failed parse config", slog.Int("index", i), slog.String("error", err.Error()))
 			continue
 		}
+		conf.Interval = cmp.Or(conf.Interval, interval)
+		conf.MetadataInterval = cmp.Or(conf.MetadataInterval, metadataInterval)
 		cs = append(cs, conf)
 	}
 
@@ -152,9 +324,44 @@ func convert(t yamlConfig) (*Config, error) {
 		}
 	}
 
+	var sq *SendQueue
+	if t.SendQueue != nil {
+		sq, err = convertSendQueue(t.SendQueue, dc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var selfMonitoringHostID string
+	if t.SelfMonitoring != nil {
+		if t.SelfMonitoring.HostID == "" {
+			return nil, fmt.Errorf("self-monitoring.host-id is needed")
+		}
+		selfMonitoringHostID = t.SelfMonitoring.HostID
+	}
+
+	var trap *TrapConfig
+	if t.Trap != nil {
+		var err error
+		trap, err = convertTrap(t.Trap, modules)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &Config{
-		ApiKey:    apiKey,
-		Collector: cs,
-		DiskCache: dc,
+		ApiKey:       apiKey,
+		HTTPListen:   t.HTTPListen,
+		StatusListen: t.StatusListen,
+		Collector:    cs,
+		DiskCache:    dc,
+		SendQueue:    sq,
+
+		MaxConcurrentPolls: t.MaxConcurrentPolls,
+		MaxAge:             maxAge,
+		PostBatchSize:      cmp.Or(t.PostBatchSize, 500),
+
+		SelfMonitoringHostID: selfMonitoringHostID,
+		Trap:                 trap,
 	}, nil
 }
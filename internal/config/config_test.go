@@ -2,6 +2,8 @@ package config
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"regexp"
 	"testing"
 	"time"
@@ -114,6 +116,117 @@ func Test_generateCustomMIB(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			source: &customMIB{
+				DisplayName: "cpu",
+				Unit:        "percentage",
+				Table: &customMIBTable{
+					MIB:     "1.3.6.1.2.1.25.3.3.1.2",
+					NameMIB: "1.3.6.1.2.1.25.3.2.1.3",
+				},
+			},
+			expected: &customMIBConfig{
+				metricNameMappedMIBs: map[string]string{},
+				graphDefs: &mackerel.GraphDefsParam{
+					Name:        "custom.custommibs.d9747e2da342bdb995f6389533ad1a3d",
+					DisplayName: "cpu",
+					Unit:        "percentage",
+					Metrics: []*mackerel.GraphDefsMetric{
+						{
+							Name:        "custom.custommibs.d9747e2da342bdb995f6389533ad1a3d.*",
+							DisplayName: "%1",
+						},
+					},
+				},
+				table: &CustomMIBTable{
+					MetricNamePrefix: "custom.custommibs.d9747e2da342bdb995f6389533ad1a3d",
+					MIB:              "1.3.6.1.2.1.25.3.3.1.2",
+					NameMIB:          "1.3.6.1.2.1.25.3.2.1.3",
+				},
+			},
+		},
+		{
+			source: &customMIB{
+				Mibs: []*mibWithDisplayName{
+					{
+						MetricName: "foo.bar",
+						MIB:        "1.2.3.4",
+					},
+				},
+				Table: &customMIBTable{
+					MIB: "1.3.6.1.2.1.25.3.3.1.2",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			source: &customMIB{
+				Table: &customMIBTable{
+					MIB: "hrProcessorLoad",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			source: &customMIB{
+				Mibs: []*mibWithDisplayName{
+					{
+						MetricName: "foo.bar",
+						MIB:        "1.2.3.4",
+						Type:       "counter",
+					},
+					{
+						MetricName: "foo.baz",
+						MIB:        "5.6.7.8",
+						Type:       "gauge",
+					},
+				},
+			},
+			expected: &customMIBConfig{
+				customMIBs: []string{"1.2.3.4", "5.6.7.8"},
+				metricNameMappedMIBs: map[string]string{
+					"custom.custommibs.d41d8cd98f00b204e9800998ecf8427e.foo.bar": "1.2.3.4",
+					"custom.custommibs.d41d8cd98f00b204e9800998ecf8427e.foo.baz": "5.6.7.8",
+				},
+				counters: []string{"custom.custommibs.d41d8cd98f00b204e9800998ecf8427e.foo.bar"},
+				graphDefs: &mackerel.GraphDefsParam{
+					Name: "custom.custommibs.d41d8cd98f00b204e9800998ecf8427e",
+					Metrics: []*mackerel.GraphDefsMetric{
+						{
+							Name:        "custom.custommibs.d41d8cd98f00b204e9800998ecf8427e.foo.bar",
+							DisplayName: "foo.bar",
+						},
+						{
+							Name:        "custom.custommibs.d41d8cd98f00b204e9800998ecf8427e.foo.baz",
+							DisplayName: "foo.baz",
+						},
+					},
+				},
+			},
+		},
+		{
+			source: &customMIB{
+				Mibs: []*mibWithDisplayName{
+					{
+						MetricName: "foo.bar",
+						MIB:        "1.2.3.4",
+						Type:       "derive",
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			source: &customMIB{
+				Mibs: []*mibWithDisplayName{
+					{
+						MetricName: "foo.bar",
+						MIB:        "SNMPv2-MIB::sysUpTime.0",
+					},
+				},
+			},
+			wantErr: true,
+		},
 		{
 			source: &customMIB{
 				Mibs: []*mibWithDisplayName{
@@ -129,7 +242,7 @@ func Test_generateCustomMIB(t *testing.T) {
 
 	opt := cmp.AllowUnexported(customMIBConfig{})
 	for _, tc := range tests {
-		actual, err := generateCustomMIB(tc.source)
+		actual, err := generateCustomMIB(tc.source, nil)
 		if (err != nil) != tc.wantErr {
 			t.Error(err)
 		}
@@ -193,16 +306,141 @@ func Test_convert(t *testing.T) {
 							Port:    161,
 							Timeout: 10 * time.Second,
 							Retry:   3,
+
+							MaxSessions: 1,
+
 							V2c: &collectorSNMPConfigV2c{
 								Community: "public",
 							},
 						},
 						MIBs:                          []string{"ifHCInOctets", "ifHCOutOctets", "ifInDiscards", "ifOutDiscards", "ifInErrors", "ifOutErrors"},
+						InterfaceNameSources:          []string{"ifDescr"},
+						Interval:                      time.Minute,
+						MetadataInterval:              3 * time.Hour,
 						CustomMIBmetricNameMappedMIBs: map[string]string{},
 					},
 				},
+				PostBatchSize: 500,
 			},
 		},
+		{
+			source: yamlConfig{
+				ApiKey:           "cat",
+				Interval:         "5m",
+				MetadataInterval: "1h",
+				Collector: []*yamlCollectorConfig{
+					{
+						CustomIdentifier: "switch-001",
+						Community:        "public",
+						Host:             "192.0.2.1",
+					},
+					{
+						CustomIdentifier: "router-001",
+						Community:        "public",
+						Host:             "192.0.2.2",
+						Interval:         "1m",
+					},
+					{
+						CustomIdentifier: "invalid",
+						Community:        "public",
+						Host:             "192.0.2.3",
+						Interval:         "30s",
+					},
+				},
+			},
+			expected: &Config{
+				ApiKey: "cat",
+				Collector: []*CollectorConfig{
+					{
+						CustomIdentifier: "switch-001",
+						SNMP: CollectorSNMPConfig{
+							Host:    "192.0.2.1",
+							Port:    161,
+							Timeout: 10 * time.Second,
+							Retry:   3,
+
+							MaxSessions: 1,
+
+							V2c: &collectorSNMPConfigV2c{
+								Community: "public",
+							},
+						},
+						MIBs:                          []string{"ifHCInOctets", "ifHCOutOctets", "ifInDiscards", "ifOutDiscards", "ifInErrors", "ifOutErrors"},
+						InterfaceNameSources:          []string{"ifDescr"},
+						Interval:                      5 * time.Minute,
+						MetadataInterval:              time.Hour,
+						CustomMIBmetricNameMappedMIBs: map[string]string{},
+					},
+					{
+						CustomIdentifier: "router-001",
+						SNMP: CollectorSNMPConfig{
+							Host:    "192.0.2.2",
+							Port:    161,
+							Timeout: 10 * time.Second,
+							Retry:   3,
+
+							MaxSessions: 1,
+
+							V2c: &collectorSNMPConfigV2c{
+								Community: "public",
+							},
+						},
+						MIBs:                          []string{"ifHCInOctets", "ifHCOutOctets", "ifInDiscards", "ifOutDiscards", "ifInErrors", "ifOutErrors"},
+						InterfaceNameSources:          []string{"ifDescr"},
+						Interval:                      time.Minute,
+						MetadataInterval:              time.Hour,
+						CustomMIBmetricNameMappedMIBs: map[string]string{},
+					},
+				},
+				PostBatchSize: 500,
+			},
+		},
+		{
+			source: yamlConfig{
+				ApiKey:   "cat",
+				Interval: "30s",
+			},
+			wantErr: true,
+		},
+		{
+			source: yamlConfig{
+				ApiKey: "cat",
+				MaxAge: "-1h",
+			},
+			wantErr: true,
+		},
+		{
+			// 解決できないシンボル名は collector を読み飛ばさずにエラーとする
+			source: yamlConfig{
+				ApiKey: "cat",
+				Collector: []*yamlCollectorConfig{
+					{
+						HostID:    "panda",
+						Community: "public",
+						Host:      "192.0.2.1",
+						CustomMibs: []*customMIB{
+							{
+								DisplayName: "uptime",
+								Mibs:        []*mibWithDisplayName{{MetricName: "uptime", MIB: "SNMPv2-MIB::sysUpTime.0"}},
+							},
+						},
+					},
+					{
+						HostID:    "cat",
+						Community: "public",
+						Host:      "192.0.2.2",
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			source: yamlConfig{
+				ApiKey:        "cat",
+				PostBatchSize: -1,
+			},
+			wantErr: true,
+		},
 		{
 			source: yamlConfig{
 				Collector: []*yamlCollectorConfig{
@@ -241,15 +479,68 @@ func Test_convert(t *testing.T) {
 							Port:    161,
 							Timeout: 10 * time.Second,
 							Retry:   3,
+
+							MaxSessions: 1,
+
 							V2c: &collectorSNMPConfigV2c{
 								Community: "public",
 							},
 						},
 
 						MIBs:                          []string{"ifHCInOctets", "ifHCOutOctets", "ifInDiscards", "ifOutDiscards", "ifInErrors", "ifOutErrors"},
+						InterfaceNameSources:          []string{"ifDescr"},
+						Interval:                      time.Minute,
+						MetadataInterval:              3 * time.Hour,
 						CustomMIBmetricNameMappedMIBs: map[string]string{},
 					},
 				},
+				PostBatchSize: 500,
+			},
+		},
+		{
+			source: yamlConfig{
+				ApiKey: "cat",
+				Collector: []*yamlCollectorConfig{
+					{
+						HostID: "panda",
+
+						Community: "public",
+						Host:      "192.0.2.1",
+						Interface: &yamlInterface{
+							Name:  []string{"ifName", "ifDescr"},
+							Alias: true,
+						},
+					},
+				},
+			},
+			expected: &Config{
+				ApiKey: "cat",
+				Collector: []*CollectorConfig{
+					{
+						HostID: "panda",
+
+						SNMP: CollectorSNMPConfig{
+							Host:    "192.0.2.1",
+							Port:    161,
+							Timeout: 10 * time.Second,
+							Retry:   3,
+
+							MaxSessions: 1,
+
+							V2c: &collectorSNMPConfigV2c{
+								Community: "public",
+							},
+						},
+
+						MIBs:                          []string{"ifHCInOctets", "ifHCOutOctets", "ifInDiscards", "ifOutDiscards", "ifInErrors", "ifOutErrors"},
+						InterfaceNameSources:          []string{"ifName", "ifDescr"},
+						InterfaceAlias:                true,
+						Interval:                      time.Minute,
+						MetadataInterval:              3 * time.Hour,
+						CustomMIBmetricNameMappedMIBs: map[string]string{},
+					},
+				},
+				PostBatchSize: 500,
 			},
 		},
 		{
@@ -276,15 +567,22 @@ func Test_convert(t *testing.T) {
 							Port:    161,
 							Timeout: 10 * time.Second,
 							Retry:   3,
+
+							MaxSessions: 1,
+
 							V2c: &collectorSNMPConfigV2c{
 								Community: "public",
 							},
 						},
 
 						MIBs:                          []string{"ifHCInOctets", "ifHCOutOctets", "ifInDiscards", "ifOutDiscards", "ifInErrors", "ifOutErrors"},
+						InterfaceNameSources:          []string{"ifDescr"},
+						Interval:                      time.Minute,
+						MetadataInterval:              3 * time.Hour,
 						CustomMIBmetricNameMappedMIBs: map[string]string{},
 					},
 				},
+				PostBatchSize: 500,
 			},
 		},
 		{
@@ -311,14 +609,21 @@ func Test_convert(t *testing.T) {
 							Port:    161,
 							Timeout: 10 * time.Second,
 							Retry:   3,
+
+							MaxSessions: 1,
+
 							V2c: &collectorSNMPConfigV2c{
 								Community: "public",
 							},
 						},
 						MIBs:                          []string{"ifHCInOctets", "ifHCOutOctets"},
+						InterfaceNameSources:          []string{"ifDescr"},
+						Interval:                      time.Minute,
+						MetadataInterval:              3 * time.Hour,
 						CustomMIBmetricNameMappedMIBs: map[string]string{},
 					},
 				},
+				PostBatchSize: 500,
 			},
 		},
 		{
@@ -348,15 +653,22 @@ func Test_convert(t *testing.T) {
 							Port:    161,
 							Timeout: 10 * time.Second,
 							Retry:   3,
+
+							MaxSessions: 1,
+
 							V2c: &collectorSNMPConfigV2c{
 								Community: "public",
 							},
 						},
 						MIBs:                          []string{"ifHCInOctets", "ifHCOutOctets"},
+						InterfaceNameSources:          []string{"ifDescr"},
+						Interval:                      time.Minute,
+						MetadataInterval:              3 * time.Hour,
 						CustomMIBmetricNameMappedMIBs: map[string]string{},
 						IncludeRegexp:                 regexp.MustCompile(reg),
 					},
 				},
+				PostBatchSize: 500,
 			},
 		},
 		{
@@ -386,15 +698,22 @@ func Test_convert(t *testing.T) {
 							Port:    161,
 							Timeout: 10 * time.Second,
 							Retry:   3,
+
+							MaxSessions: 1,
+
 							V2c: &collectorSNMPConfigV2c{
 								Community: "public",
 							},
 						},
 						MIBs:                          []string{"ifHCInOctets", "ifHCOutOctets"},
+						InterfaceNameSources:          []string{"ifDescr"},
+						Interval:                      time.Minute,
+						MetadataInterval:              3 * time.Hour,
 						CustomMIBmetricNameMappedMIBs: map[string]string{},
 						ExcludeRegexp:                 regexp.MustCompile(reg),
 					},
 				},
+				PostBatchSize: 500,
 			},
 		},
 		{
@@ -448,17 +767,24 @@ func Test_convert(t *testing.T) {
 							Port:    161,
 							Timeout: 10 * time.Second,
 							Retry:   3,
+
+							MaxSessions: 1,
+
 							V2c: &collectorSNMPConfigV2c{
 								Community: "public",
 							},
 						},
 						MIBs:                          []string{"ifHCInOctets", "ifHCOutOctets"},
+						InterfaceNameSources:          []string{"ifDescr"},
+						Interval:                      time.Minute,
+						MetadataInterval:              3 * time.Hour,
 						CustomMIBmetricNameMappedMIBs: map[string]string{},
 
 						HostID:   "panda",
 						HostName: "dog",
 					},
 				},
+				PostBatchSize: 500,
 			},
 		},
 		{
@@ -499,11 +825,17 @@ func Test_convert(t *testing.T) {
 							Port:    10161,
 							Timeout: 10 * time.Second,
 							Retry:   3,
+
+							MaxSessions: 1,
+
 							V2c: &collectorSNMPConfigV2c{
 								Community: "public",
 							},
 						},
-						MIBs: []string{"ifHCInOctets", "ifHCOutOctets"},
+						MIBs:                 []string{"ifHCInOctets", "ifHCOutOctets"},
+						InterfaceNameSources: []string{"ifDescr"},
+						Interval:             time.Minute,
+						MetadataInterval:     3 * time.Hour,
 						CustomMIBmetricNameMappedMIBs: map[string]string{
 							"custom.custommibs.d2cbe65f53da8607e64173c1a83394fe.foo.bar": "1.2.34.56",
 						},
@@ -523,6 +855,7 @@ func Test_convert(t *testing.T) {
 						},
 					},
 				},
+				PostBatchSize: 500,
 			},
 		},
 		{
@@ -557,6 +890,8 @@ func Test_convert(t *testing.T) {
 							Timeout: 10 * time.Second,
 							Retry:   3,
 
+							MaxSessions: 1,
+
 							V3: &collectorSNMPConfigV3{
 								secLevel:                 "priv",
 								usename:                  "user",
@@ -568,9 +903,13 @@ func Test_convert(t *testing.T) {
 						},
 
 						MIBs:                          []string{"ifHCInOctets", "ifHCOutOctets", "ifInDiscards", "ifOutDiscards", "ifInErrors", "ifOutErrors"},
+						InterfaceNameSources:          []string{"ifDescr"},
+						Interval:                      time.Minute,
+						MetadataInterval:              3 * time.Hour,
 						CustomMIBmetricNameMappedMIBs: map[string]string{},
 					},
 				},
+				PostBatchSize: 500,
 			},
 		},
 	}
@@ -641,3 +980,180 @@ func Test_snmpProtocolVersion(t *testing.T) {
 		}
 	}
 }
+
+func Test_convertTrap(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    *yamlTrap
+		expected *TrapConfig
+		wantErr  bool
+	}{
+		{
+			name:  "default",
+			input: &yamlTrap{Community: "public"},
+			expected: &TrapConfig{
+				Listen:    "0.0.0.0:162",
+				Community: "public",
+			},
+		},
+		{
+			name: "rules",
+			input: &yamlTrap{
+				Listen:    "127.0.0.1:10162",
+				Community: "public",
+				Rules: []*yamlTrapRule{
+					{OID: "1.3.6.1.4.1.9.9.41.2.0.1", Name: "syslog"},
+					{OID: "1.3.6.1.4.1.9.9.13.3.0.5", Name: "fan", Status: "CRITICAL"},
+				},
+			},
+			expected: &TrapConfig{
+				Listen:    "127.0.0.1:10162",
+				Community: "public",
+				Rules: []*TrapRule{
+					{OID: "1.3.6.1.4.1.9.9.41.2.0.1", Name: "syslog", Status: mackerel.CheckStatusWarning},
+					{OID: "1.3.6.1.4.1.9.9.13.3.0.5", Name: "fan", Status: mackerel.CheckStatusCritical},
+				},
+			},
+		},
+		{
+			name:    "no community",
+			input:   &yamlTrap{},
+			wantErr: true,
+		},
+		{
+			name: "no rule name",
+			input: &yamlTrap{
+				Community: "public",
+				Rules:     []*yamlTrapRule{{OID: "1.3.6.1.4.1.9.9.41.2.0.1"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid status",
+			input: &yamlTrap{
+				Community: "public",
+				Rules:     []*yamlTrapRule{{OID: "1.3.6.1.4.1.9.9.41.2.0.1", Name: "syslog", Status: "ERROR"}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := convertTrap(tc.input, nil)
+			if (err != nil) != tc.wantErr {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(actual, tc.expected); diff != "" {
+				t.Errorf("value is mismatch (-actual +expected):%s", diff)
+			}
+		})
+	}
+}
+
+func Test_diskcacheValidate(t *testing.T) {
+	size := Size{size: 10 * 1000 * 1000}
+
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, WALDirectory), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// 前回の記録が残っていても、wal が有効なら利用できる
+	if _, err := diskcacheValidate(&yamlDiskCache{Directory: dir, Size: size, WAL: true}); err != nil {
+		t.Error(err)
+	}
+	// wal を無効にした後に残った記録があっても、disk-cache は無効にしない
+	if _, err := diskcacheValidate(&yamlDiskCache{Directory: dir, Size: size}); err != nil {
+		t.Error(err)
+	}
+
+	// 前回の起動時のキャッシュファイルは読み込むため許容する
+	if err := os.WriteFile(filepath.Join(dir, "1700000000000.dat.gz"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, QuarantineDirectory), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := diskcacheValidate(&yamlDiskCache{Directory: dir, Size: size, WAL: true}); err != nil {
+		t.Error(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := diskcacheValidate(&yamlDiskCache{Directory: dir, Size: size, WAL: true}); err == nil {
+		t.Error("directory with unknown files should be rejected")
+	}
+}
+
+func Test_convertSendQueue(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   yamlSendQueue
+		dc       *DiskCache
+		expected *SendQueue
+		wantErr  bool
+	}{
+		{
+			name:     "default",
+			source:   yamlSendQueue{MaxItems: 100},
+			expected: &SendQueue{MaxItems: 100, Overflow: OverflowDropOldest},
+		},
+		{
+			name:     "spill-to-disk",
+			source:   yamlSendQueue{MaxBytes: Size{size: 1000}, Overflow: "spill-to-disk"},
+			dc:       &DiskCache{},
+			expected: &SendQueue{MaxBytes: 1000, Overflow: OverflowSpillToDisk},
+		},
+		{
+			name:     "spill-to-disk without disk-cache",
+			source:   yamlSendQueue{MaxItems: 100, Overflow: "spill-to-disk"},
+			expected: &SendQueue{MaxItems: 100, Overflow: OverflowDropOldest},
+		},
+		{
+			name:    "invalid overflow",
+			source:  yamlSendQueue{MaxItems: 100, Overflow: "drop-random"},
+			wantErr: true,
+		},
+		{
+			name:    "negative max-items",
+			source:  yamlSendQueue{MaxItems: -1},
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := convertSendQueue(&tc.source, tc.dc)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(actual, tc.expected); diff != "" {
+				t.Errorf("value is mismatch (-actual +expected):%s", diff)
+			}
+		})
+	}
+}
+
+func Test_convertCollectorDuplicatedTable(t *testing.T) {
+	collector := func(customMIBs ...*customMIB) *yamlCollectorConfig {
+		return &yamlCollectorConfig{
+			HostID:     "panda",
+			Community:  "public",
+			Host:       "192.0.2.1",
+			CustomMibs: customMIBs,
+		}
+	}
+	table := &customMIB{DisplayName: "cpu", Table: &customMIBTable{MIB: "1.3.6.1.2.1.25.3.3.1.2"}}
+	scalar := &customMIB{DisplayName: "cpu", Mibs: []*mibWithDisplayName{{MetricName: "total", MIB: "1.2.3.4"}}}
+	other := &customMIB{DisplayName: "cpu name", Table: &customMIBTable{MIB: "1.3.6.1.2.1.25.3.3.1.2", NameMIB: "1.3.6.1.2.1.25.3.2.1.3"}}
+
+	if _, err := convertCollector(collector(table, other), nil); err != nil {
+		t.Error(err)
+	}
+	if _, err := convertCollector(collector(table, table), nil); err == nil {
+		t.Error("tables with the same display-name should be rejected")
+	}
+	if _, err := convertCollector(collector(scalar, table), nil); err == nil {
+		t.Error("a table with the same display-name as mibs should be rejected")
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 func diskcacheValidate(ydc *yamlDiskCache) (*DiskCache, error) {
@@ -23,17 +26,29 @@ func diskcacheValidate(ydc *yamlDiskCache) (*DiskCache, error) {
 	}
 	defer root.Close() // nolint
 
-	// 空ディレクトリであることを確認する
+	// 前回の起動時のキャッシュファイルなど、sabatrafficd が作成するもののみであることを確認する
+	// WALDirectory は wal を無効にした後も残るため、wal の設定によらず許容する
 	dot, err := os.Open(ydc.Directory)
 	if err != nil {
 		return nil, fmt.Errorf("disable disk-cache: %s", err.Error())
 	}
 	defer dot.Close() // nolint
 
-	// ディレクトリが空であれば、エントリ数が0になり、io.EOFを返す
-	entry, err := dot.ReadDir(1)
-	if len(entry) > 0 || !errors.Is(err, io.EOF) {
-		return nil, fmt.Errorf("disable disk-cache: %s is not empty directory", ydc.Directory)
+	for {
+		// ディレクトリを読み切ると、エントリ数が0になり、io.EOFを返す
+		entry, err := dot.ReadDir(1)
+		if len(entry) == 0 && errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("disable disk-cache: %s", err.Error())
+		}
+		if !knownEntry(entry[0]) {
+			return nil, fmt.Errorf("disable disk-cache: %s contains unknown entry %s", ydc.Directory, entry[0].Name())
+		}
+		if entry[0].IsDir() && entry[0].Name() == WALDirectory && !ydc.WAL {
+			slog.Warn("wal is disabled, so the journal left in the disk-cache directory is not replayed", slog.String("directory", filepath.Join(ydc.Directory, WALDirectory)))
+		}
 	}
 
 	// ファイルの読み書き試験
@@ -56,5 +71,13 @@ func diskcacheValidate(ydc *yamlDiskCache) (*DiskCache, error) {
 	return &DiskCache{
 		Directory: ydc.Directory,
 		Size:      ydc.Size,
+		WAL:       ydc.WAL,
 	}, nil
 }
+
+func knownEntry(entry fs.DirEntry) bool {
+	if entry.IsDir() {
+		return entry.Name() == QuarantineDirectory || entry.Name() == WALDirectory
+	}
+	return strings.HasSuffix(entry.Name(), CacheFileSuffix)
+}
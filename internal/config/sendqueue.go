@@ -0,0 +1,64 @@
+package config
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+type yamlSendQueue struct {
+	MaxItems int    `yaml:"max-items,omitempty"`
+	MaxBytes Size   `yaml:"max-bytes,omitempty"`
+	Overflow string `yaml:"overflow,omitempty"`
+}
+
+type OverflowPolicy string
+
+const (
+	// 古い値から破棄する
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// 追加しようとした値を破棄する
+	OverflowDropNewest OverflowPolicy = "drop-newest"
+	// 古い値からディスクキャッシュに書き出す
+	OverflowSpillToDisk OverflowPolicy = "spill-to-disk"
+)
+
+var overflowPolicies = []OverflowPolicy{
+	OverflowDropOldest,
+	OverflowDropNewest,
+	OverflowSpillToDisk,
+}
+
+// SendQueue はメモリ上の送信キューの上限
+type SendQueue struct {
+	// 0 の場合は上限なし
+	MaxItems int
+	// メトリックの大きさの概算による上限。0 の場合は上限なし
+	MaxBytes int64
+	Overflow OverflowPolicy
+}
+
+func convertSendQueue(t *yamlSendQueue, dc *DiskCache) (*SendQueue, error) {
+	if t.MaxItems < 0 {
+		return nil, fmt.Errorf("send-queue.max-items must not be negative")
+	}
+	if t.MaxBytes.Size() < 0 {
+		return nil, fmt.Errorf("send-queue.max-bytes must not be negative")
+	}
+
+	overflow := OverflowPolicy(cmp.Or(t.Overflow, string(OverflowDropOldest)))
+	if !slices.Contains(overflowPolicies, overflow) {
+		return nil, fmt.Errorf("send-queue.overflow is invalid : %s", t.Overflow)
+	}
+	if overflow == OverflowSpillToDisk && dc == nil {
+		slog.Warn("use drop-oldest because disk-cache is disabled", slog.String("overflow", string(overflow)))
+		overflow = OverflowDropOldest
+	}
+
+	return &SendQueue{
+		MaxItems: t.MaxItems,
+		MaxBytes: t.MaxBytes.Size(),
+		Overflow: overflow,
+	}, nil
+}
@@ -29,7 +29,7 @@ func snmpProtocolVersion(v string) (string, error) {
 	return "", fmt.Errorf("invalid snmp protocol version (v2c, v3) : %s", v)
 }
 
-func convertCollector(t *yamlCollectorConfig) (*CollectorConfig, error) {
+func convertCollector(t *yamlCollectorConfig, modules *mib.Modules) (*CollectorConfig, error) {
 	if t.Host == "" {
 		return nil, fmt.Errorf("host is needed")
 	}
@@ -44,12 +44,26 @@ func convertCollector(t *yamlCollectorConfig) (*CollectorConfig, error) {
 	if err != nil {
 		return nil, err
 	}
+	// 全体の設定で補うため、無指定の場合は 0 とする
+	interval, err := parseInterval("interval", t.Interval, 0)
+	if err != nil {
+		return nil, err
+	}
+	metadataInterval, err := parseInterval("metadata-interval", t.MetadataInterval, 0)
+	if err != nil {
+		return nil, err
+	}
+	if t.MaxSessions < 0 {
+		return nil, fmt.Errorf("max-sessions must not be negative")
+	}
 
 	snmpConfig := CollectorSNMPConfig{
 		Host:    t.Host,
 		Port:    cmp.Or(t.Port, 161),
 		Timeout: timeout,
 		Retry:   cmp.Or(t.Retry, 3),
+
+		MaxSessions: cmp.Or(t.MaxSessions, 1),
 	}
 
 	version, err := snmpProtocolVersion(t.Version)
@@ -68,22 +82,9 @@ func convertCollector(t *yamlCollectorConfig) (*CollectorConfig, error) {
 		if t.SNMPv3 == nil {
 			return nil, fmt.Errorf("snmpv3 not found")
 		}
-		if ok := parseSeurity(t.SNMPv3.SecLevel); !ok {
-			return nil, fmt.Errorf("snmpv3.security is invalid : %s", t.SNMPv3.SecLevel)
-		}
-		if ok := parseAuthenticationProtocol(t.SNMPv3.AuthenticationProtocol); !ok {
-			return nil, fmt.Errorf("snmpv3.auth-protocol is invalid : %s", t.SNMPv3.AuthenticationProtocol)
-		}
-		if ok := parsePrivacyProtocol(t.SNMPv3.PrivacyProtocol); !ok {
-			return nil, fmt.Errorf("snmpv3.priv-protocol is invalid : %s", t.SNMPv3.PrivacyProtocol)
-		}
-		snmpConfig.V3 = &collectorSNMPConfigV3{
-			secLevel:                 t.SNMPv3.SecLevel,
-			usename:                  t.SNMPv3.UserName,
-			authenticationProtocol:   t.SNMPv3.AuthenticationProtocol,
-			authenticationPassphrase: t.SNMPv3.AuthenticationPassphrase,
-			privacyProtocol:          t.SNMPv3.PrivacyProtocol,
-			privacyPassphrase:        t.SNMPv3.PrivacyPassphrase,
+		snmpConfig.V3, err = convertSNMPv3(t.SNMPv3)
+		if err != nil {
+			return nil, err
 		}
 	}
 
@@ -95,6 +96,10 @@ func convertCollector(t *yamlCollectorConfig) (*CollectorConfig, error) {
 		SNMP: snmpConfig,
 
 		SkipDownLinkState:             t.SkipLinkdown,
+		CounterPerSecond:              t.CounterPerSecond,
+		ReachabilityCheck:             t.ReachabilityCheck,
+		Interval:                      interval,
+		MetadataInterval:              metadataInterval,
 		CustomMIBmetricNameMappedMIBs: map[string]string{},
 	}
 
@@ -116,6 +121,16 @@ func convertCollector(t *yamlCollectorConfig) (*CollectorConfig, error) {
 		}
 	}
 
+	var interfaceNames []string
+	if t.Interface != nil {
+		interfaceNames = t.Interface.Name
+		c.InterfaceAlias = t.Interface.Alias
+	}
+	c.InterfaceNameSources, err = mib.ValidateInterfaceNames(interfaceNames)
+	if err != nil {
+		return nil, err
+	}
+
 	c.MIBs, err = mib.Validate(t.Mibs)
 	if err != nil {
 		return nil, err
@@ -123,13 +138,25 @@ func convertCollector(t *yamlCollectorConfig) (*CollectorConfig, error) {
 	// Reload 処理で差分を抑制するためのソート
 	slices.Sort(c.MIBs)
 
+	// graphDefs.Name:table か
+	prefixes := make(map[string]bool, len(t.CustomMibs))
 	for i := range t.CustomMibs {
-		res, err := generateCustomMIB(t.CustomMibs[i])
+		res, err := generateCustomMIB(t.CustomMibs[i], modules)
 		if err != nil {
 			return nil, err
 		}
+		// table は display-name から作るメトリック名の接頭辞で行を区別するため、重複を許さない
+		table, exists := prefixes[res.graphDefs.Name]
+		if exists && (table || res.table != nil) {
+			return nil, fmt.Errorf("custom-mibs.display-name is duplicated with a table : %s", t.CustomMibs[i].DisplayName)
+		}
+		prefixes[res.graphDefs.Name] = table || res.table != nil
 		c.CustomMIBs = append(c.CustomMIBs, res.customMIBs...)
+		c.CustomMIBCounters = append(c.CustomMIBCounters, res.counters...)
 		c.CustomMIBsGraphDefs = append(c.CustomMIBsGraphDefs, res.graphDefs)
+		if res.table != nil {
+			c.CustomMIBTables = append(c.CustomMIBTables, res.table)
+		}
 		for metricName, mib := range res.metricNameMappedMIBs {
 			c.CustomMIBmetricNameMappedMIBs[metricName] = mib
 		}
@@ -153,12 +180,20 @@ type customMIBConfig struct {
 
 	// metricName:MIB
 	metricNameMappedMIBs map[string]string
+	counters             []string
 
 	graphDefs *mackerel.GraphDefsParam
+
+	table *CustomMIBTable
 }
 
-func generateCustomMIB(t *customMIB) (*customMIBConfig, error) {
+func generateCustomMIB(t *customMIB, modules *mib.Modules) (*customMIBConfig, error) {
+	if t.Table != nil {
+		return generateCustomMIBTable(t, modules)
+	}
+
 	var customMIBs []string
+	var counters []string
 	var metrics []*mackerel.GraphDefsMetric
 	var metricNameMappedMIBs = make(map[string]string, 0)
 
@@ -174,13 +209,21 @@ func generateCustomMIB(t *customMIB) (*customMIBConfig, error) {
 			DisplayName: cmp.Or(t.Mibs[idx].DisplayName, t.Mibs[idx].MetricName),
 		})
 
-		err := mib.ValidateCustom(t.Mibs[idx].MIB)
+		oid, err := mib.ResolveCustom(t.Mibs[idx].MIB, modules)
 		if err != nil {
 			return nil, err
 		}
-		customMIBs = append(customMIBs, t.Mibs[idx].MIB)
+		customMIBs = append(customMIBs, oid)
+
+		metricNameMappedMIBs[mackerelMetricName] = oid
 
-		metricNameMappedMIBs[mackerelMetricName] = t.Mibs[idx].MIB
+		counter, err := isCounterType(t.Mibs[idx].Type)
+		if err != nil {
+			return nil, err
+		}
+		if counter {
+			counters = append(counters, mackerelMetricName)
+		}
 	}
 
 	return &customMIBConfig{
@@ -192,5 +235,89 @@ func generateCustomMIB(t *customMIB) (*customMIBConfig, error) {
 		},
 		customMIBs:           customMIBs,
 		metricNameMappedMIBs: metricNameMappedMIBs,
+		counters:             counters,
+	}, nil
+}
+
+const (
+	customMIBTypeGauge   = "gauge"
+	customMIBTypeCounter = "counter"
+)
+
+func isCounterType(v string) (bool, error) {
+	switch v {
+	case "", customMIBTypeGauge:
+		return false, nil
+	case customMIBTypeCounter:
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid custom-mibs type (gauge, counter) : %s", v)
+}
+
+func generateCustomMIBTable(t *customMIB, modules *mib.Modules) (*customMIBConfig, error) {
+	if len(t.Mibs) > 0 {
+		return nil, fmt.Errorf("custom-mibs.mibs, custom-mibs.table is exclusive")
+	}
+	if t.Table.MIB == "" {
+		return nil, fmt.Errorf("custom-mibs.table.mib is needed")
+	}
+
+	column, err := mib.ResolveCustom(t.Table.MIB, modules)
+	if err != nil {
+		return nil, err
+	}
+	counter, err := isCounterType(t.Table.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	var nameColumn string
+	if t.Table.NameMIB != "" {
+		nameColumn, err = mib.ResolveCustom(t.Table.NameMIB, modules)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	prefix := customMIBMackerelMetricNameParent(t.DisplayName)
+	return &customMIBConfig{
+		graphDefs: &mackerel.GraphDefsParam{
+			Name:        prefix,
+			Unit:        t.Unit,
+			DisplayName: t.DisplayName,
+			Metrics: []*mackerel.GraphDefsMetric{
+				{
+					Name:        prefix + ".*",
+					DisplayName: "%1",
+				},
+			},
+		},
+		metricNameMappedMIBs: map[string]string{},
+		table: &CustomMIBTable{
+			MetricNamePrefix: prefix,
+			MIB:              column,
+			NameMIB:          nameColumn,
+			Counter:          counter,
+		},
+	}, nil
+}
+
+func convertSNMPv3(t *yamlCollectorConfigSNMPv3) (*collectorSNMPConfigV3, error) {
+	if ok := parseSeurity(t.SecLevel); !ok {
+		return nil, fmt.Errorf("snmpv3.security is invalid : %s", t.SecLevel)
+	}
+	if ok := parseAuthenticationProtocol(t.AuthenticationProtocol); !ok {
+		return nil, fmt.Errorf("snmpv3.auth-protocol is invalid : %s", t.AuthenticationProtocol)
+	}
+	if ok := parsePrivacyProtocol(t.PrivacyProtocol); !ok {
+		return nil, fmt.Errorf("snmpv3.priv-protocol is invalid : %s", t.PrivacyProtocol)
+	}
+	return &collectorSNMPConfigV3{
+		secLevel:                 t.SecLevel,
+		usename:                  t.UserName,
+		authenticationProtocol:   t.AuthenticationProtocol,
+		authenticationPassphrase: t.AuthenticationPassphrase,
+		privacyProtocol:          t.PrivacyProtocol,
+		privacyPassphrase:        t.PrivacyPassphrase,
 	}, nil
 }
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/mackerelio/mackerel-client-go"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/mib"
+)
+
+type yamlTrap struct {
+	Listen    string                     `yaml:"listen,omitempty"`
+	Community string                     `yaml:"community,omitempty"`
+	SNMPv3    *yamlCollectorConfigSNMPv3 `yaml:"snmpv3,omitempty"`
+	Rules     []*yamlTrapRule            `yaml:"rules,omitempty"`
+}
+
+type yamlTrapRule struct {
+	OID    string `yaml:"oid"`
+	Name   string `yaml:"name"`
+	Status string `yaml:"status,omitempty"`
+}
+
+type TrapConfig struct {
+	Listen string
+
+	// 空の場合は v2c のトラップを受け付けない
+	Community string
+	// nil の場合は v3 のトラップを受け付けない
+	V3 *collectorSNMPConfigV3
+
+	// linkDown, linkUp, coldStart, warmStart 以外に通知するトラップ
+	Rules []*TrapRule
+}
+
+type TrapRule struct {
+	// snmpTrapOID の値
+	OID string
+	// チェック監視の名前
+	Name   string
+	Status mackerel.CheckStatus
+}
+
+var checkStatuses = []mackerel.CheckStatus{
+	mackerel.CheckStatusOK,
+	mackerel.CheckStatusWarning,
+	mackerel.CheckStatusCritical,
+	mackerel.CheckStatusUnknown,
+}
+
+func convertTrap(t *yamlTrap, modules *mib.Modules) (*TrapConfig, error) {
+	if t.Community == "" && t.SNMPv3 == nil {
+		return nil, fmt.Errorf("trap.community or trap.snmpv3 is needed")
+	}
+
+	c := &TrapConfig{
+		Listen:    cmp.Or(t.Listen, "0.0.0.0:162"),
+		Community: t.Community,
+	}
+	if t.SNMPv3 != nil {
+		var err error
+		c.V3, err = convertSNMPv3(t.SNMPv3)
+		if err != nil {
+			return nil, fmt.Errorf("trap.%w", err)
+		}
+	}
+
+	for _, rule := range t.Rules {
+		oid, err := mib.ResolveCustom(rule.OID, modules)
+		if err != nil {
+			return nil, err
+		}
+		if rule.Name == "" {
+			return nil, fmt.Errorf("trap.rules.name is needed : %s", rule.OID)
+		}
+		status := mackerel.CheckStatus(cmp.Or(rule.Status, string(mackerel.CheckStatusWarning)))
+		if !slices.Contains(checkStatuses, status) {
+			return nil, fmt.Errorf("trap.rules.status is invalid : %s", rule.Status)
+		}
+		c.Rules = append(c.Rules, &TrapRule{OID: oid, Name: rule.Name, Status: status})
+	}
+	return c, nil
+}
@@ -1,18 +1,22 @@
 package diskcache
 
 import (
+	"cmp"
 	"compress/gzip"
 	"container/list"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/mackerelio/mackerel-client-go"
-
 	"github.com/mackerelio-labs/sabatrafficd/internal/config"
 	"github.com/mackerelio-labs/sabatrafficd/internal/sendqueue"
 )
@@ -27,9 +31,17 @@ type DiskCache struct {
 	filelist   *list.List
 	totalBytes int64
 	totalItems int
+	// 最後に作成したファイルの時刻 (unix milli)。ファイル名に使う
+	lastCreated int64
 
 	fileMu    sync.Mutex
 	filequeue *list.List
+	// 読み込み中のファイル。読み込んだ値が全て投稿されるまで削除しない
+	reading *cacheEntry
+	// reading から読み込み、まだ Ack されていない値の数
+	pending int
+
+	expiry *sendqueue.Expiry
 }
 
 type cacheEntry struct {
@@ -41,6 +53,8 @@ type cacheEntry struct {
 type queue interface {
 	FrontN(length int) []sendqueue.Item
 	Len() int
+	// ファイルに書き出した値を通知する
+	Ack(items ...sendqueue.Item)
 }
 
 const limit = 1000
@@ -54,14 +68,107 @@ func New(q queue, conf *config.DiskCache) (*DiskCache, error) {
 		return nil, fmt.Errorf("disable disk-cache: %s", err.Error())
 	}
 
-	return &DiskCache{
+	dc := &DiskCache{
 		root:  root,
 		queue: q,
 		conf:  conf,
 
 		filelist:  list.New(),
 		filequeue: list.New(),
-	}, nil
+	}
+	if err = dc.recover(); err != nil {
+		root.Close() // nolint
+		return nil, fmt.Errorf("disable disk-cache: %s", err.Error())
+	}
+	return dc, nil
+}
+
+// recover は前回の起動時に残ったキャッシュファイルを、作成した順に送信対象に加える
+// 読み込めないファイルは QuarantineDirectory に移動する
+func (dc *DiskCache) recover() error {
+	entries, err := fs.ReadDir(dc.root.FS(), ".")
+	if err != nil {
+		return err
+	}
+
+	type cacheFile struct {
+		name      string
+		createdAt int64 // unix milli
+	}
+	var files []cacheFile
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), config.CacheFileSuffix)
+		if entry.IsDir() || !ok {
+			continue
+		}
+		createdAt, err := strconv.ParseInt(name, 10, 64)
+		if err != nil {
+			dc.quarantine(entry.Name(), err)
+			continue
+		}
+		files = append(files, cacheFile{name: entry.Name(), createdAt: createdAt})
+	}
+	slices.SortFunc(files, func(a, b cacheFile) int {
+		return cmp.Compare(a.createdAt, b.createdAt)
+	})
+
+	for _, file := range files {
+		items, err := dc.load(file.name)
+		if err != nil {
+			dc.quarantine(file.name, err)
+			continue
+		}
+		st, err := dc.root.Stat(file.name)
+		if err != nil {
+			return err
+		}
+		dc.filelist.PushBack(cacheEntry{filename: file.name, bytes: st.Size(), items: len(items)})
+		dc.lastCreated = file.createdAt
+		dc.totalBytes += st.Size()
+		dc.totalItems += len(items)
+	}
+	if len(files) > 0 {
+		slog.Info("recover diskcache", slog.Int("files", dc.filelist.Len()), slog.Int("items", dc.totalItems), slog.Int64("bytes", dc.totalBytes))
+	}
+	return nil
+}
+
+func (dc *DiskCache) quarantine(filename string, cause error) {
+	slog.Warn("quarantine broken diskcache", slog.String("filename", filename), slog.String("error", cause.Error()))
+	if err := dc.root.MkdirAll(config.QuarantineDirectory, 0755); err != nil {
+		slog.Error("failed quarantine diskcache", slog.String("filename", filename), slog.String("error", err.Error()))
+		return
+	}
+	if err := dc.root.Rename(filename, path.Join(config.QuarantineDirectory, filename)); err != nil {
+		slog.Error("failed quarantine diskcache", slog.String("filename", filename), slog.String("error", err.Error()))
+	}
+}
+
+// load はキャッシュファイルを読み込む
+func (dc *DiskCache) load(filename string) ([]sendqueue.Item, error) {
+	fi, err := dc.root.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close() // nolint
+
+	rd, err := gzip.NewReader(fi)
+	if err != nil {
+		return nil, err
+	}
+	var items []sendqueue.Item
+	if err = json.NewDecoder(rd).Decode(&items); err != nil {
+		return nil, err
+	}
+	if err = rd.Close(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// SetExpiry は古いメトリックを破棄するよう設定する。投稿を開始する前に呼び出すこと
+func (dc *DiskCache) SetExpiry(e *sendqueue.Expiry) {
+	dc.expiry = e
 }
 
 func (dc *DiskCache) Close() error {
@@ -77,41 +184,75 @@ func (dc *DiskCache) Tick(ctx context.Context) {
 }
 
 func (dc *DiskCache) createFile() {
-	// Tick 環境下の制約により、ファイル名は衝突しない
-	filename := fmt.Sprintf("%d.dat.gz", time.Now().UnixMilli())
-
 	// limit 件未満は処理しない
 	if len := dc.queue.Len(); len < limit {
 		return
 	}
 
-	items := dc.queue.FrontN(limit)
+	dequeued := dc.queue.FrontN(limit)
 	// 詰まりが解消し、0件の取得になった場合はスキップ
+	if len(dequeued) == 0 {
+		return
+	}
+	if err := dc.save(dequeued); err != nil {
+		slog.Error("failed save diskcache", slog.String("error", err.Error()))
+		return
+	}
+	// ファイルに書き出したので、journal からは外す
+	dc.queue.Ack(dequeued...)
+}
+
+// Spill は送信キューの上限から溢れた値をファイルに書き出す
+func (dc *DiskCache) Spill(items []sendqueue.Item) error {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	if err := dc.save(items); err != nil {
+		return fmt.Errorf("failed save diskcache: %w", err)
+	}
+	dc.purge()
+	return nil
+}
+
+// save は古いメトリックを除いて1つのファイルに書き出す。dc.mu を取得した状態で呼び出す
+func (dc *DiskCache) save(dequeued []sendqueue.Item) error {
+	now := time.Now()
+	items := make([]sendqueue.Item, 0, len(dequeued))
+	for _, item := range dequeued {
+		if filtered, ok := dc.expiry.Filter(item, now); ok {
+			items = append(items, filtered)
+		}
+	}
 	length := len(items)
 	if length == 0 {
-		return
+		return nil
 	}
 
+	// Spill が同じミリ秒に続いても衝突しないよう、作成時刻は前回より後にする
+	dc.lastCreated = max(now.UnixMilli(), dc.lastCreated+1)
+	filename := fmt.Sprintf("%d%s", dc.lastCreated, config.CacheFileSuffix)
+
 	fi, err := dc.root.Create(filename)
 	if err != nil {
-		slog.Error("failed save diskcache", slog.String("error", err.Error()))
-		return
+		return err
 	}
 
 	wr := gzip.NewWriter(fi)
 	if err = json.NewEncoder(wr).Encode(items); err != nil {
-		slog.Error("failed save diskcache", slog.String("error", err.Error()))
 		fi.Close() // nolint
-		return
+		return err
 	}
 	if err = wr.Close(); err != nil {
-		slog.Error("failed save diskcache", slog.String("error", err.Error()))
 		fi.Close() // nolint
-		return
+		return err
+	}
+	// 呼び出し元は書き出した値を journal から外すため、ディスクに書き込まれるまで待つ
+	if err = fi.Sync(); err != nil {
+		fi.Close() // nolint
+		return err
 	}
 	if err = fi.Close(); err != nil {
-		slog.Error("failed save diskcache", slog.String("error", err.Error()))
-		return
+		return err
 	}
 
 	var bs int64
@@ -123,6 +264,7 @@ func (dc *DiskCache) createFile() {
 	dc.filelist.PushBack(cacheEntry{filename: filename, bytes: bs, items: length})
 	dc.totalBytes += bs
 	dc.totalItems += length
+	return nil
 }
 
 func (dc *DiskCache) purge() {
@@ -146,6 +288,10 @@ func (dc *DiskCache) purge() {
 	dc.totalItems -= entry.items
 }
 
+func (*DiskCache) Name() string {
+	return "diskcache"
+}
+
 func (*DiskCache) Reload(conf *config.CollectorConfig) {
 	// no support
 }
@@ -168,59 +314,86 @@ func (dc *DiskCache) filelistDequeue() (cacheEntry, bool) {
 	return e.Value.(cacheEntry), true
 }
 
-func (dc *DiskCache) Dequeue() (hostid string, metrics []*mackerel.MetricValue, ok bool) {
+func (dc *DiskCache) Dequeue() (sendqueue.Item, bool) {
+	batch := dc.DequeueBatch(0)
+	if len(batch) == 0 {
+		return sendqueue.Item{}, false
+	}
+	return batch[0], true
+}
+
+// DequeueBatch は同じホストの値をまとめて取り出す。古いメトリックは除く
+func (dc *DiskCache) DequeueBatch(maxMetrics int) []sendqueue.Item {
 	dc.fileMu.Lock()
 	defer dc.fileMu.Unlock()
 
-	// ファイルの読み込みが開始されてない。または、ファイルを読み切った場合
-	if dc.filequeue.Len() == 0 {
+	// ファイルの読み込みが開始されてない。または、読み込んだ値が全て投稿された場合
+	if dc.reading == nil {
 		// 読み出すべきファイルがあれば、処理する
 		if entry, ok := dc.filelistDequeue(); ok {
-			fi, err := dc.root.Open(entry.filename)
+			items, err := dc.load(entry.filename)
 			if err != nil {
 				slog.Error("failed load diskcache", slog.String("error", err.Error()))
-				return "", nil, false
-			}
-			rd, err := gzip.NewReader(fi)
-			if err != nil {
-				slog.Error("failed load diskcache", slog.String("error", err.Error()))
-				return "", nil, false
-			}
-			var items []sendqueue.Item
-			if err = json.NewDecoder(rd).Decode(&items); err != nil {
-				slog.Error("failed load diskcache", slog.String("error", err.Error()))
-				return "", nil, false
+				dc.quarantine(entry.filename, err)
+				dc.mu.Lock()
+				dc.totalBytes -= entry.bytes
+				dc.totalItems -= entry.items
+				dc.mu.Unlock()
+				return nil
 			}
 			// container/list にコピーする
 			for idx := range items {
 				dc.filequeue.PushBack(sendqueue.Item{HostID: items[idx].HostID, Metrics: items[idx].Metrics})
 			}
-			if err = rd.Close(); err != nil {
-				slog.Error("failed load diskcache", slog.String("error", err.Error()))
-			}
-			if err = fi.Close(); err != nil {
-				slog.Error("failed load diskcache", slog.String("error", err.Error()))
+			dc.mu.Lock()
+			dc.totalItems -= entry.items
+			dc.mu.Unlock()
+			dc.reading, dc.pending = &entry, len(items)
+			if len(items) == 0 {
+				dc.done(0)
 			}
-			// ファイルは削除する
-			if err = dc.root.Remove(entry.filename); err != nil {
-				slog.Error("failed remove diskcache", slog.String("filename", entry.filename), slog.String("error", err.Error()))
+		}
+	}
+
+	now := time.Now()
+	for {
+		popped := sendqueue.PopBatch(dc.filequeue, maxMetrics)
+		if len(popped) == 0 {
+			return nil
+		}
+		batch := make([]sendqueue.Item, 0, len(popped))
+		for _, item := range popped {
+			if filtered, ok := dc.expiry.Filter(item, now); ok {
+				batch = append(batch, filtered)
 			} else {
-				dc.mu.Lock()
-				dc.totalBytes -= entry.bytes
-				dc.totalItems -= entry.items
-				dc.mu.Unlock()
+				dc.done(1)
 			}
 		}
+		if len(batch) > 0 {
+			return batch
+		}
 	}
+}
 
-	e := dc.filequeue.Front()
-	if e == nil {
-		return "", nil, false
+// done は読み込み中のファイルの値が n 件投稿された、または破棄されたことを記録する。dc.fileMu を取得した状態で呼び出す
+// 全ての値が投稿されたらファイルを削除する
+func (dc *DiskCache) done(n int) {
+	if dc.reading == nil {
+		return
 	}
-
-	item := e.Value.(sendqueue.Item)
-	dc.filequeue.Remove(e)
-	return item.HostID, item.Metrics, true
+	dc.pending -= n
+	if dc.pending > 0 {
+		return
+	}
+	entry := *dc.reading
+	dc.reading, dc.pending = nil, 0
+	if err := dc.root.Remove(entry.filename); err != nil {
+		slog.Error("failed remove diskcache", slog.String("filename", entry.filename), slog.String("error", err.Error()))
+		return
+	}
+	dc.mu.Lock()
+	dc.totalBytes -= entry.bytes
+	dc.mu.Unlock()
 }
 
 // 未送信件数
@@ -234,8 +407,22 @@ func (dc *DiskCache) Len() int {
 	return dc.totalItems + dc.filequeue.Len()
 }
 
-func (dc *DiskCache) ReEnqueue(hostID string, metrics []*mackerel.MetricValue) {
+// キャッシュファイルの合計サイズ
+func (dc *DiskCache) Bytes() int64 {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	return dc.totalBytes
+}
+
+func (dc *DiskCache) ReEnqueue(item sendqueue.Item) {
+	dc.fileMu.Lock()
+	defer dc.fileMu.Unlock()
+	dc.filequeue.PushFront(item)
+}
+
+// Ack は投稿が完了した値を記録し、読み込み中のファイルの値が全て投稿されたらファイルを削除する
+func (dc *DiskCache) Ack(items ...sendqueue.Item) {
 	dc.fileMu.Lock()
 	defer dc.fileMu.Unlock()
-	dc.filequeue.PushFront(sendqueue.Item{HostID: hostID, Metrics: metrics})
+	dc.done(len(items))
 }
@@ -0,0 +1,169 @@
+package diskcache
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mackerelio/mackerel-client-go"
+	"gopkg.in/yaml.v3"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/config"
+	"github.com/mackerelio-labs/sabatrafficd/internal/sendqueue"
+)
+
+func writeCacheFile(t *testing.T, filename string, items []sendqueue.Item) {
+	t.Helper()
+	fi, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fi.Close() // nolint
+	wr := gzip.NewWriter(fi)
+	if err = json.NewEncoder(wr).Encode(items); err != nil {
+		t.Fatal(err)
+	}
+	if err = wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	dir := t.TempDir()
+	item := func(hostID string) sendqueue.Item {
+		return sendqueue.Item{HostID: hostID, Metrics: []*mackerel.MetricValue{{Name: "name", Time: 1, Value: float64(1)}}}
+	}
+	// 作成した順に送信する
+	writeCacheFile(t, filepath.Join(dir, "1700000000200.dat.gz"), []sendqueue.Item{item("c")})
+	writeCacheFile(t, filepath.Join(dir, "1700000000100.dat.gz"), []sendqueue.Item{item("a"), item("b")})
+	// 読み込めないファイルは隔離する
+	if err := os.WriteFile(filepath.Join(dir, "1700000000150.dat.gz"), []byte("broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken.dat.gz"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dc, err := New(sendqueue.New(), &config.DiskCache{Directory: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dc.Close() // nolint
+
+	if dc.Len() != 3 {
+		t.Errorf("invalid len: %d", dc.Len())
+	}
+	if dc.Bytes() == 0 {
+		t.Error("bytes should be recovered")
+	}
+
+	var hostIDs []string
+	for {
+		item, ok := dc.Dequeue()
+		if !ok {
+			break
+		}
+		hostIDs = append(hostIDs, item.HostID)
+		dc.Ack(item)
+	}
+	if len(hostIDs) != 3 || hostIDs[0] != "a" || hostIDs[1] != "b" || hostIDs[2] != "c" {
+		t.Errorf("invalid order: %v", hostIDs)
+	}
+	if dc.Len() != 0 || dc.Bytes() != 0 {
+		t.Errorf("invalid len: %d, bytes: %d", dc.Len(), dc.Bytes())
+	}
+
+	for _, name := range []string{"1700000000150.dat.gz", "broken.dat.gz"} {
+		if _, err := os.Stat(filepath.Join(dir, config.QuarantineDirectory, name)); err != nil {
+			t.Errorf("%s is not quarantined: %s", name, err)
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.dat.gz")); len(files) != 0 {
+		t.Errorf("files should be removed: %v", files)
+	}
+}
+
+func TestSpill(t *testing.T) {
+	dir := t.TempDir()
+	var size config.Size
+	if err := yaml.Unmarshal([]byte("10MB"), &size); err != nil {
+		t.Fatal(err)
+	}
+	dc, err := New(sendqueue.New(), &config.DiskCache{Directory: dir, Size: size})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dc.Close() // nolint
+
+	item := func(hostID string) sendqueue.Item {
+		return sendqueue.Item{HostID: hostID, Metrics: []*mackerel.MetricValue{{Name: "name", Time: time.Now().Unix(), Value: float64(1)}}}
+	}
+	// 同じミリ秒に続けて書き出しても別のファイルになる
+	if err := dc.Spill([]sendqueue.Item{item("a"), item("b")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dc.Spill([]sendqueue.Item{item("c")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.dat.gz")); len(files) != 2 {
+		t.Errorf("invalid files: %v", files)
+	}
+	if dc.Len() != 3 {
+		t.Errorf("invalid len: %d", dc.Len())
+	}
+
+	var hostIDs []string
+	for {
+		item, ok := dc.Dequeue()
+		if !ok {
+			break
+		}
+		hostIDs = append(hostIDs, item.HostID)
+		dc.Ack(item)
+	}
+	if len(hostIDs) != 3 || hostIDs[0] != "a" || hostIDs[1] != "b" || hostIDs[2] != "c" {
+		t.Errorf("invalid order: %v", hostIDs)
+	}
+}
+
+func TestRemoveAfterAck(t *testing.T) {
+	dir := t.TempDir()
+	item := func(hostID string) sendqueue.Item {
+		return sendqueue.Item{HostID: hostID, Metrics: []*mackerel.MetricValue{{Name: "name", Time: 1, Value: float64(1)}}}
+	}
+	filename := filepath.Join(dir, "1700000000100.dat.gz")
+	writeCacheFile(t, filename, []sendqueue.Item{item("a"), item("b")})
+	writeCacheFile(t, filepath.Join(dir, "1700000000200.dat.gz"), []sendqueue.Item{item("c")})
+
+	dc, err := New(sendqueue.New(), &config.DiskCache{Directory: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dc.Close() // nolint
+
+	a, _ := dc.Dequeue()
+	// 投稿に失敗した値は戻される
+	dc.ReEnqueue(a)
+	a, _ = dc.Dequeue()
+	dc.Ack(a)
+	b, _ := dc.Dequeue()
+	// 投稿が終わるまではファイルを残し、次のファイルも読み込まない
+	if _, err := os.Stat(filename); err != nil {
+		t.Errorf("file is removed before ack: %s", err)
+	}
+	if _, ok := dc.Dequeue(); ok {
+		t.Error("next file is loaded before ack")
+	}
+
+	dc.Ack(b)
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("file is not removed after ack: %v", err)
+	}
+	if c, ok := dc.Dequeue(); !ok || c.HostID != "c" {
+		t.Errorf("invalid item: %+v", c)
+	}
+}
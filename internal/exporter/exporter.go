@@ -0,0 +1,261 @@
+package exporter
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/collector"
+	"github.com/mackerelio-labs/sabatrafficd/internal/config"
+	"github.com/mackerelio-labs/sabatrafficd/internal/mib"
+	"github.com/mackerelio-labs/sabatrafficd/internal/snmp"
+)
+
+// 一定時間更新されない collector は、停止または削除されたものとして出力しない
+// 取得間隔が長い collector は、取得間隔の2倍まで出力する
+const staleness = 5 * time.Minute
+
+type Exporter struct {
+	mu         sync.RWMutex
+	collectors map[string]*snapshot
+
+	srv        *http.Server
+	isShutdown atomic.Bool
+}
+
+type snapshot struct {
+	host string
+
+	interfaces        []collector.MetricsDutum
+	interfacesUpdated time.Time
+	custom            map[string]snmp.Value
+	customUpdated     time.Time
+	tables            []collector.CustomTableDutum
+	tablesUpdated     time.Time
+	// type: counter が指定された mib
+	counters map[string]bool
+
+	// 直近の取得間隔
+	interval time.Duration
+}
+
+func (s *snapshot) stale(now, updated time.Time) bool {
+	return now.Sub(updated) > max(staleness, 2*s.interval)
+}
+
+func New(addr string) *Exporter {
+	e := &Exporter{
+		collectors: make(map[string]*snapshot),
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", e)
+	e.srv = &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return e
+}
+
+func (e *Exporter) snapshot(collectorID, host string) *snapshot {
+	s, ok := e.collectors[collectorID]
+	if !ok {
+		s = &snapshot{}
+		e.collectors[collectorID] = s
+	}
+	s.host = host
+	return s
+}
+
+// exporter が無効な場合は nil のまま呼び出されるため、nil を許容する
+func (e *Exporter) UpdateInterfaces(collectorID, host string, metrics []collector.MetricsDutum) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := e.snapshot(collectorID, host)
+	now := time.Now()
+	if !s.interfacesUpdated.IsZero() {
+		s.interval = now.Sub(s.interfacesUpdated)
+	}
+	s.interfaces = metrics
+	s.interfacesUpdated = now
+}
+
+// counters は type: counter が指定された mib。custom-mibs と custom-mib-tables で共通
+func (e *Exporter) UpdateCustomMIBs(collectorID, host string, values map[string]snmp.Value, counters map[string]bool) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := e.snapshot(collectorID, host)
+	s.custom = values
+	s.customUpdated = time.Now()
+	s.counters = counters
+}
+
+func (e *Exporter) UpdateCustomMIBTables(collectorID, host string, rows []collector.CustomTableDutum, counters map[string]bool) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := e.snapshot(collectorID, host)
+	s.tables = rows
+	s.tablesUpdated = time.Now()
+	s.counters = counters
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	e.write(bw, time.Now())
+	if err := bw.Flush(); err != nil {
+		slog.Warn("failed write metrics", slog.String("error", err.Error()))
+	}
+}
+
+func (e *Exporter) write(w *bufio.Writer, now time.Time) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	ids := make([]string, 0, len(e.collectors))
+	for id := range e.collectors {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	// 同じ機器を port や host-id を変えて取得する collector を区別するため、collector ラベルを付ける
+	// Prometheus では1つのメトリックファミリーに1つの型しか指定できないため、type ごとに分ける
+	for _, counter := range []bool{false, true} {
+		name, help, typ := "sabatrafficd_interface_value", "Latest raw value of interface MIB.", "gauge"
+		if counter {
+			name, help, typ = "sabatrafficd_interface_total", "Latest raw value of interface MIB of counter type.", "counter"
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+		for _, id := range ids {
+			s := e.collectors[id]
+			if s.stale(now, s.interfacesUpdated) {
+				continue
+			}
+			var metrics []collector.MetricsDutum
+			for _, m := range s.interfaces {
+				if mib.IsCounter(m.Mib) == counter {
+					metrics = append(metrics, m)
+				}
+			}
+			slices.SortFunc(metrics, func(a, b collector.MetricsDutum) int {
+				return cmp.Or(cmp.Compare(a.Mib, b.Mib), cmp.Compare(a.IfIndex, b.IfIndex))
+			})
+			for _, m := range metrics {
+				fmt.Fprintf(w, "%s{collector=%s,host=%s,ifIndex=\"%d\",ifName=%s,mib=%s} %d\n",
+					name, quote(id), quote(s.host), m.IfIndex, quote(m.IfName), quote(m.Mib), m.Value)
+			}
+		}
+	}
+
+	for _, counter := range []bool{false, true} {
+		name, help, typ := "sabatrafficd_custom_mib_value", "Latest value of custom-mibs.", "gauge"
+		if counter {
+			name, help, typ = "sabatrafficd_custom_mib_total", "Latest value of custom-mibs of counter type.", "counter"
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+		for _, id := range ids {
+			s := e.collectors[id]
+			if s.stale(now, s.customUpdated) {
+				continue
+			}
+			oids := make([]string, 0, len(s.custom))
+			for oid := range s.custom {
+				if s.counters[oid] == counter {
+					oids = append(oids, oid)
+				}
+			}
+			slices.Sort(oids)
+			for _, oid := range oids {
+				fmt.Fprintf(w, "%s{collector=%s,host=%s,mib=%s} %s\n",
+					name, quote(id), quote(s.host), quote(oid), formatFloat(s.custom[oid].Float))
+			}
+		}
+	}
+
+	for _, counter := range []bool{false, true} {
+		name, help, typ := "sabatrafficd_custom_mib_table_value", "Latest value of custom-mibs tables.", "gauge"
+		if counter {
+			name, help, typ = "sabatrafficd_custom_mib_table_total", "Latest value of custom-mibs tables of counter type.", "counter"
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+		for _, id := range ids {
+			s := e.collectors[id]
+			if s.stale(now, s.tablesUpdated) {
+				continue
+			}
+			var rows []collector.CustomTableDutum
+			for _, row := range s.tables {
+				if s.counters[row.MIB] == counter {
+					rows = append(rows, row)
+				}
+			}
+			slices.SortFunc(rows, func(a, b collector.CustomTableDutum) int {
+				return cmp.Or(cmp.Compare(a.MIB, b.MIB), cmp.Compare(a.Index, b.Index))
+			})
+			for _, row := range rows {
+				fmt.Fprintf(w, "%s{collector=%s,host=%s,mib=%s,index=%s,name=%s} %s\n",
+					name, quote(id), quote(s.host), quote(row.MIB), quote(row.Index), quote(row.Name), formatFloat(row.Value.Float))
+			}
+		}
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func quote(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func (e *Exporter) Serve() error {
+	slog.Info("listen metrics exporter", slog.String("addr", e.srv.Addr))
+	if err := e.srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	if !e.isShutdown.CompareAndSwap(false, true) {
+		return nil
+	}
+	return e.srv.Shutdown(ctx)
+}
+
+func (*Exporter) Reload(conf *config.CollectorConfig) {
+	// no support
+}
+
+func (*Exporter) CollectorID() string {
+	// no support
+	return ""
+}
+
+func (e *Exporter) Alive() bool {
+	return !e.isShutdown.Load()
+}
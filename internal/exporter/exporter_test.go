@@ -0,0 +1,93 @@
+package exporter
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/collector"
+	"github.com/mackerelio-labs/sabatrafficd/internal/snmp"
+)
+
+func TestServeHTTP(t *testing.T) {
+	e := New("")
+	e.UpdateInterfaces("a", "192.0.2.1", []collector.MetricsDutum{
+		{IfIndex: 2, Mib: "ifHCInOctets", IfName: "eth1", Value: 20},
+		{IfIndex: 1, Mib: "ifHCInOctets", IfName: `eth"0"`, Value: 10},
+		{IfIndex: 1, Mib: "ifOperStatus", IfName: `eth"0"`, Value: 1},
+	})
+	// 同じ機器を別の host-id で取得する collector
+	e.UpdateInterfaces("c", "192.0.2.1", []collector.MetricsDutum{
+		{IfIndex: 1, Mib: "ifHCInOctets", IfName: `eth"0"`, Value: 10},
+	})
+	counters := map[string]bool{"1.3.6.1.2.1.4.3.0": true, "1.3.6.1.2.1.31.1.1.1.6": true}
+	e.UpdateCustomMIBs("a", "192.0.2.1", map[string]snmp.Value{
+		"1.3.6.1.2.1.1.3.0": {Float: 12345},
+		"1.3.6.1.2.1.4.3.0": {Float: 100, Counter: 100, CounterBits: 32},
+	}, counters)
+	e.UpdateCustomMIBTables("a", "192.0.2.1", []collector.CustomTableDutum{
+		{MIB: "1.3.6.1.2.1.25.3.3.1.2", Index: "196608", Name: "CPU 0", Value: snmp.Value{Float: 1.5}},
+		{MIB: "1.3.6.1.2.1.31.1.1.1.6", Index: "1", Name: "eth0", Value: snmp.Value{Float: 200, Counter: 200, CounterBits: 64}},
+	}, counters)
+	e.UpdateInterfaces("b", "192.0.2.2", []collector.MetricsDutum{
+		{IfIndex: 1, Mib: "ifHCOutOctets", IfName: "lo0", Value: 30},
+	})
+	e.collectors["b"].interfacesUpdated = time.Now().Add(-time.Hour)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, err := io.ReadAll(rec.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP sabatrafficd_interface_value Latest raw value of interface MIB.
+# TYPE sabatrafficd_interface_value gauge
+sabatrafficd_interface_value{collector="a",host="192.0.2.1",ifIndex="1",ifName="eth\"0\"",mib="ifOperStatus"} 1
+# HELP sabatrafficd_interface_total Latest raw value of interface MIB of counter type.
+# TYPE sabatrafficd_interface_total counter
+sabatrafficd_interface_total{collector="a",host="192.0.2.1",ifIndex="1",ifName="eth\"0\"",mib="ifHCInOctets"} 10
+sabatrafficd_interface_total{collector="a",host="192.0.2.1",ifIndex="2",ifName="eth1",mib="ifHCInOctets"} 20
+sabatrafficd_interface_total{collector="c",host="192.0.2.1",ifIndex="1",ifName="eth\"0\"",mib="ifHCInOctets"} 10
+# HELP sabatrafficd_custom_mib_value Latest value of custom-mibs.
+# TYPE sabatrafficd_custom_mib_value gauge
+sabatrafficd_custom_mib_value{collector="a",host="192.0.2.1",mib="1.3.6.1.2.1.1.3.0"} 12345
+# HELP sabatrafficd_custom_mib_total Latest value of custom-mibs of counter type.
+# TYPE sabatrafficd_custom_mib_total counter
+sabatrafficd_custom_mib_total{collector="a",host="192.0.2.1",mib="1.3.6.1.2.1.4.3.0"} 100
+# HELP sabatrafficd_custom_mib_table_value Latest value of custom-mibs tables.
+# TYPE sabatrafficd_custom_mib_table_value gauge
+sabatrafficd_custom_mib_table_value{collector="a",host="192.0.2.1",mib="1.3.6.1.2.1.25.3.3.1.2",index="196608",name="CPU 0"} 1.5
+# HELP sabatrafficd_custom_mib_table_total Latest value of custom-mibs tables of counter type.
+# TYPE sabatrafficd_custom_mib_table_total counter
+sabatrafficd_custom_mib_table_total{collector="a",host="192.0.2.1",mib="1.3.6.1.2.1.31.1.1.1.6",index="1",name="eth0"} 200
+`
+	if diff := cmp.Diff(string(body), expected); diff != "" {
+		t.Errorf("value is mismatch (-actual +expected):%s", diff)
+	}
+}
+
+func TestNilExporter(t *testing.T) {
+	var e *Exporter
+	e.UpdateInterfaces("a", "192.0.2.1", nil)
+	e.UpdateCustomMIBs("a", "192.0.2.1", nil, nil)
+	e.UpdateCustomMIBTables("a", "192.0.2.1", nil, nil)
+}
+
+func TestStale(t *testing.T) {
+	now := time.Now()
+	s := &snapshot{}
+	if !s.stale(now, now.Add(-6*time.Minute)) {
+		t.Error("snapshot should be stale after 5 minutes")
+	}
+	s.interval = 5 * time.Minute
+	if s.stale(now, now.Add(-6*time.Minute)) {
+		t.Error("snapshot should not be stale within twice the interval")
+	}
+	if !s.stale(now, now.Add(-11*time.Minute)) {
+		t.Error("snapshot should be stale after twice the interval")
+	}
+}
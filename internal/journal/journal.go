@@ -0,0 +1,254 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mackerelio/mackerel-client-go"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/sendqueue"
+)
+
+// セグメントがこの大きさを超えたら、次のセグメントに切り替える
+const segmentSize = 4 * 1000 * 1000
+
+const suffix = ".wal"
+
+// Journal は送信キューに追加した値を、投稿が完了するまでファイルに記録する
+// セグメント内の値がすべて完了したら、セグメントを削除する
+// 一部が完了したセグメントは、再起動時にすべて再投稿されるが、同じメトリック名と時刻の値は上書きされるため問題ない
+type Journal struct {
+	mu   sync.Mutex
+	root *os.Root
+
+	seq uint64
+
+	current int
+	file    *os.File
+	size    int64
+
+	// segment:未完了の件数
+	pending map[int]int
+	// seq:segment
+	segments map[uint64]int
+}
+
+type record struct {
+	Seq     uint64                  `json:"seq"`
+	HostID  string                  `json:"hostID"`
+	Metrics []*mackerel.MetricValue `json:"metrics"`
+}
+
+// Open は directory 以下のセグメントを読み込み、未完了の値を返す
+func Open(directory string) (*Journal, []sendqueue.Item, error) {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, nil, err
+	}
+	root, err := os.OpenRoot(directory)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	j := &Journal{
+		root:     root,
+		pending:  make(map[int]int),
+		segments: make(map[uint64]int),
+	}
+	items, err := j.replay()
+	if err != nil {
+		root.Close() // nolint
+		return nil, nil, err
+	}
+	if err = j.rotate(); err != nil {
+		root.Close() // nolint
+		return nil, nil, err
+	}
+	return j, items, nil
+}
+
+func segmentName(segment int) string {
+	return fmt.Sprintf("%020d%s", segment, suffix)
+}
+
+func (j *Journal) replay() ([]sendqueue.Item, error) {
+	entries, err := fs.ReadDir(j.root.FS(), ".")
+	if err != nil {
+		return nil, err
+	}
+	var segments []int
+	for _, entry := range entries {
+		var segment int
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), suffix) {
+			continue
+		}
+		if _, err := fmt.Sscanf(entry.Name(), "%d"+suffix, &segment); err != nil {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	slices.Sort(segments)
+
+	var items []sendqueue.Item
+	for _, segment := range segments {
+		j.current = max(j.current, segment)
+		records, err := j.read(segment)
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			j.remove(segment)
+			continue
+		}
+		for _, r := range records {
+			j.seq = max(j.seq, r.Seq)
+			j.segments[r.Seq] = segment
+			j.pending[segment]++
+			items = append(items, sendqueue.Item{Seq: r.Seq, HostID: r.HostID, Metrics: r.Metrics})
+		}
+	}
+	return items, nil
+}
+
+func (j *Journal) read(segment int) ([]record, error) {
+	fi, err := j.root.Open(segmentName(segment))
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close() // nolint
+
+	var records []record
+	sc := bufio.NewScanner(fi)
+	sc.Buffer(make([]byte, 64*1024), segmentSize)
+	for sc.Scan() {
+		var r record
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			// 書き込み中に停止した場合は、最後の行が壊れている
+			slog.Warn("skip broken journal record", slog.String("segment", segmentName(segment)), slog.String("error", err.Error()))
+			continue
+		}
+		records = append(records, r)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed read journal %s: %w", segmentName(segment), err)
+	}
+	return records, nil
+}
+
+// rotate は新しいセグメントに切り替える。呼び出し元でロックを取得すること
+func (j *Journal) rotate() error {
+	prev := j.current
+	if j.file != nil {
+		if err := j.file.Close(); err != nil {
+			return err
+		}
+	}
+
+	j.current++
+	fi, err := j.root.OpenFile(segmentName(j.current), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	j.file = fi
+	j.size = 0
+
+	if j.pending[prev] == 0 {
+		j.remove(prev)
+	}
+	return nil
+}
+
+func (j *Journal) remove(segment int) {
+	delete(j.pending, segment)
+	if err := j.root.Remove(segmentName(segment)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("failed remove journal", slog.String("segment", segmentName(segment)), slog.String("error", err.Error()))
+	}
+}
+
+// Append は items を記録し、それぞれの seq を返す
+// ファイルへの同期が完了してから返る
+func (j *Journal) Append(items []sendqueue.Item) ([]uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var buf []byte
+	seqs := make([]uint64, 0, len(items))
+	seq := j.seq
+	for _, item := range items {
+		seq++
+		b, err := json.Marshal(record{Seq: seq, HostID: item.HostID, Metrics: item.Metrics})
+		if err != nil {
+			return nil, err
+		}
+		buf = append(append(buf, b...), '\n')
+		seqs = append(seqs, seq)
+	}
+
+	n, err := j.file.Write(buf)
+	j.size += int64(n)
+	if err != nil {
+		return nil, err
+	}
+	if err = j.file.Sync(); err != nil {
+		return nil, err
+	}
+
+	j.seq = seq
+	for _, seq := range seqs {
+		j.segments[seq] = j.current
+	}
+	j.pending[j.current] += len(seqs)
+
+	if j.size >= segmentSize {
+		if err = j.rotate(); err != nil {
+			slog.Warn("failed rotate journal", slog.String("error", err.Error()))
+		}
+	}
+	return seqs, nil
+}
+
+// Ack は投稿が完了した、または他の方法で永続化された値を記録から外す
+func (j *Journal) Ack(seq uint64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	segment, ok := j.segments[seq]
+	if !ok {
+		return
+	}
+	delete(j.segments, seq)
+	j.pending[segment]--
+	if j.pending[segment] == 0 && segment != j.current {
+		j.remove(segment)
+	}
+}
+
+// Len は未完了の件数を返す
+func (j *Journal) Len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.segments)
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var err error
+	if j.file != nil {
+		err = j.file.Close()
+		j.file = nil
+	}
+	// すべて完了していれば、空のセグメントは残さない
+	if j.pending[j.current] == 0 {
+		j.remove(j.current)
+	}
+	return errors.Join(err, j.root.Close())
+}
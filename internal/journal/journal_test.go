@@ -0,0 +1,127 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mackerelio/mackerel-client-go"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/sendqueue"
+)
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+suffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestJournal(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "wal")
+
+	j, replay, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replay) != 0 {
+		t.Errorf("invalid replay: %d", len(replay))
+	}
+
+	items := []sendqueue.Item{
+		{HostID: "a", Metrics: []*mackerel.MetricValue{{Name: "m1", Time: 1, Value: float64(1)}}},
+		{HostID: "b", Metrics: []*mackerel.MetricValue{{Name: "m2", Time: 2, Value: float64(2)}}},
+	}
+	seqs, err := j.Append(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(seqs, []uint64{1, 2}); diff != "" {
+		t.Errorf("value is mismatch (-actual +expected):%s", diff)
+	}
+	j.Ack(seqs[0])
+	// 停止せずに終了した場合を再現するため、Close しない
+	j.file.Close() // nolint
+
+	// 書き込み中に停止した行は読み飛ばす
+	f, err := os.OpenFile(filepath.Join(dir, segmentName(j.current)), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":3,"hostID":"c","met`) // nolint
+	f.Close()                                   // nolint
+
+	j, replay, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 一部のみ完了したセグメントは、すべて再投稿する
+	expected := []sendqueue.Item{
+		{Seq: 1, HostID: "a", Metrics: items[0].Metrics},
+		{Seq: 2, HostID: "b", Metrics: items[1].Metrics},
+	}
+	if diff := cmp.Diff(replay, expected); diff != "" {
+		t.Errorf("value is mismatch (-actual +expected):%s", diff)
+	}
+
+	// 続きの番号が振られる
+	seqs, err = j.Append(items[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seqs[0] != 3 {
+		t.Errorf("invalid seq: %d", seqs[0])
+	}
+	if len(segmentFiles(t, dir)) != 2 {
+		t.Errorf("invalid segments: %v", segmentFiles(t, dir))
+	}
+
+	// すべて完了すると、セグメントは削除される
+	for _, seq := range []uint64{1, 2, 3} {
+		j.Ack(seq)
+	}
+	if j.Len() != 0 {
+		t.Errorf("invalid len: %d", j.Len())
+	}
+	if err = j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files := segmentFiles(t, dir); len(files) != 0 {
+		t.Errorf("segments should be removed: %v", files)
+	}
+}
+
+func TestJournalRotate(t *testing.T) {
+	dir := t.TempDir()
+	j, _, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close() // nolint
+
+	metrics := make([]*mackerel.MetricValue, 50)
+	for idx := range metrics {
+		metrics[idx] = &mackerel.MetricValue{Name: "custom.interface.ifHCInOctets.GigabitEthernet0-1", Time: 1700000000, Value: float64(idx)}
+	}
+	var seqs []uint64
+	for range 1000 {
+		s, err := j.Append([]sendqueue.Item{{HostID: "a", Metrics: metrics}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, s...)
+	}
+	if len(segmentFiles(t, dir)) < 2 {
+		t.Fatalf("segment is not rotated: %v", segmentFiles(t, dir))
+	}
+	for _, seq := range seqs {
+		j.Ack(seq)
+	}
+	// 書き込み中のセグメントのみ残る
+	if files := segmentFiles(t, dir); len(files) != 1 {
+		t.Errorf("invalid segments: %v", files)
+	}
+}
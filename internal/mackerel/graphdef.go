@@ -1,6 +1,11 @@
 package mackerel
 
-import "github.com/mackerelio/mackerel-client-go"
+import (
+	"maps"
+	"slices"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
 
 var graphDefs = []*mackerel.GraphDefsParam{
 	{
@@ -47,4 +52,254 @@ var graphDefs = []*mackerel.GraphDefsParam{
 			},
 		},
 	},
+	{
+		Name:        "custom.interface.ifInUnknownProtos",
+		Unit:        "integer",
+		DisplayName: "In Unknown Protos",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.interface.ifInUnknownProtos.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.interface.ifOutQLen",
+		Unit:        "integer",
+		DisplayName: "Out Queue Length",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.interface.ifOutQLen.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.interface.ifHighSpeed",
+		Unit:        "integer",
+		DisplayName: "Speed (Mbps)",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.interface.ifHighSpeed.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.interface.ifHCInUcastPkts",
+		Unit:        "float",
+		DisplayName: "In Unicast Packets/sec",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.interface.ifHCInUcastPkts.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.interface.ifHCOutUcastPkts",
+		Unit:        "float",
+		DisplayName: "Out Unicast Packets/sec",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.interface.ifHCOutUcastPkts.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.interface.ifHCInMulticastPkts",
+		Unit:        "float",
+		DisplayName: "In Multicast Packets/sec",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.interface.ifHCInMulticastPkts.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.interface.ifHCOutMulticastPkts",
+		Unit:        "float",
+		DisplayName: "Out Multicast Packets/sec",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.interface.ifHCOutMulticastPkts.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.interface.ifHCInBroadcastPkts",
+		Unit:        "float",
+		DisplayName: "In Broadcast Packets/sec",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.interface.ifHCInBroadcastPkts.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.interface.ifHCOutBroadcastPkts",
+		Unit:        "float",
+		DisplayName: "Out Broadcast Packets/sec",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.interface.ifHCOutBroadcastPkts.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.interface.inUtilization",
+		Unit:        "percentage",
+		DisplayName: "In Utilization",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.interface.inUtilization.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.interface.outUtilization",
+		Unit:        "percentage",
+		DisplayName: "Out Utilization",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.interface.outUtilization.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.interface.ifOperStatus",
+		Unit:        "integer",
+		DisplayName: "Oper Status",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.interface.ifOperStatus.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.interface.ifAdminStatus",
+		Unit:        "integer",
+		DisplayName: "Admin Status",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.interface.ifAdminStatus.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.interface.linkStateChanged",
+		Unit:        "integer",
+		DisplayName: "Link State Changed (0/1)",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.interface.linkStateChanged.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.interface.adminUpOperDown",
+		Unit:        "integer",
+		DisplayName: "Admin Up / Oper Down",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.interface.adminUpOperDown.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.interface.ifInDiscardsPerSec",
+		Unit:        "float",
+		DisplayName: "In Discards/sec",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.interface.ifInDiscardsPerSec.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.interface.ifOutDiscardsPerSec",
+		Unit:        "float",
+		DisplayName: "Out Discards/sec",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.interface.ifOutDiscardsPerSec.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.interface.ifInErrorsPerSec",
+		Unit:        "float",
+		DisplayName: "In Errors/sec",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.interface.ifInErrorsPerSec.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.interface.ifOutErrorsPerSec",
+		Unit:        "float",
+		DisplayName: "Out Errors/sec",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.interface.ifOutErrorsPerSec.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.interface.ifInUnknownProtosPerSec",
+		Unit:        "float",
+		DisplayName: "In Unknown Protos/sec",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.interface.ifInUnknownProtosPerSec.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+}
+
+// interfaceGraphDefs は displayNames のインターフェイスの凡例を ifAlias にしたグラフ定義を返す
+// displayNames はメトリック名に使うインターフェイス名:凡例に表示する名前。それ以外のインターフェイスはインターフェイス名を表示する
+func interfaceGraphDefs(displayNames map[string]string) []*mackerel.GraphDefsParam {
+	if len(displayNames) == 0 {
+		return graphDefs
+	}
+	names := slices.Sorted(maps.Keys(displayNames))
+
+	defs := make([]*mackerel.GraphDefsParam, 0, len(graphDefs))
+	for _, def := range graphDefs {
+		// custom.interface.<mib>.* のようにインターフェイスごとのメトリックをまとめたグラフのみ
+		if len(def.Metrics) != 1 || def.Metrics[0].Name != def.Name+".*" {
+			defs = append(defs, def)
+			continue
+		}
+		metrics := make([]*mackerel.GraphDefsMetric, 0, len(names)+1)
+		for _, name := range names {
+			metrics = append(metrics, &mackerel.GraphDefsMetric{
+				Name:        def.Name + "." + name,
+				DisplayName: displayNames[name],
+				IsStacked:   def.Metrics[0].IsStacked,
+			})
+		}
+		d := *def
+		d.Metrics = append(metrics, def.Metrics...)
+		defs = append(defs, &d)
+	}
+	return defs
 }
@@ -16,6 +16,7 @@ type mackerelClient interface {
 	CreateGraphDefs(payloads []*mackerel.GraphDefsParam) error
 	PostHostMetricValuesByHostID(hostID string, metricValues []*mackerel.MetricValue) error
 	FindHostByCustomIdentifierContext(ctx context.Context, customIdentifier string, param *mackerel.FindHostByCustomIdentifierParam) (*mackerel.Host, error)
+	PostCheckReportsContext(ctx context.Context, checkReports *mackerel.CheckReports) error
 }
 
 type Mackerel struct {
@@ -30,7 +31,8 @@ func New(apikey string) *Mackerel {
 	}
 }
 
-func (m *Mackerel) UpdateHost(ctx context.Context, hostID, hostAddr, hostname string, ifs []collector.Interface) error {
+// displayNames はメトリック名に使うインターフェイス名:グラフの凡例に表示する名前 (ifAlias)
+func (m *Mackerel) UpdateHost(ctx context.Context, hostID, hostAddr, hostname string, ifs []collector.Interface, displayNames map[string]string) error {
 	var interfaces []mackerel.Interface
 
 	if len(ifs) == 0 {
@@ -56,7 +58,7 @@ func (m *Mackerel) UpdateHost(ctx context.Context, hostID, hostAddr, hostname st
 		return err
 	}
 
-	if err = m.CreateGraphDefs(ctx, graphDefs); err != nil {
+	if err = m.CreateGraphDefs(ctx, interfaceGraphDefs(displayNames)); err != nil {
 		return err
 	}
 	return nil
@@ -70,6 +72,10 @@ func (m *Mackerel) Send(ctx context.Context, hostID string, value []*mackerel.Me
 	return m.client.PostHostMetricValuesByHostID(hostID, value)
 }
 
+func (m *Mackerel) PostCheckReports(ctx context.Context, reports []*mackerel.CheckReport) error {
+	return m.client.PostCheckReportsContext(ctx, &mackerel.CheckReports{Reports: reports})
+}
+
 func (m *Mackerel) FindHostByCustomIdentifierContext(ctx context.Context, customIdentifier string) (string, error) {
 	host, err := m.client.FindHostByCustomIdentifierContext(ctx, customIdentifier, &mackerel.FindHostByCustomIdentifierParam{
 		CaseInsensitive: false,
@@ -16,6 +16,7 @@ type mackerelClientMock struct {
 	graphDef     []*mackerel.GraphDefsParam
 	hostID       string
 	metricValues []*mackerel.MetricValue
+	checkReports []*mackerel.CheckReport
 
 	returnHostID        string
 	returnError         error
@@ -41,6 +42,11 @@ func (m *mackerelClientMock) FindHostByCustomIdentifierContext(_ context.Context
 	return m.returnHost, m.returnError
 }
 
+func (m *mackerelClientMock) PostCheckReportsContext(_ context.Context, checkReports *mackerel.CheckReports) error {
+	m.checkReports = checkReports.Reports
+	return m.returnError
+}
+
 func TestInit(t *testing.T) {
 	id := "1234567890"
 	updateHost := mackerel.UpdateHostParam{
@@ -128,7 +134,7 @@ func TestInit(t *testing.T) {
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			tc.queue.client = tc.mock
-			err := tc.queue.UpdateHost(t.Context(), "0987654321", "192.0.2.2", "hostname", tc.interfaces)
+			err := tc.queue.UpdateHost(t.Context(), "0987654321", "192.0.2.2", "hostname", tc.interfaces, nil)
 			if !errors.Is(err, tc.expectedError) {
 				t.Error("invalid error")
 			}
@@ -159,6 +165,31 @@ func TestSend(t *testing.T) {
 
 }
 
+func TestInterfaceGraphDefs(t *testing.T) {
+	if defs := interfaceGraphDefs(nil); !reflect.DeepEqual(defs, graphDefs) {
+		t.Error("graph definitions should not be changed without display names")
+	}
+
+	defs := interfaceGraphDefs(map[string]string{"Gi1-0-1": "uplink", "Gi1-0-3": "server-a"})
+	if len(defs) != len(graphDefs) {
+		t.Fatalf("invalid graph definitions: %d", len(defs))
+	}
+	for idx, def := range defs {
+		expected := []*mackerel.GraphDefsMetric{
+			{Name: graphDefs[idx].Name + ".Gi1-0-1", DisplayName: "uplink"},
+			{Name: graphDefs[idx].Name + ".Gi1-0-3", DisplayName: "server-a"},
+			{Name: graphDefs[idx].Name + ".*", DisplayName: "%1"},
+		}
+		if !reflect.DeepEqual(def.Metrics, expected) {
+			t.Errorf("invalid metrics of %s: %v", def.Name, def.Metrics)
+		}
+	}
+	// 元のグラフ定義は変更しない
+	if len(graphDefs[0].Metrics) != 1 {
+		t.Error("graphDefs is modified")
+	}
+}
+
 func TestFindHostByCustomIdentifierContext(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
 		mc := &Mackerel{client: &mackerelClientMock{returnHost: &mackerel.Host{ID: "host123"}}}
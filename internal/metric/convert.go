@@ -2,7 +2,10 @@ package metric
 
 import (
 	"fmt"
+	"log/slog"
+	"maps"
 	"math"
+	"slices"
 	"strings"
 	"time"
 
@@ -14,37 +17,118 @@ import (
 type Converter struct {
 	prevSnapshot  []collector.MetricsDutum
 	lastExecution time.Time
+	// エラー数や破棄数などのカウンタも秒間の値に変換する
+	perSecond bool
+
+	// 絞り込み前の ifIndex:インターフェイス名 と、そこから決めたメトリック名に使うインターフェイス名
+	ifNames map[uint64]string
+	names   map[uint64]string
 }
 
-func NewConverter() *Converter {
-	return &Converter{}
+func NewConverter(perSecond bool) *Converter {
+	return &Converter{perSecond: perSecond}
 }
 
 func (c *Converter) Reset() {
 	c.prevSnapshot = []collector.MetricsDutum{}
 }
 
-func (c *Converter) Convert(rawMetrics []collector.MetricsDutum, now time.Time) []*mackerel.MetricValue {
+// ifNames は絞り込み前のすべてのインターフェイスの名前で、重複の判定に使う
+func (c *Converter) Convert(rawMetrics []collector.MetricsDutum, ifNames map[uint64]string, now time.Time) []*mackerel.MetricValue {
 	defer func() {
 		c.prevSnapshot = rawMetrics
 		c.lastExecution = now
 	}()
+	if c.names == nil || !maps.Equal(c.ifNames, ifNames) {
+		c.updateNames(ifNames)
+	}
 
 	if len(c.prevSnapshot) == 0 {
 		return nil
 	}
-	return convert(rawMetrics, c.prevSnapshot, now, c.lastExecution)
+	return convert(rawMetrics, c.prevSnapshot, c.names, now, c.lastExecution, c.perSecond)
+}
+
+func (c *Converter) updateNames(ifNames map[uint64]string) {
+	names, duplicates := interfaceMetricNames(ifNames)
+	for name, ifIndexes := range duplicates {
+		slog.Warn("interface name is duplicated, ifIndex is appended to the metric name",
+			slog.String("name", name), slog.Any("ifIndexes", ifIndexes))
+	}
+	c.ifNames = ifNames
+	c.names = names
+}
+
+// InterfaceMetricNames は ifIndex:メトリック名に使うインターフェイス名 を返す。Converter と同じ名前になる
+func InterfaceMetricNames(ifNames map[uint64]string) map[uint64]string {
+	names, _ := interfaceMetricNames(ifNames)
+	return names
 }
 
-func convert(rawMetrics, prevSnapshot []collector.MetricsDutum, now, lastExecution time.Time) []*mackerel.MetricValue {
+// interfaceMetricNames はメトリック名に使うインターフェイス名を ifIndex ごとに返す
+// エスケープ後の名前が複数の ifIndex で重複する場合は、該当するすべてに "-<ifIndex>" を付与する
+// 付与した名前がほかのインターフェイスの名前と重なる場合は、重ならなくなるまで "-<ifIndex>" を付与する
+// 絞り込み前のすべてのインターフェイスの名前と ifIndex のみから決まるため、再起動や絞り込みの結果によらず同じ名前になる
+func interfaceMetricNames(ifNames map[uint64]string) (map[uint64]string, map[string][]uint64) {
+	names := make(map[uint64]string, len(ifNames))
+	ifIndexes := make(map[string][]uint64)
+	for ifIndex, ifName := range ifNames {
+		name := escapeInterfaceName(ifName)
+		names[ifIndex] = name
+		ifIndexes[name] = append(ifIndexes[name], ifIndex)
+	}
+
+	duplicates := make(map[string][]uint64)
+	for name, indexes := range ifIndexes {
+		if len(indexes) < 2 {
+			continue
+		}
+		slices.Sort(indexes)
+		duplicates[name] = indexes
+	}
+
+	// 使用済みの名前。付与した名前同士も重ならないよう、名前順に決める
+	taken := make(map[string]struct{}, len(ifIndexes))
+	for name := range ifIndexes {
+		taken[name] = struct{}{}
+	}
+	for _, name := range slices.Sorted(maps.Keys(duplicates)) {
+		for _, ifIndex := range duplicates[name] {
+			unique := fmt.Sprintf("%s-%d", name, ifIndex)
+			for {
+				if _, exists := taken[unique]; !exists {
+					break
+				}
+				unique = fmt.Sprintf("%s-%d", unique, ifIndex)
+			}
+			taken[unique] = struct{}{}
+			names[ifIndex] = unique
+		}
+	}
+	return names, duplicates
+}
+
+// now, lastExecution は値を取得した時刻で、ポーリングが遅れた場合でも実際の間隔で秒間の値に変換する
+// names は ifIndex:メトリック名に使うインターフェイス名
+func convert(rawMetrics, prevSnapshot []collector.MetricsDutum, names map[uint64]string, now, lastExecution time.Time, perSecond bool) []*mackerel.MetricValue {
+	// 時刻が戻った場合や前回と同じ時刻の場合は、秒間の値を計算できないため何も返さない
+	elapsed := now.Sub(lastExecution).Seconds()
+	if elapsed <= 0 {
+		return nil
+	}
+
 	metrics := make([]*mackerel.MetricValue, 0)
 	for _, metric := range rawMetrics {
 		prevValue := metric.Value
-		var found bool
+		var (
+			found         bool
+			discontinuous bool
+		)
 		for _, v := range prevSnapshot {
 			if v.IfIndex == metric.IfIndex && v.Mib == metric.Mib {
 				prevValue = v.Value
 				found = true
+				discontinuous = v.Discontinuity != metric.Discontinuity
 				break
 			}
 		}
@@ -52,20 +136,64 @@ func convert(rawMetrics, prevSnapshot []collector.MetricsDutum, now, lastExecuti
 		if !found {
 			continue
 		}
+		// カウンタが再初期化された場合は、桁あふれとして扱うと誤った値になるので、今回の値を基準にしなおす
+		if discontinuous && !gaugeValues(metric.Mib) {
+			continue
+		}
 
-		value := calcurateDiff(prevValue, metric.Value, overflowValue(metric.Mib))
+		diff := calcurateDiff(prevValue, metric.Value, overflowValue(metric.Mib))
 
-		var name string
-		ifName := escapeInterfaceName(metric.IfName)
-		if deltaValues(metric.Mib) {
+		var (
+			name  string
+			value any
+		)
+		ifName := metricInterfaceName(names, metric)
+		switch {
+		case deltaValues(metric.Mib):
 			direction := "txBytes"
 			if receiveDirection(metric.Mib) {
 				direction = "rxBytes"
 			}
 			name = fmt.Sprintf("interface.%s.%s.delta", ifName, direction)
-			value /= uint64(now.Sub(lastExecution).Seconds())
-		} else {
+			value = float64(diff) / elapsed
+
+			if metric.Speed > 0 {
+				utilization := "outUtilization"
+				if receiveDirection(metric.Mib) {
+					utilization = "inUtilization"
+				}
+				bps := float64(diff) * 8 / elapsed
+				metrics = append(metrics, &mackerel.MetricValue{
+					Name:  fmt.Sprintf("custom.interface.%s.%s", utilization, ifName),
+					Time:  now.Unix(),
+					Value: bps / float64(metric.Speed) * 100,
+				})
+			}
+		case metric.Mib == "ifLastChange":
+			// 前回から ifLastChange が変化していれば、リンク状態が変化したとみなす
+			// 取得間隔の間に複数回変化しても 1 となるため、回数ではなく変化の有無として投稿する
+			// ifLastChange は sysUpTime の値なので、戻った場合は再起動による変化であり、リンク状態の変化とはみなさない
+			if metric.Value < prevValue {
+				continue
+			}
+			name = fmt.Sprintf("custom.interface.linkStateChanged.%s", ifName)
+			value = uint64(0)
+			if metric.Value != prevValue {
+				value = uint64(1)
+			}
+		case gaugeValues(metric.Mib):
+			name = fmt.Sprintf("custom.interface.%s.%s", metric.Mib, ifName)
+			value = metric.Value
+		case rateValues(metric.Mib):
 			name = fmt.Sprintf("custom.interface.%s.%s", metric.Mib, ifName)
+			value = float64(diff) / elapsed
+		case perSecond:
+			// 既存のグラフと単位が混ざらないように、別の名前で投稿する
+			name = fmt.Sprintf("custom.interface.%sPerSec.%s", metric.Mib, ifName)
+			value = float64(diff) / elapsed
+		default:
+			name = fmt.Sprintf("custom.interface.%s.%s", metric.Mib, ifName)
+			value = diff
 		}
 		metrics = append(metrics, &mackerel.MetricValue{
 			Name:  name,
@@ -73,15 +201,71 @@ func convert(rawMetrics, prevSnapshot []collector.MetricsDutum, now, lastExecuti
 			Value: value,
 		})
 	}
+	return append(metrics, adminUpOperDown(rawMetrics, names, now)...)
+}
+
+const (
+	statusUp = 1
+)
+
+// adminUpOperDown は ifAdminStatus が up(1) にもかかわらず ifOperStatus が up(1) でないインターフェイスを 1 とする
+func adminUpOperDown(rawMetrics []collector.MetricsDutum, names map[uint64]string, now time.Time) []*mackerel.MetricValue {
+	adminStatus := make(map[uint64]uint64)
+	operStatus := make(map[uint64]uint64)
+	for _, metric := range rawMetrics {
+		switch metric.Mib {
+		case "ifAdminStatus":
+			adminStatus[metric.IfIndex] = metric.Value
+		case "ifOperStatus":
+			operStatus[metric.IfIndex] = metric.Value
+		}
+	}
+
+	var metrics []*mackerel.MetricValue
+	for _, metric := range rawMetrics {
+		if metric.Mib != "ifOperStatus" {
+			continue
+		}
+		admin, ok := adminStatus[metric.IfIndex]
+		if !ok {
+			continue
+		}
+		value := uint64(0)
+		if admin == statusUp && operStatus[metric.IfIndex] != statusUp {
+			value = 1
+		}
+		metrics = append(metrics, &mackerel.MetricValue{
+			Name:  fmt.Sprintf("custom.interface.adminUpOperDown.%s", metricInterfaceName(names, metric)),
+			Time:  now.Unix(),
+			Value: value,
+		})
+	}
 	return metrics
 }
 
+// names に含まれない場合は、インターフェイス名をエスケープして使う
+func metricInterfaceName(names map[uint64]string, metric collector.MetricsDutum) string {
+	if name, ok := names[metric.IfIndex]; ok {
+		return name
+	}
+	return escapeInterfaceName(metric.IfName)
+}
+
 func escapeInterfaceName(ifName string) string {
-	return strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(ifName, "/", "-"), ".", "_"), " ", "")
+	escaped := strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(ifName, "/", "-"), ".", "_"), " ", "")
+	// ifAlias などに含まれる、メトリック名に使えない文字
+	return strings.Map(func(r rune) rune {
+		if ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, escaped)
 }
 
 func overflowValue(mib string) uint64 {
-	if mib == "ifInOctets" || mib == "ifOutOctets" {
+	switch mib {
+	// Counter32
+	case "ifInOctets", "ifOutOctets", "ifInDiscards", "ifOutDiscards", "ifInErrors", "ifOutErrors", "ifInUnknownProtos":
 		return math.MaxUint32
 	}
 	return math.MaxUint64
@@ -95,6 +279,22 @@ func deltaValues(mib string) bool {
 	return mib == "ifInOctets" || mib == "ifOutOctets" || mib == "ifHCInOctets" || mib == "ifHCOutOctets"
 }
 
+// 差分ではなく、取得した値をそのまま投稿する
+func gaugeValues(mib string) bool {
+	return mib == "ifOutQLen" || mib == "ifHighSpeed" || mib == "ifOperStatus" || mib == "ifAdminStatus"
+}
+
+// 差分を秒間の値に変換して投稿する
+func rateValues(mib string) bool {
+	switch mib {
+	case "ifHCInUcastPkts", "ifHCOutUcastPkts",
+		"ifHCInMulticastPkts", "ifHCOutMulticastPkts",
+		"ifHCInBroadcastPkts", "ifHCOutBroadcastPkts":
+		return true
+	}
+	return false
+}
+
 func calcurateDiff(a, b, overflow uint64) uint64 {
 	if b < a {
 		return overflow - a + b
@@ -21,6 +21,7 @@ func compare[T any](t *testing.T, a, b T) {
 
 func TestEscapeInterfaceName(t *testing.T) {
 	compare(t, escapeInterfaceName("a/1.hello hello"), "a-1_hellohello")
+	compare(t, escapeInterfaceName("uplink:core#1 (10G)"), "uplink_core_1_10G_")
 }
 func TestCalcurateDiff(t *testing.T) {
 	compare(t, calcurateDiff(1, 2, 4), 1)
@@ -73,18 +74,18 @@ func Test_convert(t *testing.T) {
 			IfName:  "eth0",
 			Value:   1,
 		},
-	}, prevSnapshot, now, lastExecution)
+	}, prevSnapshot, nil, now, lastExecution, false)
 
 	expected := []*mackerel.MetricValue{
 		{
 			Name:  "interface.eth0.rxBytes.delta",
 			Time:  time.Now().Unix(),
-			Value: uint64(0),
+			Value: float64(0),
 		},
 		{
 			Name:  "interface.eth0.txBytes.delta",
 			Time:  time.Now().Unix(),
-			Value: uint64(1),
+			Value: float64(1),
 		},
 		{
 			Name:  "custom.interface.ifInDiscards.eth0",
@@ -134,7 +135,7 @@ func Test_convert_scenario(t *testing.T) {
 			},
 			expected: []*mackerel.MetricValue{
 				{
-					Name: "interface.eth0.rxBytes.delta", Time: time.Now().Unix(), Value: uint64(1),
+					Name: "interface.eth0.rxBytes.delta", Time: time.Now().Unix(), Value: float64(1),
 				},
 			},
 		},
@@ -155,10 +156,10 @@ func Test_convert_scenario(t *testing.T) {
 			},
 			expected: []*mackerel.MetricValue{
 				{
-					Name: "interface.eth0.rxBytes.delta", Time: time.Now().Unix(), Value: uint64(1),
+					Name: "interface.eth0.rxBytes.delta", Time: time.Now().Unix(), Value: float64(1),
 				},
 				{
-					Name: "interface.eth0.txBytes.delta", Time: time.Now().Unix(), Value: uint64(1),
+					Name: "interface.eth0.txBytes.delta", Time: time.Now().Unix(), Value: float64(1),
 				},
 			},
 		},
@@ -179,10 +180,10 @@ func Test_convert_scenario(t *testing.T) {
 			},
 			expected: []*mackerel.MetricValue{
 				{
-					Name: "interface.eth0.rxBytes.delta", Time: time.Now().Unix(), Value: uint64(1),
+					Name: "interface.eth0.rxBytes.delta", Time: time.Now().Unix(), Value: float64(1),
 				},
 				{
-					Name: "interface.eth0.txBytes.delta", Time: time.Now().Unix(), Value: uint64(1),
+					Name: "interface.eth0.txBytes.delta", Time: time.Now().Unix(), Value: float64(1),
 				},
 			},
 		},
@@ -197,7 +198,7 @@ func Test_convert_scenario(t *testing.T) {
 			},
 			expected: []*mackerel.MetricValue{
 				{
-					Name: "interface.eth0.txBytes.delta", Time: time.Now().Unix(), Value: uint64(1),
+					Name: "interface.eth0.txBytes.delta", Time: time.Now().Unix(), Value: float64(1),
 				},
 			},
 		},
@@ -218,7 +219,7 @@ func Test_convert_scenario(t *testing.T) {
 			},
 			expected: []*mackerel.MetricValue{
 				{
-					Name: "interface.eth0.txBytes.delta", Time: time.Now().Unix(), Value: uint64(1),
+					Name: "interface.eth0.txBytes.delta", Time: time.Now().Unix(), Value: float64(1),
 				},
 			},
 		},
@@ -239,10 +240,10 @@ func Test_convert_scenario(t *testing.T) {
 			},
 			expected: []*mackerel.MetricValue{
 				{
-					Name: "interface.eth0.rxBytes.delta", Time: time.Now().Unix(), Value: uint64(1),
+					Name: "interface.eth0.rxBytes.delta", Time: time.Now().Unix(), Value: float64(1),
 				},
 				{
-					Name: "interface.eth0.txBytes.delta", Time: time.Now().Unix(), Value: uint64(1),
+					Name: "interface.eth0.txBytes.delta", Time: time.Now().Unix(), Value: float64(1),
 				},
 			},
 		},
@@ -253,10 +254,259 @@ func Test_convert_scenario(t *testing.T) {
 		if i > 0 {
 			prevSnapshot = tests[i-1].input
 		}
-		actual := convert(tests[i].input, prevSnapshot, now, lastExecution)
+		actual := convert(tests[i].input, prevSnapshot, nil, now, lastExecution, false)
 
 		if diff := cmp.Diff(actual, tests[i].expected); diff != "" {
 			t.Errorf("value is mismatch (-actual +expected):%s", diff)
 		}
 	}
 }
+
+func TestInterfaceMetricNames(t *testing.T) {
+	names, duplicates := interfaceMetricNames(map[uint64]string{
+		10: "Ethernet",
+		2:  "Ethernet",
+		3:  "Gi1/0/1",
+		4:  "Gi1-0-1",
+		5:  "lo0",
+		// 付与した名前と重なる実在の名前
+		6: "Ethernet-2",
+	})
+
+	if diff := cmp.Diff(names, map[uint64]string{
+		10: "Ethernet-10",
+		2:  "Ethernet-2-2",
+		3:  "Gi1-0-1-3",
+		4:  "Gi1-0-1-4",
+		5:  "lo0",
+		6:  "Ethernet-2",
+	}); diff != "" {
+		t.Errorf("value is mismatch (-actual +expected):%s", diff)
+	}
+	if diff := cmp.Diff(duplicates, map[string][]uint64{
+		"Ethernet": {2, 10},
+		"Gi1-0-1":  {3, 4},
+	}); diff != "" {
+		t.Errorf("value is mismatch (-actual +expected):%s", diff)
+	}
+}
+
+func TestConvertDuplicatedInterfaceName(t *testing.T) {
+	now := time.Now()
+	c := NewConverter(false)
+	ifNames := map[uint64]string{1: "Ethernet", 2: "Ethernet", 3: "Ethernet"}
+	// ifIndex 3 は絞り込まれていても、重複の判定には含める
+	prev := []collector.MetricsDutum{
+		{IfIndex: 1, Mib: "ifInErrors", IfName: "Ethernet", Value: 1},
+		{IfIndex: 2, Mib: "ifInErrors", IfName: "Ethernet", Value: 1},
+	}
+	c.Convert(prev, ifNames, now.Add(-time.Minute))
+	actual := c.Convert([]collector.MetricsDutum{
+		{IfIndex: 1, Mib: "ifInErrors", IfName: "Ethernet", Value: 3},
+	}, ifNames, now)
+
+	expected := []*mackerel.MetricValue{
+		{Name: "custom.interface.ifInErrors.Ethernet-1", Time: now.Unix(), Value: uint64(2)},
+	}
+	if diff := cmp.Diff(actual, expected); diff != "" {
+		t.Errorf("value is mismatch (-actual +expected):%s", diff)
+	}
+}
+
+func TestConvertPacketsAndGauges(t *testing.T) {
+	now := time.Now()
+	lastExecution := now.Add(-time.Minute)
+	prevSnapshot := []collector.MetricsDutum{
+		{IfIndex: 1, Mib: "ifHCInBroadcastPkts", IfName: "eth0", Value: 1000},
+		{IfIndex: 1, Mib: "ifInUnknownProtos", IfName: "eth0", Value: math.MaxUint32 - 1},
+		{IfIndex: 1, Mib: "ifHighSpeed", IfName: "eth0", Value: 1000},
+		{IfIndex: 1, Mib: "ifOutQLen", IfName: "eth0", Value: 3},
+	}
+	actual := convert([]collector.MetricsDutum{
+		{IfIndex: 1, Mib: "ifHCInBroadcastPkts", IfName: "eth0", Value: 7000},
+		{IfIndex: 1, Mib: "ifInUnknownProtos", IfName: "eth0", Value: 2},
+		{IfIndex: 1, Mib: "ifHighSpeed", IfName: "eth0", Value: 10000},
+		{IfIndex: 1, Mib: "ifOutQLen", IfName: "eth0", Value: 5},
+	}, prevSnapshot, nil, now, lastExecution, false)
+
+	expected := []*mackerel.MetricValue{
+		{Name: "custom.interface.ifHCInBroadcastPkts.eth0", Time: now.Unix(), Value: float64(100)},
+		{Name: "custom.interface.ifInUnknownProtos.eth0", Time: now.Unix(), Value: uint64(3)},
+		{Name: "custom.interface.ifHighSpeed.eth0", Time: now.Unix(), Value: uint64(10000)},
+		{Name: "custom.interface.ifOutQLen.eth0", Time: now.Unix(), Value: uint64(5)},
+	}
+	if diff := cmp.Diff(actual, expected); diff != "" {
+		t.Errorf("value is mismatch (-actual +expected):%s", diff)
+	}
+}
+
+func TestConvertUtilization(t *testing.T) {
+	now := time.Now()
+	lastExecution := now.Add(-time.Minute)
+	prevSnapshot := []collector.MetricsDutum{
+		{IfIndex: 1, Mib: "ifHCInOctets", IfName: "eth0", Value: 0, Speed: 1_000_000_000},
+		{IfIndex: 1, Mib: "ifHCOutOctets", IfName: "eth0", Value: 0, Speed: 1_000_000_000},
+		{IfIndex: 2, Mib: "ifHCInOctets", IfName: "eth1", Value: 0},
+	}
+	actual := convert([]collector.MetricsDutum{
+		// 60秒で 6GB = 800Mbps
+		{IfIndex: 1, Mib: "ifHCInOctets", IfName: "eth0", Value: 6_000_000_000, Speed: 1_000_000_000},
+		{IfIndex: 1, Mib: "ifHCOutOctets", IfName: "eth0", Value: 750_000_000, Speed: 1_000_000_000},
+		{IfIndex: 2, Mib: "ifHCInOctets", IfName: "eth1", Value: 60},
+	}, prevSnapshot, nil, now, lastExecution, false)
+
+	expected := []*mackerel.MetricValue{
+		{Name: "custom.interface.inUtilization.eth0", Time: now.Unix(), Value: float64(80)},
+		{Name: "interface.eth0.rxBytes.delta", Time: now.Unix(), Value: float64(100_000_000)},
+		{Name: "custom.interface.outUtilization.eth0", Time: now.Unix(), Value: float64(10)},
+		{Name: "interface.eth0.txBytes.delta", Time: now.Unix(), Value: float64(12_500_000)},
+		{Name: "interface.eth1.rxBytes.delta", Time: now.Unix(), Value: float64(1)},
+	}
+	if diff := cmp.Diff(actual, expected); diff != "" {
+		t.Errorf("value is mismatch (-actual +expected):%s", diff)
+	}
+}
+
+func TestConvertLinkState(t *testing.T) {
+	now := time.Now()
+	lastExecution := now.Add(-time.Minute)
+	prevSnapshot := []collector.MetricsDutum{
+		{IfIndex: 1, Mib: "ifAdminStatus", IfName: "eth0", Value: 1},
+		{IfIndex: 1, Mib: "ifOperStatus", IfName: "eth0", Value: 1},
+		{IfIndex: 1, Mib: "ifLastChange", IfName: "eth0", Value: 1000},
+		{IfIndex: 2, Mib: "ifAdminStatus", IfName: "eth1", Value: 2},
+		{IfIndex: 2, Mib: "ifOperStatus", IfName: "eth1", Value: 2},
+		{IfIndex: 2, Mib: "ifLastChange", IfName: "eth1", Value: 500},
+		{IfIndex: 3, Mib: "ifLastChange", IfName: "eth2", Value: 8000},
+	}
+	actual := convert([]collector.MetricsDutum{
+		{IfIndex: 1, Mib: "ifAdminStatus", IfName: "eth0", Value: 1},
+		{IfIndex: 1, Mib: "ifOperStatus", IfName: "eth0", Value: 2},
+		{IfIndex: 1, Mib: "ifLastChange", IfName: "eth0", Value: 6000},
+		{IfIndex: 2, Mib: "ifAdminStatus", IfName: "eth1", Value: 2},
+		{IfIndex: 2, Mib: "ifOperStatus", IfName: "eth1", Value: 2},
+		{IfIndex: 2, Mib: "ifLastChange", IfName: "eth1", Value: 500},
+		// sysUpTime が戻ったことによる変化は投稿しない
+		{IfIndex: 3, Mib: "ifLastChange", IfName: "eth2", Value: 300},
+	}, prevSnapshot, nil, now, lastExecution, false)
+
+	expected := []*mackerel.MetricValue{
+		{Name: "custom.interface.ifAdminStatus.eth0", Time: now.Unix(), Value: uint64(1)},
+		{Name: "custom.interface.ifOperStatus.eth0", Time: now.Unix(), Value: uint64(2)},
+		{Name: "custom.interface.linkStateChanged.eth0", Time: now.Unix(), Value: uint64(1)},
+		{Name: "custom.interface.ifAdminStatus.eth1", Time: now.Unix(), Value: uint64(2)},
+		{Name: "custom.interface.ifOperStatus.eth1", Time: now.Unix(), Value: uint64(2)},
+		{Name: "custom.interface.linkStateChanged.eth1", Time: now.Unix(), Value: uint64(0)},
+		{Name: "custom.interface.adminUpOperDown.eth0", Time: now.Unix(), Value: uint64(1)},
+		{Name: "custom.interface.adminUpOperDown.eth1", Time: now.Unix(), Value: uint64(0)},
+	}
+	if diff := cmp.Diff(actual, expected); diff != "" {
+		t.Errorf("value is mismatch (-actual +expected):%s", diff)
+	}
+}
+
+func TestConvertDiscontinuity(t *testing.T) {
+	now := time.Now()
+	lastExecution := now.Add(-time.Minute)
+	prevSnapshot := []collector.MetricsDutum{
+		{IfIndex: 1, Mib: "ifInErrors", IfName: "eth0", Value: 100, Discontinuity: 0},
+		{IfIndex: 2, Mib: "ifInErrors", IfName: "eth1", Value: 100, Discontinuity: 0},
+		{IfIndex: 2, Mib: "ifOutQLen", IfName: "eth1", Value: 3, Discontinuity: 0},
+	}
+	actual := convert([]collector.MetricsDutum{
+		{IfIndex: 1, Mib: "ifInErrors", IfName: "eth0", Value: 110, Discontinuity: 0},
+		// ラインカードのリセットで 0 から数えなおしている
+		{IfIndex: 2, Mib: "ifInErrors", IfName: "eth1", Value: 5, Discontinuity: 4200},
+		{IfIndex: 2, Mib: "ifOutQLen", IfName: "eth1", Value: 1, Discontinuity: 4200},
+	}, prevSnapshot, nil, now, lastExecution, false)
+
+	expected := []*mackerel.MetricValue{
+		{Name: "custom.interface.ifInErrors.eth0", Time: now.Unix(), Value: uint64(10)},
+		{Name: "custom.interface.ifOutQLen.eth1", Time: now.Unix(), Value: uint64(1)},
+	}
+	if diff := cmp.Diff(actual, expected); diff != "" {
+		t.Errorf("value is mismatch (-actual +expected):%s", diff)
+	}
+}
+
+func TestConvertPerSecond(t *testing.T) {
+	now := time.Now()
+	// ポーリングが遅れて 90 秒空いた
+	lastExecution := now.Add(-90 * time.Second)
+	prevSnapshot := []collector.MetricsDutum{
+		{IfIndex: 1, Mib: "ifInErrors", IfName: "eth0", Value: 100},
+		{IfIndex: 1, Mib: "ifOutDiscards", IfName: "eth0", Value: 100},
+		{IfIndex: 1, Mib: "ifOutQLen", IfName: "eth0", Value: 3},
+	}
+	actual := convert([]collector.MetricsDutum{
+		{IfIndex: 1, Mib: "ifInErrors", IfName: "eth0", Value: 280},
+		{IfIndex: 1, Mib: "ifOutDiscards", IfName: "eth0", Value: 145},
+		{IfIndex: 1, Mib: "ifOutQLen", IfName: "eth0", Value: 1},
+	}, prevSnapshot, nil, now, lastExecution, true)
+
+	expected := []*mackerel.MetricValue{
+		{Name: "custom.interface.ifInErrorsPerSec.eth0", Time: now.Unix(), Value: float64(2)},
+		{Name: "custom.interface.ifOutDiscardsPerSec.eth0", Time: now.Unix(), Value: float64(0.5)},
+		{Name: "custom.interface.ifOutQLen.eth0", Time: now.Unix(), Value: uint64(1)},
+	}
+	if diff := cmp.Diff(actual, expected); diff != "" {
+		t.Errorf("value is mismatch (-actual +expected):%s", diff)
+	}
+}
+
+func TestConvertSubSecondInterval(t *testing.T) {
+	now := time.Now()
+	prevSnapshot := []collector.MetricsDutum{
+		{IfIndex: 1, Mib: "ifHCInOctets", IfName: "eth0", Value: 0},
+	}
+	rawMetrics := []collector.MetricsDutum{
+		{IfIndex: 1, Mib: "ifHCInOctets", IfName: "eth0", Value: 300},
+	}
+
+	for _, tc := range []struct {
+		elapsed  time.Duration
+		expected []*mackerel.MetricValue
+	}{
+		// 1秒未満でも切り捨てずに秒間の値にする
+		{elapsed: 500 * time.Millisecond, expected: []*mackerel.MetricValue{
+			{Name: "interface.eth0.rxBytes.delta", Time: now.Unix(), Value: float64(600)},
+		}},
+		{elapsed: 1500 * time.Millisecond, expected: []*mackerel.MetricValue{
+			{Name: "interface.eth0.rxBytes.delta", Time: now.Unix(), Value: float64(200)},
+		}},
+		// 間隔が 0 の場合は計算できないため何も返さない
+		{elapsed: 0, expected: nil},
+	} {
+		actual := convert(rawMetrics, prevSnapshot, nil, now, now.Add(-tc.elapsed), false)
+		if diff := cmp.Diff(actual, tc.expected); diff != "" {
+			t.Errorf("elapsed=%s: value is mismatch (-actual +expected):%s", tc.elapsed, diff)
+		}
+	}
+}
+
+func TestConvertInterval(t *testing.T) {
+	now := time.Now()
+	// interval: 5m
+	lastExecution := now.Add(-5 * time.Minute)
+	prevSnapshot := []collector.MetricsDutum{
+		{IfIndex: 1, Mib: "ifHCInOctets", IfName: "eth0", Value: 0, Speed: 1000},
+		{IfIndex: 1, Mib: "ifHCInUcastPkts", IfName: "eth0", Value: 0},
+		{IfIndex: 1, Mib: "ifInErrors", IfName: "eth0", Value: 0},
+	}
+	actual := convert([]collector.MetricsDutum{
+		{IfIndex: 1, Mib: "ifHCInOctets", IfName: "eth0", Value: 30000, Speed: 1000},
+		{IfIndex: 1, Mib: "ifHCInUcastPkts", IfName: "eth0", Value: 600},
+		{IfIndex: 1, Mib: "ifInErrors", IfName: "eth0", Value: 7},
+	}, prevSnapshot, nil, now, lastExecution, false)
+
+	expected := []*mackerel.MetricValue{
+		{Name: "custom.interface.inUtilization.eth0", Time: now.Unix(), Value: float64(80)},
+		{Name: "interface.eth0.rxBytes.delta", Time: now.Unix(), Value: float64(100)},
+		{Name: "custom.interface.ifHCInUcastPkts.eth0", Time: now.Unix(), Value: float64(2)},
+		// 秒間の値に変換しない場合は、取得間隔での増分のまま
+		{Name: "custom.interface.ifInErrors.eth0", Time: now.Unix(), Value: uint64(7)},
+	}
+	if diff := cmp.Diff(actual, expected); diff != "" {
+		t.Errorf("value is mismatch (-actual +expected):%s", diff)
+	}
+}
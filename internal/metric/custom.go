@@ -1,34 +1,188 @@
 package metric
 
 import (
+	"math"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/mackerelio/mackerel-client-go"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/collector"
+	"github.com/mackerelio-labs/sabatrafficd/internal/snmp"
 )
 
 type Custom struct {
 	mapping map[string]string
+	// metricNamePrefix:columns
+	tables map[string]TableColumns
+	// counter として扱う metricName または metricNamePrefix
+	counters map[string]struct{}
+
+	// metricName:前回取得した counter の値
+	prevSamples map[string]counterSample
+	// table の行の metricName:前回取得した counter の値
+	prevRows map[string]counterSample
+}
+
+// TableColumns は table の値と行の名前を取得する列
+type TableColumns struct {
+	MIB     string
+	NameMIB string
+}
+
+type counterSample struct {
+	value uint64
+	// snmp.Value.CounterBits
+	bits int
+	time time.Time
+}
+
+func NewCustom(mapping map[string]string, tables map[string]TableColumns, counters []string) *Custom {
+	c := &Custom{
+		mapping:     mapping,
+		tables:      tables,
+		counters:    make(map[string]struct{}, len(counters)),
+		prevSamples: make(map[string]counterSample),
+		prevRows:    make(map[string]counterSample),
+	}
+	for _, name := range counters {
+		c.counters[name] = struct{}{}
+	}
+	return c
+}
+
+func (c *Custom) Reset() {
+	c.ResetScalars()
+	c.ResetTables()
 }
 
-func NewCustom(mapping map[string]string) *Custom {
-	return &Custom{mapping: mapping}
+// ResetScalars は custom-mibs の前回値のみを破棄する
+func (c *Custom) ResetScalars() {
+	c.prevSamples = make(map[string]counterSample)
 }
 
-func (c *Custom) Convert(resp map[string]float64) []*mackerel.MetricValue {
-	now := time.Now().Unix()
+// ResetTables は custom-mib-tables の前回値のみを破棄する
+func (c *Custom) ResetTables() {
+	c.prevRows = make(map[string]counterSample)
+}
 
+func (c *Custom) Convert(resp map[string]snmp.Value, now time.Time) []*mackerel.MetricValue {
 	metrics := make([]*mackerel.MetricValue, 0)
 	for metricName, mib := range c.mapping {
-		if f, ok := resp[mib]; ok {
+		v, ok := resp[mib]
+		if !ok {
+			continue
+		}
+		f := v.Float
+		if _, counter := c.counters[metricName]; counter {
+			if f, ok = rate(c.prevSamples, metricName, v, now); !ok {
+				continue
+			}
+		}
+		metrics = append(metrics, &mackerel.MetricValue{
+			Name:  metricName,
+			Time:  now.Unix(),
+			Value: f,
+		})
+	}
+	if len(metrics) > 0 {
+		return metrics
+	}
+	return nil
+}
+
+func (c *Custom) ConvertTable(rows []collector.CustomTableDutum, now time.Time) []*mackerel.MetricValue {
+	metrics := make([]*mackerel.MetricValue, 0)
+	// 今回取得した行。なくなった行の前回値は破棄する
+	seen := make(map[string]struct{}, len(c.prevRows))
+	for prefix, columns := range c.tables {
+		var tableRows []collector.CustomTableDutum
+		for _, row := range rows {
+			if row.MIB == columns.MIB && row.NameMIB == columns.NameMIB {
+				tableRows = append(tableRows, row)
+			}
+		}
+		_, counter := c.counters[prefix]
+
+		labels := tableRowLabels(tableRows)
+		for _, row := range tableRows {
+			name := prefix + "." + labels[row.Index]
+			value := row.Value.Float
+			if counter {
+				seen[name] = struct{}{}
+				var ok bool
+				if value, ok = rate(c.prevRows, name, row.Value, now); !ok {
+					continue
+				}
+			}
 			metrics = append(metrics, &mackerel.MetricValue{
-				Name:  metricName,
-				Time:  now,
-				Value: f,
+				Name:  name,
+				Time:  now.Unix(),
+				Value: value,
 			})
 		}
 	}
+	for name := range c.prevRows {
+		if _, ok := seen[name]; !ok {
+			delete(c.prevRows, name)
+		}
+	}
 	if len(metrics) > 0 {
 		return metrics
 	}
 	return nil
 }
+
+// rate は samples の前回値との差分を秒間の値に変換し、samples を今回の値で更新する。前回値がなければ false を返す
+func rate(samples map[string]counterSample, name string, value snmp.Value, now time.Time) (float64, bool) {
+	current := value.Counter
+	if value.CounterBits == 0 {
+		// counter 型でない値を counter として扱う場合
+		current = uint64(max(value.Float, 0))
+	}
+	prev, found := samples[name]
+	samples[name] = counterSample{value: current, bits: value.CounterBits, time: now}
+	if !found || prev.bits != value.CounterBits {
+		return 0, false
+	}
+
+	elapsed := now.Sub(prev.time).Seconds()
+	if elapsed <= 0 {
+		return 0, false
+	}
+	// 値が戻った場合、Counter32 は折り返しとして扱い、それ以外はリセットされたものとして取り直す
+	if current < prev.value && value.CounterBits != 32 {
+		return 0, false
+	}
+	diff := calcurateDiff(prev.value, current, math.MaxUint32)
+	return float64(diff) / elapsed, true
+}
+
+var invalidMetricNameRe = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+func escapeMetricName(name string) string {
+	return invalidMetricNameRe.ReplaceAllString(strings.ReplaceAll(name, " ", ""), "_")
+}
+
+// index:label
+// 名前が取得できない行はインデックスを、名前が重複する行は名前にインデックスを付与したものを使う
+func tableRowLabels(rows []collector.CustomTableDutum) map[string]string {
+	count := make(map[string]int, len(rows))
+	for _, row := range rows {
+		count[escapeMetricName(row.Name)]++
+	}
+
+	labels := make(map[string]string, len(rows))
+	for _, row := range rows {
+		label := escapeMetricName(row.Name)
+		switch {
+		case label == "":
+			label = escapeMetricName(row.Index)
+		case count[label] > 1:
+			label = label + "_" + escapeMetricName(row.Index)
+		}
+		labels[row.Index] = label
+	}
+	return labels
+}
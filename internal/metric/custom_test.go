@@ -1,11 +1,16 @@
 package metric
 
 import (
+	"math"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/mackerelio/mackerel-client-go"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/collector"
+	"github.com/mackerelio-labs/sabatrafficd/internal/snmp"
 )
 
 func TestConvertCustom(t *testing.T) {
@@ -16,17 +21,18 @@ func TestConvertCustom(t *testing.T) {
 		},
 	}
 
-	input := map[string]float64{
-		"1.2.3.4": 1.2345,
-		"3.4.5.6": 0.1234,
+	input := map[string]snmp.Value{
+		"1.2.3.4": {Float: 1.2345},
+		"3.4.5.6": {Float: 0.1234},
 	}
 
-	actual := c.Convert(input)
+	now := time.Now()
+	actual := c.Convert(input, now)
 
 	expected := []*mackerel.MetricValue{
 		{
 			Name:  "foo",
-			Time:  time.Now().Unix(),
+			Time:  now.Unix(),
 			Value: 1.2345,
 		},
 	}
@@ -35,3 +41,155 @@ func TestConvertCustom(t *testing.T) {
 		t.Errorf("value is mismatch (-actual +expected):%s", diff)
 	}
 }
+
+func TestConvertCustomTable(t *testing.T) {
+	c := &Custom{
+		tables: map[string]TableColumns{
+			"custom.custommibs.cpu": {MIB: "1.3.6.1.2.1.25.3.3.1.2", NameMIB: "1.3.6.1.2.1.25.3.2.1.3"},
+		},
+	}
+
+	input := []collector.CustomTableDutum{
+		{MIB: "1.3.6.1.2.1.25.3.3.1.2", NameMIB: "1.3.6.1.2.1.25.3.2.1.3", Index: "1", Name: "Slot 1/CPU", Value: snmp.Value{Float: 10}},
+		{MIB: "1.3.6.1.2.1.25.3.3.1.2", NameMIB: "1.3.6.1.2.1.25.3.2.1.3", Index: "2", Name: "CPU", Value: snmp.Value{Float: 20}},
+		{MIB: "1.3.6.1.2.1.25.3.3.1.2", NameMIB: "1.3.6.1.2.1.25.3.2.1.3", Index: "3", Name: "CPU", Value: snmp.Value{Float: 30}},
+		{MIB: "1.3.6.1.2.1.25.3.3.1.2", NameMIB: "1.3.6.1.2.1.25.3.2.1.3", Index: "4.1", Value: snmp.Value{Float: 40}},
+		{MIB: "1.3.6.1.2.1.99", Index: "1", Name: "other", Value: snmp.Value{Float: 50}},
+		// 値の列が同じでも、名前の列が異なる table の行は含めない
+		{MIB: "1.3.6.1.2.1.25.3.3.1.2", Index: "1", Value: snmp.Value{Float: 10}},
+	}
+
+	now := time.Now()
+	actual := c.ConvertTable(input, now)
+
+	expected := []*mackerel.MetricValue{
+		{Name: "custom.custommibs.cpu.Slot1_CPU", Time: now.Unix(), Value: float64(10)},
+		{Name: "custom.custommibs.cpu.CPU_2", Time: now.Unix(), Value: float64(20)},
+		{Name: "custom.custommibs.cpu.CPU_3", Time: now.Unix(), Value: float64(30)},
+		{Name: "custom.custommibs.cpu.4_1", Time: now.Unix(), Value: float64(40)},
+	}
+
+	if diff := cmp.Diff(actual, expected); diff != "" {
+		t.Errorf("value is mismatch (-actual +expected):%s", diff)
+	}
+}
+
+func TestConvertCustomCounter(t *testing.T) {
+	c := NewCustom(
+		map[string]string{
+			"counter": "1.2.3.4",
+			"gauge":   "2.3.4.5",
+		},
+		map[string]TableColumns{
+			"custom.custommibs.table": {MIB: "3.4.5.6"},
+		},
+		[]string{"counter", "custom.custommibs.table"},
+	)
+
+	counter32 := func(v uint64) snmp.Value { return snmp.Value{Float: float64(v), Counter: v, CounterBits: 32} }
+	counter64 := func(v uint64) snmp.Value { return snmp.Value{Float: float64(v), Counter: v, CounterBits: 64} }
+
+	now := time.Now()
+	tests := []struct {
+		scalar   map[string]snmp.Value
+		rows     []collector.CustomTableDutum
+		now      time.Time
+		expected []*mackerel.MetricValue
+	}{
+		{
+			scalar: map[string]snmp.Value{"1.2.3.4": counter32(100), "2.3.4.5": {Float: 5}},
+			rows:   []collector.CustomTableDutum{{MIB: "3.4.5.6", Index: "1", Value: counter64(10)}},
+			now:    now,
+			expected: []*mackerel.MetricValue{
+				{Name: "gauge", Time: now.Unix(), Value: float64(5)},
+			},
+		},
+		{
+			scalar: map[string]snmp.Value{"1.2.3.4": counter32(700), "2.3.4.5": {Float: 6}},
+			rows:   []collector.CustomTableDutum{{MIB: "3.4.5.6", Index: "1", Value: counter64(130)}},
+			now:    now.Add(time.Minute),
+			expected: []*mackerel.MetricValue{
+				{Name: "counter", Time: now.Add(time.Minute).Unix(), Value: float64(10)},
+				{Name: "gauge", Time: now.Add(time.Minute).Unix(), Value: float64(6)},
+				{Name: "custom.custommibs.table.1", Time: now.Add(time.Minute).Unix(), Value: float64(2)},
+			},
+		},
+		{
+			// Counter32 の折り返し
+			scalar: map[string]snmp.Value{"1.2.3.4": counter32(59)},
+			rows:   []collector.CustomTableDutum{{MIB: "3.4.5.6", Index: "1", Value: counter64(250)}},
+			now:    now.Add(3 * time.Minute),
+			expected: []*mackerel.MetricValue{
+				{Name: "counter", Time: now.Add(3 * time.Minute).Unix(), Value: float64(math.MaxUint32-700+59) / 120},
+				{Name: "custom.custommibs.table.1", Time: now.Add(3 * time.Minute).Unix(), Value: float64(1)},
+			},
+		},
+		{
+			// Counter64 は折り返さないため、値が戻った場合はリセットとして扱う
+			scalar: map[string]snmp.Value{"1.2.3.4": counter32(119)},
+			rows:   []collector.CustomTableDutum{{MIB: "3.4.5.6", Index: "1", Value: counter64(5)}},
+			now:    now.Add(4 * time.Minute),
+			expected: []*mackerel.MetricValue{
+				{Name: "counter", Time: now.Add(4 * time.Minute).Unix(), Value: float64(1)},
+			},
+		},
+		{
+			// 型が変わった場合は基準値を取り直す
+			scalar: map[string]snmp.Value{"1.2.3.4": counter64(1 << 60)},
+			rows:   []collector.CustomTableDutum{{MIB: "3.4.5.6", Index: "1", Value: counter64(65)}},
+			now:    now.Add(5 * time.Minute),
+			expected: []*mackerel.MetricValue{
+				{Name: "custom.custommibs.table.1", Time: now.Add(5 * time.Minute).Unix(), Value: float64(1)},
+			},
+		},
+		{
+			// 2^53 を超える値でも差分の精度が落ちない
+			scalar: map[string]snmp.Value{"1.2.3.4": counter64(1<<60 + 60)},
+			now:    now.Add(6 * time.Minute),
+			expected: []*mackerel.MetricValue{
+				{Name: "counter", Time: now.Add(6 * time.Minute).Unix(), Value: float64(1)},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		actual := c.Convert(tc.scalar, tc.now)
+		actual = append(actual, c.ConvertTable(tc.rows, tc.now)...)
+
+		if diff := cmp.Diff(actual, tc.expected, cmpopts.SortSlices(func(i, j *mackerel.MetricValue) bool { return i.Name < j.Name })); diff != "" {
+			t.Errorf("value is mismatch (-actual +expected):%s", diff)
+		}
+	}
+}
+
+func TestConvertCustomTableReset(t *testing.T) {
+	c := NewCustom(
+		map[string]string{"counter": "1.2.3.4"},
+		map[string]TableColumns{"custom.custommibs.table": {MIB: "3.4.5.6"}},
+		[]string{"counter", "custom.custommibs.table"},
+	)
+	counter64 := func(v uint64) snmp.Value { return snmp.Value{Float: float64(v), Counter: v, CounterBits: 64} }
+	row := func(index string, v uint64) collector.CustomTableDutum {
+		return collector.CustomTableDutum{MIB: "3.4.5.6", Index: index, Value: counter64(v)}
+	}
+	now := time.Now()
+
+	c.Convert(map[string]snmp.Value{"1.2.3.4": counter64(0)}, now)
+	c.ConvertTable([]collector.CustomTableDutum{row("1", 0), row("2", 0)}, now)
+	// 取得できなかった行の前回値は破棄する
+	c.ConvertTable([]collector.CustomTableDutum{row("1", 60)}, now.Add(time.Minute))
+	if _, ok := c.prevRows["custom.custommibs.table.2"]; ok {
+		t.Error("sample of missing row is kept")
+	}
+
+	// table の前回値を破棄しても custom-mibs の前回値は残る
+	c.ResetTables()
+	actual := c.Convert(map[string]snmp.Value{"1.2.3.4": counter64(120)}, now.Add(2*time.Minute))
+	expected := []*mackerel.MetricValue{{Name: "counter", Time: now.Add(2 * time.Minute).Unix(), Value: float64(1)}}
+	if diff := cmp.Diff(actual, expected); diff != "" {
+		t.Errorf("value is mismatch (-actual +expected):%s", diff)
+	}
+	if actual := c.ConvertTable([]collector.CustomTableDutum{row("1", 180)}, now.Add(2*time.Minute)); actual != nil {
+		t.Errorf("table should be rebaselined: %v", actual)
+	}
+}
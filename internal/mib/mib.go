@@ -3,6 +3,8 @@ package mib
 import (
 	"fmt"
 	"regexp"
+	"slices"
+	"strings"
 )
 
 func Oidmapping() map[string]string {
@@ -10,27 +12,86 @@ func Oidmapping() map[string]string {
 }
 
 var oidMapping = map[string]string{
-	"ifInOctets":    "1.3.6.1.2.1.2.2.1.10",
-	"ifOutOctets":   "1.3.6.1.2.1.2.2.1.16",
-	"ifHCInOctets":  "1.3.6.1.2.1.31.1.1.1.6",
-	"ifHCOutOctets": "1.3.6.1.2.1.31.1.1.1.10",
-	"ifInDiscards":  "1.3.6.1.2.1.2.2.1.13",
-	"ifOutDiscards": "1.3.6.1.2.1.2.2.1.19",
-	"ifInErrors":    "1.3.6.1.2.1.2.2.1.14",
-	"ifOutErrors":   "1.3.6.1.2.1.2.2.1.20",
+	"ifInOctets":           "1.3.6.1.2.1.2.2.1.10",
+	"ifOutOctets":          "1.3.6.1.2.1.2.2.1.16",
+	"ifHCInOctets":         "1.3.6.1.2.1.31.1.1.1.6",
+	"ifHCOutOctets":        "1.3.6.1.2.1.31.1.1.1.10",
+	"ifInDiscards":         "1.3.6.1.2.1.2.2.1.13",
+	"ifOutDiscards":        "1.3.6.1.2.1.2.2.1.19",
+	"ifInErrors":           "1.3.6.1.2.1.2.2.1.14",
+	"ifOutErrors":          "1.3.6.1.2.1.2.2.1.20",
+	"ifAdminStatus":        "1.3.6.1.2.1.2.2.1.7",
+	"ifOperStatus":         "1.3.6.1.2.1.2.2.1.8",
+	"ifLastChange":         "1.3.6.1.2.1.2.2.1.9",
+	"ifInUnknownProtos":    "1.3.6.1.2.1.2.2.1.15",
+	"ifOutQLen":            "1.3.6.1.2.1.2.2.1.21",
+	"ifHCInUcastPkts":      "1.3.6.1.2.1.31.1.1.1.7",
+	"ifHCInMulticastPkts":  "1.3.6.1.2.1.31.1.1.1.8",
+	"ifHCInBroadcastPkts":  "1.3.6.1.2.1.31.1.1.1.9",
+	"ifHCOutUcastPkts":     "1.3.6.1.2.1.31.1.1.1.11",
+	"ifHCOutMulticastPkts": "1.3.6.1.2.1.31.1.1.1.12",
+	"ifHCOutBroadcastPkts": "1.3.6.1.2.1.31.1.1.1.13",
+	"ifHighSpeed":          "1.3.6.1.2.1.31.1.1.1.15",
+}
+
+// 取得した値がカウンタではない MIB
+var gaugeMIBs = []string{
+	"ifAdminStatus",
+	"ifOperStatus",
+	"ifLastChange",
+	"ifOutQLen",
+	"ifHighSpeed",
+}
+
+// IsCounter は mibs で指定できる MIB の値が単調増加するカウンタかを返す
+func IsCounter(name string) bool {
+	return !slices.Contains(gaugeMIBs, name)
+}
+
+// mibs を指定しない場合に取得する MIB
+var defaultMIBs = []string{
+	"ifHCInOctets",
+	"ifHCOutOctets",
+	"ifInDiscards",
+	"ifOutDiscards",
+	"ifInErrors",
+	"ifOutErrors",
+}
+
+// インターフェイス名として使える MIB
+var interfaceNameMapping = map[string]string{
+	"ifDescr": "1.3.6.1.2.1.2.2.1.2",
+	"ifName":  "1.3.6.1.2.1.31.1.1.1.1",
+	"ifAlias": "1.3.6.1.2.1.31.1.1.1.18",
+}
+
+func InterfaceNameMapping() map[string]string {
+	return interfaceNameMapping
+}
+
+// ValidateInterfaceNames はインターフェイス名の取得元を検証する。未指定の場合は ifDescr のみを使う
+func ValidateInterfaceNames(rawNames []string) ([]string, error) {
+	if len(rawNames) == 0 {
+		return []string{"ifDescr"}, nil
+	}
+
+	var names []string
+	for _, name := range rawNames {
+		if _, exists := interfaceNameMapping[name]; !exists {
+			return nil, fmt.Errorf("interface name %s is not supported", name)
+		}
+		if slices.Contains(names, name) {
+			return nil, fmt.Errorf("interface name %s is duplicated", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
 }
 
 func Validate(rawMibs []string) ([]string, error) {
 	var parseMibs []string
 	if len(rawMibs) == 0 {
-		for key := range Oidmapping() {
-			// skipped 32 bit octets.
-			if key == "ifInOctets" || key == "ifOutOctets" {
-				continue
-			}
-			parseMibs = append(parseMibs, key)
-		}
-		return parseMibs, nil
+		return slices.Clone(defaultMIBs), nil
 	}
 
 	for _, name := range rawMibs {
@@ -44,10 +105,21 @@ func Validate(rawMibs []string) ([]string, error) {
 
 var re = regexp.MustCompile(`^([\d]+\.)+[\d]+$`)
 
-// TODO smi support
-func ValidateCustom(mib string) error {
-	if valid := re.MatchString(mib); !valid {
-		return fmt.Errorf("mib '%s' is not supported", mib)
+// ResolveCustom は数値OIDはそのまま、シンボル名は modules を使って数値OIDに変換する
+func ResolveCustom(mib string, modules *Modules) (string, error) {
+	if re.MatchString(mib) {
+		return mib, nil
+	}
+	if !isIdentifier(mib) || strings.HasSuffix(mib, ".") {
+		return "", fmt.Errorf("mib '%s' is not supported", mib)
+	}
+	if modules == nil {
+		moduleName, symbol, ok := strings.Cut(mib, "::")
+		if !ok {
+			moduleName, symbol = "", mib
+		}
+		symbol, _, _ = strings.Cut(symbol, ".")
+		return "", &UnresolvedError{Name: mib, Module: moduleName, Symbol: symbol, reason: "mib-directory is not configured"}
 	}
-	return nil
+	return modules.Resolve(mib)
 }
@@ -1,6 +1,7 @@
 package mib
 
 import (
+	"errors"
 	"maps"
 	"slices"
 	"testing"
@@ -65,25 +66,38 @@ func TestValidate(t *testing.T) {
 
 }
 
-func TestValidateCustom(t *testing.T) {
-	var cases = map[string]bool{
-		"DISMAN-EVENT-MIB::sysUpTimeInstance": false,
-		"1.2.3.4.":                            false,
-		".1.2.3.4":                            false,
-		"1.2.3.4.5.6":                         true,
+func TestValidateInterfaceNames(t *testing.T) {
+	var cases = []struct {
+		source   []string
+		expected []string
+		wantErr  bool
+	}{
+		{source: nil, expected: []string{"ifDescr"}},
+		{source: []string{"ifName", "ifDescr"}, expected: []string{"ifName", "ifDescr"}},
+		{source: []string{"ifAlias", "ifName", "ifDescr"}, expected: []string{"ifAlias", "ifName", "ifDescr"}},
+		{source: []string{"ifName", "ifName"}, wantErr: true},
+		{source: []string{"ifType"}, wantErr: true},
 	}
 
-	for tc, isValid := range cases {
-		actual := ValidateCustom(tc)
-		if (actual == nil) != isValid {
-			t.Errorf("not a match : %s", tc)
+	for _, tc := range cases {
+		actual, err := ValidateInterfaceNames(tc.source)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%v: unexpected error %v", tc.source, err)
+		}
+		if diff := cmp.Diff(actual, tc.expected); diff != "" {
+			t.Errorf("%v: value is mismatch (-actual +expected):%s", tc.source, diff)
 		}
 	}
 }
 
 func TestOidMapping(t *testing.T) {
 	actual := slices.Collect(maps.Keys(oidMapping))
-	expected := []string{"ifInOctets", "ifOutOctets", "ifHCInOctets", "ifHCOutOctets", "ifInDiscards", "ifOutDiscards", "ifInErrors", "ifOutErrors"}
+	expected := []string{
+		"ifInOctets", "ifOutOctets", "ifHCInOctets", "ifHCOutOctets", "ifInDiscards", "ifOutDiscards", "ifInErrors", "ifOutErrors",
+		"ifAdminStatus", "ifOperStatus", "ifLastChange",
+		"ifInUnknownProtos", "ifOutQLen", "ifHighSpeed",
+		"ifHCInUcastPkts", "ifHCOutUcastPkts", "ifHCInMulticastPkts", "ifHCOutMulticastPkts", "ifHCInBroadcastPkts", "ifHCOutBroadcastPkts",
+	}
 
 	if diff := cmp.Diff(
 		actual,
@@ -93,3 +107,50 @@ func TestOidMapping(t *testing.T) {
 		t.Errorf("value is mismatch (-actual +expected):%s", diff)
 	}
 }
+
+func TestResolveCustom(t *testing.T) {
+	modules, err := LoadModules("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    string
+		modules  *Modules
+		expected string
+		wantErr  bool
+	}{
+		{input: "1.3.6.1.2.1.1.3.0", expected: "1.3.6.1.2.1.1.3.0"},
+		{input: "SNMPv2-MIB::sysUpTime.0", wantErr: true},
+		{input: "SNMPv2-MIB::sysUpTime.0", modules: modules, expected: "1.3.6.1.2.1.1.3.0"},
+		{input: "SNMPv2-MIB::sysDescr", modules: modules, expected: "1.3.6.1.2.1.1.1"},
+		{input: "snmpInPkts.0", modules: modules, expected: "1.3.6.1.2.1.11.1.0"},
+		{input: "coldStart", modules: modules, expected: "1.3.6.1.6.3.1.1.5.1"},
+		{input: "hrProcessorLoad.196608", modules: modules, expected: "1.3.6.1.2.1.25.3.3.1.2.196608"},
+		{input: "HOST-RESOURCES-MIB::hrProcessorLoad.1.2", modules: modules, expected: "1.3.6.1.2.1.25.3.3.1.2.1.2"},
+		{input: "HOST-RESOURCES-MIB::sysUpTime.0", modules: modules, wantErr: true},
+		{input: "IF-MIB::ifDescr", modules: modules, wantErr: true},
+		{input: "unknownSymbol.0", modules: modules, wantErr: true},
+		{input: "sysUpTime.a", modules: modules, wantErr: true},
+		{input: "sysUpTime.", modules: modules, wantErr: true},
+		{input: ".1.3.6", modules: modules, wantErr: true},
+		{input: "1.2.3.4.", wantErr: true},
+		{input: ".1.2.3.4", wantErr: true},
+	}
+	for _, tc := range tests {
+		actual, err := ResolveCustom(tc.input, tc.modules)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: unexpected error %v", tc.input, err)
+		}
+		if actual != tc.expected {
+			t.Errorf("%s: invalid actual: %s, expected: %s", tc.input, actual, tc.expected)
+		}
+	}
+
+	// 未定義のシンボルはモジュール名とシンボル名を返す
+	_, err = ResolveCustom("HOST-RESOURCES-MIB::sysUpTime.0", modules)
+	var unresolved *UnresolvedError
+	if !errors.As(err, &unresolved) || unresolved.Module != "HOST-RESOURCES-MIB" || unresolved.Symbol != "sysUpTime" {
+		t.Errorf("invalid error: %v", err)
+	}
+}
@@ -0,0 +1,391 @@
+package mib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Modules は MIB ファイルから読み込んだ OID 定義を保持する
+type Modules struct {
+	modules map[string]*module
+	// 読み込んだ順序。モジュール名を省略した問い合わせの探索順に使う
+	order []string
+}
+
+type module struct {
+	name    string
+	defs    map[string][]oidComponent
+	imports map[string]string // symbol:module
+}
+
+type oidComponent struct {
+	name   string
+	number int64 // 未指定なら -1
+}
+
+// SNMPv2-SMI が読み込まれていない場合に備えた基本ノード
+var builtinNodes = map[string]string{
+	"ccitt":           "0",
+	"iso":             "1",
+	"joint-iso-ccitt": "2",
+	"org":             "1.3",
+	"dod":             "1.3.6",
+	"internet":        "1.3.6.1",
+	"directory":       "1.3.6.1.1",
+	"mgmt":            "1.3.6.1.2",
+	"mib-2":           "1.3.6.1.2.1",
+	"transmission":    "1.3.6.1.2.1.10",
+	"experimental":    "1.3.6.1.3",
+	"private":         "1.3.6.1.4",
+	"enterprises":     "1.3.6.1.4.1",
+	"security":        "1.3.6.1.5",
+	"snmpV2":          "1.3.6.1.6",
+	"snmpDomains":     "1.3.6.1.6.1",
+	"snmpProxys":      "1.3.6.1.6.2",
+	"snmpModules":     "1.3.6.1.6.3",
+	"zeroDotZero":     "0.0",
+}
+
+var macroNames = []string{
+	"OBJECT-TYPE",
+	"OBJECT-IDENTITY",
+	"MODULE-IDENTITY",
+	"NOTIFICATION-TYPE",
+	"OBJECT-GROUP",
+	"NOTIFICATION-GROUP",
+	"MODULE-COMPLIANCE",
+	"AGENT-CAPABILITIES",
+}
+
+// LoadModules は directory 直下の全ファイルを MIB モジュールとして読み込む
+func LoadModules(directory string) (*Modules, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Modules{modules: make(map[string]*module)}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(directory, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m.parse(string(b))
+	}
+	return m, nil
+}
+
+// UnresolvedError はシンボル名を数値OIDに変換できなかったことを表す
+type UnresolvedError struct {
+	Name string
+	// モジュール名を省略し、どのモジュールにも定義がない場合は空
+	Module string
+	Symbol string
+
+	reason string
+}
+
+func (e *UnresolvedError) Error() string {
+	return fmt.Sprintf("mib '%s' is not resolved: %s", e.Name, e.reason)
+}
+
+// Resolve は "MODULE::symbol.0" または "symbol.0" 形式の名前を数値OIDに変換する
+func (m *Modules) Resolve(name string) (string, error) {
+	moduleName, symbol, ok := strings.Cut(name, "::")
+	if !ok {
+		moduleName, symbol = "", name
+	}
+	symbol, suffix, _ := strings.Cut(symbol, ".")
+	if suffix != "" && !re.MatchString(suffix) && !onlyDigits(suffix) {
+		return "", fmt.Errorf("mib '%s' has invalid index : %s", name, suffix)
+	}
+
+	var oid string
+	if moduleName != "" {
+		mod, exists := m.modules[moduleName]
+		if !exists {
+			return "", &UnresolvedError{Name: name, Module: moduleName, Symbol: symbol, reason: fmt.Sprintf("module %s is not loaded", moduleName)}
+		}
+		if _, defined := mod.defs[symbol]; !defined {
+			return "", &UnresolvedError{Name: name, Module: moduleName, Symbol: symbol, reason: fmt.Sprintf("symbol %s is not defined in module %s", symbol, moduleName)}
+		}
+		resolved, err := m.resolveSymbol(mod, symbol, 0)
+		if err != nil {
+			return "", &UnresolvedError{Name: name, Module: moduleName, Symbol: symbol, reason: err.Error()}
+		}
+		oid = resolved
+	} else {
+		var found bool
+		for _, modName := range m.order {
+			mod := m.modules[modName]
+			if _, defined := mod.defs[symbol]; !defined {
+				continue
+			}
+			resolved, err := m.resolveSymbol(mod, symbol, 0)
+			if err != nil {
+				return "", &UnresolvedError{Name: name, Module: modName, Symbol: symbol, reason: err.Error()}
+			}
+			oid, found = resolved, true
+			break
+		}
+		if !found {
+			return "", &UnresolvedError{Name: name, Symbol: symbol, reason: fmt.Sprintf("symbol %s is not defined in any module", symbol)}
+		}
+	}
+
+	if suffix != "" {
+		oid = oid + "." + suffix
+	}
+	return oid, nil
+}
+
+func onlyDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+const maxResolveDepth = 64
+
+func (m *Modules) resolveSymbol(mod *module, symbol string, depth int) (string, error) {
+	if depth > maxResolveDepth {
+		return "", fmt.Errorf("symbol %s in module %s is too deeply nested", symbol, mod.name)
+	}
+
+	components, defined := mod.defs[symbol]
+	if !defined {
+		return m.lookup(mod, symbol, depth+1)
+	}
+
+	var parts []string
+	for idx, c := range components {
+		if c.number >= 0 {
+			parts = append(parts, strconv.FormatInt(c.number, 10))
+			continue
+		}
+		// 数値を伴わない名前は先頭の親ノードにのみ現れる
+		if idx != 0 {
+			return "", fmt.Errorf("symbol %s in module %s has invalid component %s", symbol, mod.name, c.name)
+		}
+		parent, err := m.lookup(mod, c.name, depth+1)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, parent)
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// lookup は mod から参照できる symbol を、定義、IMPORTS、全モジュール、組み込みの順に探す
+func (m *Modules) lookup(mod *module, symbol string, depth int) (string, error) {
+	if _, defined := mod.defs[symbol]; defined {
+		return m.resolveSymbol(mod, symbol, depth)
+	}
+	if from, imported := mod.imports[symbol]; imported {
+		if src, exists := m.modules[from]; exists {
+			if _, defined := src.defs[symbol]; defined {
+				return m.resolveSymbol(src, symbol, depth)
+			}
+			if _, reexported := src.imports[symbol]; reexported {
+				return m.lookup(src, symbol, depth+1)
+			}
+		}
+	}
+	for _, modName := range m.order {
+		if other := m.modules[modName]; other != mod {
+			if _, defined := other.defs[symbol]; defined {
+				return m.resolveSymbol(other, symbol, depth)
+			}
+		}
+	}
+	if oid, exists := builtinNodes[symbol]; exists {
+		return oid, nil
+	}
+	return "", fmt.Errorf("symbol %s referenced from module %s is not defined", symbol, mod.name)
+}
+
+func (m *Modules) parse(src string) {
+	tokens := tokenize(src)
+
+	var (
+		current *module
+		pending string
+	)
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch {
+		case tok == "DEFINITIONS" && i > 0:
+			current = &module{
+				name:    tokens[i-1],
+				defs:    make(map[string][]oidComponent),
+				imports: make(map[string]string),
+			}
+			if _, exists := m.modules[current.name]; !exists {
+				m.order = append(m.order, current.name)
+			}
+			m.modules[current.name] = current
+
+		case current == nil:
+			continue
+
+		case tok == "END":
+			current = nil
+
+		case tok == "IMPORTS":
+			i = parseImports(tokens, i+1, current)
+
+		case tok == "MACRO":
+			// SNMPv2-SMI などのマクロ定義本体は読み飛ばす
+			i = skipUntil(tokens, i+1, "END")
+
+		case slices.Contains(macroNames, tok) && i > 0 && isIdentifier(tokens[i-1]):
+			pending = tokens[i-1]
+
+		case tok == "::=" && i+1 < len(tokens) && tokens[i+1] == "{":
+			name := pending
+			if i >= 3 && tokens[i-2] == "OBJECT" && tokens[i-1] == "IDENTIFIER" && isIdentifier(tokens[i-3]) {
+				name = tokens[i-3]
+			}
+			pending = ""
+
+			var components []oidComponent
+			components, i = parseOIDValue(tokens, i+2)
+			if name != "" && len(components) > 0 {
+				current.defs[name] = components
+			}
+		}
+	}
+}
+
+func parseImports(tokens []string, i int, mod *module) int {
+	var symbols []string
+	for ; i < len(tokens); i++ {
+		switch tok := tokens[i]; tok {
+		case ";":
+			return i
+		case ",":
+			continue
+		case "FROM":
+			if i+1 < len(tokens) {
+				for _, s := range symbols {
+					mod.imports[s] = tokens[i+1]
+				}
+				i++
+			}
+			symbols = nil
+		default:
+			symbols = append(symbols, tok)
+		}
+	}
+	return i
+}
+
+func parseOIDValue(tokens []string, i int) ([]oidComponent, int) {
+	var components []oidComponent
+	for ; i < len(tokens); i++ {
+		tok := tokens[i]
+		if tok == "}" {
+			return components, i
+		}
+		if n, err := strconv.ParseInt(tok, 10, 64); err == nil {
+			components = append(components, oidComponent{number: n})
+			continue
+		}
+		if !isIdentifier(tok) {
+			continue
+		}
+		c := oidComponent{name: tok, number: -1}
+		// name(number) 形式
+		if i+3 < len(tokens) && tokens[i+1] == "(" && tokens[i+3] == ")" {
+			if n, err := strconv.ParseInt(tokens[i+2], 10, 64); err == nil {
+				c.number = n
+			}
+			i += 3
+		}
+		components = append(components, c)
+	}
+	return components, i
+}
+
+func skipUntil(tokens []string, i int, end string) int {
+	for ; i < len(tokens); i++ {
+		if tokens[i] == end {
+			return i
+		}
+	}
+	return i
+}
+
+func isIdentifier(tok string) bool {
+	if tok == "" {
+		return false
+	}
+	c := tok[0]
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func tokenize(src string) []string {
+	var tokens []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+
+		case strings.HasPrefix(src[i:], "--"):
+			// コメントは行末、または次の "--" まで
+			i += 2
+			for i < len(src) && src[i] != '\n' {
+				if strings.HasPrefix(src[i:], "--") {
+					i += 2
+					break
+				}
+				i++
+			}
+
+		case c == '"':
+			end := strings.IndexByte(src[i+1:], '"')
+			if end < 0 {
+				return tokens
+			}
+			i += end + 2
+
+		case strings.HasPrefix(src[i:], "::="):
+			tokens = append(tokens, "::=")
+			i += 3
+
+		case isWordByte(c):
+			start := i
+			for i < len(src) && isWordByte(src[i]) {
+				// "--" はコメントの開始なので識別子に含めない
+				if strings.HasPrefix(src[i:], "--") {
+					break
+				}
+				i++
+			}
+			tokens = append(tokens, src[start:i])
+
+		default:
+			tokens = append(tokens, string(c))
+			i++
+		}
+	}
+	return tokens
+}
+
+func isWordByte(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || c == '-' || c == '_'
+}
@@ -0,0 +1,102 @@
+package selfmetric
+
+import "github.com/mackerelio/mackerel-client-go"
+
+var GraphDefs = []*mackerel.GraphDefsParam{
+	{
+		Name:        "custom.sabatrafficd.poll.duration",
+		Unit:        "seconds",
+		DisplayName: "sabatrafficd Poll Duration",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.sabatrafficd.poll.duration.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.sabatrafficd.poll.queue_latency",
+		Unit:        "seconds",
+		DisplayName: "sabatrafficd Poll Queue Latency",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.sabatrafficd.poll.queue_latency.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.sabatrafficd.poll.errors",
+		Unit:        "integer",
+		DisplayName: "sabatrafficd Poll Errors",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.sabatrafficd.poll.errors.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.sabatrafficd.poll.overruns",
+		Unit:        "integer",
+		DisplayName: "sabatrafficd Poll Overruns",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.sabatrafficd.poll.overruns.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.sabatrafficd.poll.interfaces",
+		Unit:        "integer",
+		DisplayName: "sabatrafficd Interfaces Collected",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.sabatrafficd.poll.interfaces.*",
+				DisplayName: "%1",
+			},
+		},
+	},
+	{
+		Name:        "custom.sabatrafficd.queue",
+		Unit:        "integer",
+		DisplayName: "sabatrafficd Queue Length",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.sabatrafficd.queue.memory",
+				DisplayName: "memory",
+			},
+			{
+				Name:        "custom.sabatrafficd.queue.disk",
+				DisplayName: "disk",
+			},
+		},
+	},
+	{
+		Name:        "custom.sabatrafficd.diskcache",
+		Unit:        "bytes",
+		DisplayName: "sabatrafficd Disk Cache",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.sabatrafficd.diskcache.bytes",
+				DisplayName: "bytes",
+			},
+		},
+	},
+	{
+		Name:        "custom.sabatrafficd.post",
+		Unit:        "integer",
+		DisplayName: "sabatrafficd Post Failures",
+		Metrics: []*mackerel.GraphDefsMetric{
+			{
+				Name:        "custom.sabatrafficd.post.failures",
+				DisplayName: "failures",
+			},
+			{
+				Name:        "custom.sabatrafficd.post.discarded",
+				DisplayName: "discarded metrics",
+			},
+		},
+	},
+}
@@ -0,0 +1,87 @@
+package selfmetric
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+// Registry は collector ごとの取得状況を保持する
+type Registry struct {
+	mu    sync.RWMutex
+	stats map[string]PollStat // collectorID:stat
+}
+
+type PollStat struct {
+	HostID string
+	Host   string
+	Port   uint16
+
+	LastTick     time.Time
+	LastDuration time.Duration
+	LastError    string
+	// 起動からの累計
+	Errors uint64
+	// 次の開始時刻までに取得が終わらなかった回数
+	Overruns uint64
+	// 同時実行数の制限により、取得の開始を待った時間
+	QueueLatency time.Duration
+	Interfaces   int
+	Metrics      int
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		stats: make(map[string]PollStat),
+	}
+}
+
+func (r *Registry) ObservePoll(collectorID, hostID, host string, port uint16, started time.Time, d time.Duration, interfaces, metrics int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stat := r.stats[collectorID]
+	stat.HostID = hostID
+	stat.Host = host
+	stat.Port = port
+	stat.LastTick = started
+	stat.LastDuration = d
+	stat.Interfaces = interfaces
+	stat.Metrics = metrics
+	stat.LastError = ""
+	if err != nil {
+		stat.LastError = err.Error()
+		stat.Errors++
+	}
+	r.stats[collectorID] = stat
+}
+
+func (r *Registry) ObserveOverrun(collectorID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stat := r.stats[collectorID]
+	stat.Overruns++
+	r.stats[collectorID] = stat
+}
+
+func (r *Registry) ObserveQueueLatency(collectorID string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stat := r.stats[collectorID]
+	stat.QueueLatency = d
+	r.stats[collectorID] = stat
+}
+
+func (r *Registry) Remove(collectorID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.stats, collectorID)
+}
+
+func (r *Registry) PollStats() map[string]PollStat {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return maps.Clone(r.stats)
+}
@@ -0,0 +1,148 @@
+package selfmetric
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/mackerelio/mackerel-client-go"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/config"
+)
+
+type enqueuer interface {
+	Enqueue(hostID string, rawMetrics []*mackerel.MetricValue)
+}
+
+type memoryQueue interface {
+	Len() int
+	Dropped() uint64
+}
+
+type diskCache interface {
+	Len() int
+	Bytes() int64
+}
+
+type failureCounter interface {
+	Failures() uint64
+}
+
+type discardCounter interface {
+	Discarded() uint64
+}
+
+// Ticker は sabatrafficd 自身の状態を Mackerel に投稿する
+type Ticker struct {
+	hostID   string
+	registry *Registry
+	queue    enqueuer
+
+	sendQueue memoryQueue
+	diskCache diskCache
+	discarded discardCounter
+	senders   []failureCounter
+
+	// 前回投稿時点の累計値
+	prevPollErrors   map[string]uint64
+	prevPollOverruns map[string]uint64
+	prevPostFailures uint64
+	prevDiscarded    uint64
+	prevDropped      uint64
+}
+
+func NewTicker(hostID string, registry *Registry, q enqueuer, sendQueue memoryQueue, dc diskCache, discarded discardCounter, senders ...failureCounter) *Ticker {
+	return &Ticker{
+		hostID:    hostID,
+		registry:  registry,
+		queue:     q,
+		sendQueue: sendQueue,
+		diskCache: dc,
+		discarded: discarded,
+		senders:   senders,
+
+		prevPollErrors:   make(map[string]uint64),
+		prevPollOverruns: make(map[string]uint64),
+	}
+}
+
+func (t *Ticker) Tick(_ context.Context) {
+	if m := t.metrics(time.Now()); len(m) > 0 {
+		t.queue.Enqueue(t.hostID, m)
+	}
+}
+
+func (t *Ticker) metrics(now time.Time) []*mackerel.MetricValue {
+	var metrics []*mackerel.MetricValue
+	add := func(name string, value any) {
+		metrics = append(metrics, &mackerel.MetricValue{Name: name, Time: now.Unix(), Value: value})
+	}
+
+	pollErrors := make(map[string]uint64)
+	pollOverruns := make(map[string]uint64)
+	for collectorID, stat := range t.registry.PollStats() {
+		// 起動直後などまだ取得していない collector は投稿しない
+		if stat.LastTick.IsZero() {
+			continue
+		}
+		name := collectorMetricName(stat.Host, stat.Port, stat.HostID)
+		add("custom.sabatrafficd.poll.duration."+name, stat.LastDuration.Seconds())
+		add("custom.sabatrafficd.poll.queue_latency."+name, stat.QueueLatency.Seconds())
+		add("custom.sabatrafficd.poll.errors."+name, stat.Errors-t.prevPollErrors[collectorID])
+		add("custom.sabatrafficd.poll.overruns."+name, stat.Overruns-t.prevPollOverruns[collectorID])
+		add("custom.sabatrafficd.poll.interfaces."+name, stat.Interfaces)
+		pollErrors[collectorID] = stat.Errors
+		pollOverruns[collectorID] = stat.Overruns
+	}
+	t.prevPollErrors = pollErrors
+	t.prevPollOverruns = pollOverruns
+
+	add("custom.sabatrafficd.queue.memory", t.sendQueue.Len())
+	if t.diskCache != nil {
+		add("custom.sabatrafficd.queue.disk", t.diskCache.Len())
+		add("custom.sabatrafficd.diskcache.bytes", t.diskCache.Bytes())
+	}
+
+	var postFailures uint64
+	for _, s := range t.senders {
+		postFailures += s.Failures()
+	}
+	add("custom.sabatrafficd.post.failures", postFailures-t.prevPostFailures)
+	t.prevPostFailures = postFailures
+
+	discarded := t.discarded.Discarded()
+	add("custom.sabatrafficd.post.discarded", discarded-t.prevDiscarded)
+	t.prevDiscarded = discarded
+
+	dropped := t.sendQueue.Dropped()
+	add("custom.sabatrafficd.post.dropped", dropped-t.prevDropped)
+	t.prevDropped = dropped
+
+	return metrics
+}
+
+var invalidMetricNameRe = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// collectorMetricName は collector ごとのメトリック名を返す
+// 同じ機器を別の host-id で取得する collector が衝突しないよう、host-id も含める
+func collectorMetricName(host string, port uint16, hostID string) string {
+	name := invalidMetricNameRe.ReplaceAllString(host, "_")
+	if port != 161 {
+		name = fmt.Sprintf("%s_%d", name, port)
+	}
+	return name + "_" + invalidMetricNameRe.ReplaceAllString(hostID, "_")
+}
+
+func (*Ticker) Name() string {
+	return "selfmetric"
+}
+
+func (*Ticker) Reload(conf *config.CollectorConfig) {
+	// no support
+}
+
+func (*Ticker) CollectorID() string {
+	// no support
+	return ""
+}
@@ -0,0 +1,129 @@
+package selfmetric
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+type mockQueue struct {
+	length  int
+	dropped uint64
+}
+
+func (m *mockQueue) Len() int {
+	return m.length
+}
+
+func (m *mockQueue) Dropped() uint64 {
+	return m.dropped
+}
+
+type mockDiskCache struct {
+	length int
+	bytes  int64
+}
+
+func (m *mockDiskCache) Len() int {
+	return m.length
+}
+
+func (m *mockDiskCache) Bytes() int64 {
+	return m.bytes
+}
+
+type mockDiscardCounter struct {
+	discarded uint64
+}
+
+func (m *mockDiscardCounter) Discarded() uint64 {
+	return m.discarded
+}
+
+type mockSender struct {
+	failures uint64
+}
+
+func (m *mockSender) Failures() uint64 {
+	return m.failures
+}
+
+func TestMetrics(t *testing.T) {
+	registry := NewRegistry()
+	s1, s2 := &mockSender{}, &mockSender{}
+	discarded := &mockDiscardCounter{discarded: 5}
+	q := &mockQueue{length: 3, dropped: 7}
+	tk := NewTicker("hostid", registry, nil, q, &mockDiskCache{length: 1000, bytes: 2048}, discarded, s1, s2)
+
+	started := time.Now()
+	registry.ObservePoll("a", "host1", "192.0.2.1", 161, started, 1500*time.Millisecond, 24, 100, nil)
+	registry.ObservePoll("b", "host2", "192.0.2.2", 10161, started, 10*time.Second, 0, 0, errors.New("timeout"))
+	registry.ObservePoll("b", "host2", "192.0.2.2", 10161, started, 10*time.Second, 0, 0, errors.New("timeout"))
+	registry.ObserveOverrun("b")
+	registry.ObserveQueueLatency("a", 250*time.Millisecond)
+	s1.failures = 2
+	s2.failures = 1
+
+	opt := cmpopts.SortSlices(func(i, j *mackerel.MetricValue) bool { return i.Name < j.Name })
+
+	now := time.Now()
+	expected := []*mackerel.MetricValue{
+		{Name: "custom.sabatrafficd.poll.duration.192_0_2_1_host1", Time: now.Unix(), Value: 1.5},
+		{Name: "custom.sabatrafficd.poll.queue_latency.192_0_2_1_host1", Time: now.Unix(), Value: 0.25},
+		{Name: "custom.sabatrafficd.poll.errors.192_0_2_1_host1", Time: now.Unix(), Value: uint64(0)},
+		{Name: "custom.sabatrafficd.poll.overruns.192_0_2_1_host1", Time: now.Unix(), Value: uint64(0)},
+		{Name: "custom.sabatrafficd.poll.interfaces.192_0_2_1_host1", Time: now.Unix(), Value: 24},
+		{Name: "custom.sabatrafficd.poll.duration.192_0_2_2_10161_host2", Time: now.Unix(), Value: float64(10)},
+		{Name: "custom.sabatrafficd.poll.queue_latency.192_0_2_2_10161_host2", Time: now.Unix(), Value: float64(0)},
+		{Name: "custom.sabatrafficd.poll.errors.192_0_2_2_10161_host2", Time: now.Unix(), Value: uint64(2)},
+		{Name: "custom.sabatrafficd.poll.overruns.192_0_2_2_10161_host2", Time: now.Unix(), Value: uint64(1)},
+		{Name: "custom.sabatrafficd.poll.interfaces.192_0_2_2_10161_host2", Time: now.Unix(), Value: 0},
+		{Name: "custom.sabatrafficd.queue.memory", Time: now.Unix(), Value: 3},
+		{Name: "custom.sabatrafficd.queue.disk", Time: now.Unix(), Value: 1000},
+		{Name: "custom.sabatrafficd.diskcache.bytes", Time: now.Unix(), Value: int64(2048)},
+		{Name: "custom.sabatrafficd.post.failures", Time: now.Unix(), Value: uint64(3)},
+		{Name: "custom.sabatrafficd.post.discarded", Time: now.Unix(), Value: uint64(5)},
+		{Name: "custom.sabatrafficd.post.dropped", Time: now.Unix(), Value: uint64(7)},
+	}
+	if diff := cmp.Diff(tk.metrics(now), expected, opt); diff != "" {
+		t.Errorf("value is mismatch (-actual +expected):%s", diff)
+	}
+
+	// 2回目は前回からの増分のみ
+	registry.ObservePoll("b", "host2", "192.0.2.2", 10161, started, 10*time.Second, 0, 0, errors.New("timeout"))
+	s2.failures = 2
+	discarded.discarded = 6
+	q.dropped = 8
+	actual := tk.metrics(now)
+	for _, m := range actual {
+		switch m.Name {
+		case "custom.sabatrafficd.poll.errors.192_0_2_2_10161_host2", "custom.sabatrafficd.post.failures", "custom.sabatrafficd.post.discarded", "custom.sabatrafficd.post.dropped":
+			if m.Value != uint64(1) {
+				t.Errorf("invalid delta %s: %v", m.Name, m.Value)
+			}
+		}
+	}
+}
+
+func TestCollectorMetricName(t *testing.T) {
+	tests := []struct {
+		host     string
+		port     uint16
+		hostID   string
+		expected string
+	}{
+		{host: "192.0.2.1", port: 161, hostID: "host1", expected: "192_0_2_1_host1"},
+		// 同じ機器でも host-id が異なれば別の名前になる
+		{host: "192.0.2.1", port: 161, hostID: "host2", expected: "192_0_2_1_host2"},
+		{host: "2001:db8::1", port: 10161, hostID: "host1", expected: "2001_db8__1_10161_host1"},
+	}
+	for _, tc := range tests {
+		if actual := collectorMetricName(tc.host, tc.port, tc.hostID); actual != tc.expected {
+			t.Errorf("collectorMetricName(%s, %d, %s) = %s, expected %s", tc.host, tc.port, tc.hostID, actual, tc.expected)
+		}
+	}
+}
@@ -2,7 +2,10 @@ package sender
 
 import (
 	"context"
+	"errors"
 	"log/slog"
+	"net/http"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -10,6 +13,7 @@ import (
 	"github.com/mackerelio/mackerel-client-go"
 
 	"github.com/mackerelio-labs/sabatrafficd/internal/config"
+	"github.com/mackerelio-labs/sabatrafficd/internal/sendqueue"
 )
 
 type sendFunc interface {
@@ -17,9 +21,12 @@ type sendFunc interface {
 }
 
 type queue interface {
-	Dequeue() (hostid string, metrics []*mackerel.MetricValue, ok bool)
+	// 同じホストの値を、メトリックの合計が maxMetrics を超えない範囲でまとめて取り出す
+	DequeueBatch(maxMetrics int) []sendqueue.Item
 	Len() int
-	ReEnqueue(string, []*mackerel.MetricValue)
+	ReEnqueue(sendqueue.Item)
+	// 投稿が完了したことを通知する
+	Ack(items ...sendqueue.Item)
 }
 
 type Sender struct {
@@ -29,13 +36,16 @@ type Sender struct {
 
 	queue    queue
 	sendFunc sendFunc
-}
 
-type item struct {
-	hostID  string
-	metrics []*mackerel.MetricValue
+	failures atomic.Uint64
+	expiry   *sendqueue.Expiry
+	// 1回の投稿にまとめるメトリック数の上限
+	batchSize int
 }
 
+// まとめない場合と同じく、Enqueue で分割される件数とする
+const defaultBatchSize = 50
+
 type noopSendFunc struct{}
 
 func (noopSendFunc) Send(_ context.Context, _ string, _ []*mackerel.MetricValue) error {
@@ -50,20 +60,20 @@ func New(sendFunc sendFunc, queue queue) *Sender {
 		shutdown: make(chan struct{}),
 		queue:    queue,
 		sendFunc: sendFunc,
+
+		batchSize: defaultBatchSize,
 	}
 }
 
 func (q *Sender) Serve() error {
 	var wg sync.WaitGroup
-	ch := make(chan *item, 100)
+	ch := make(chan []sendqueue.Item, 100)
 
 	for range 10 {
 		wg.Go(func() {
 			backoff := 100 * time.Millisecond
-			for v := range ch {
-				if err := q.sendFunc.Send(context.Background(), v.hostID, v.metrics); err != nil {
-					slog.Warn("failed post", slog.String("error", err.Error()))
-					q.queue.ReEnqueue(v.hostID, v.metrics)
+			for batch := range ch {
+				if err := q.post(batch); err != nil {
 					time.Sleep(backoff)
 					backoff = min(backoff*2, 30*time.Second)
 				} else {
@@ -86,17 +96,99 @@ func (q *Sender) Serve() error {
 			slog.Debug("Serve stopped")
 			return nil
 		default:
-			hostID, metrics, ok := q.queue.Dequeue()
-			if !ok {
+			batch := q.queue.DequeueBatch(q.batchSize)
+			if len(batch) == 0 {
 				time.Sleep(100 * time.Millisecond)
 				continue
 			}
 
-			ch <- &item{hostID: hostID, metrics: metrics}
+			ch <- batch
 		}
 	}
 }
 
+// post は同じホストの値をまとめて投稿する
+// まとめた投稿が拒否された場合は、一部の値が原因の可能性があるため1件ずつ投稿し直す
+func (q *Sender) post(batch []sendqueue.Item) error {
+	hostID := batch[0].HostID
+	var metrics []*mackerel.MetricValue
+	for _, item := range batch {
+		metrics = append(metrics, item.Metrics...)
+	}
+
+	err := q.sendFunc.Send(context.Background(), hostID, metrics)
+	if len(batch) > 1 && rejected(err) {
+		var errs error
+		for _, item := range batch {
+			errs = errors.Join(errs, q.post([]sendqueue.Item{item}))
+		}
+		return errs
+	}
+
+	switch {
+	case tooOld(err, metrics, time.Now()):
+		// 再投稿しても受け付けられないため破棄する
+		slog.Warn("discard metrics because they are too old", slog.String("hostID", hostID), slog.Int("metrics", len(metrics)), slog.String("error", err.Error()))
+		q.expiry.Discard(len(metrics))
+		q.queue.Ack(batch...)
+		return nil
+	case err != nil:
+		slog.Warn("failed post", slog.String("error", err.Error()))
+		q.failures.Add(1)
+		// 先頭に戻すため、後ろの値から戻す
+		for _, item := range slices.Backward(batch) {
+			q.queue.ReEnqueue(item)
+		}
+		return err
+	default:
+		q.queue.Ack(batch...)
+		return nil
+	}
+}
+
+// SetBatchSize は1回の投稿にまとめるメトリック数の上限を設定する。Serve の前に呼び出すこと
+func (q *Sender) SetBatchSize(n int) {
+	q.batchSize = n
+}
+
+// SetExpiry は古すぎて破棄したメトリックの件数を記録するよう設定する。Serve の前に呼び出すこと
+func (q *Sender) SetExpiry(e *sendqueue.Expiry) {
+	q.expiry = e
+}
+
+// Mackerel が受け付ける過去のメトリックの範囲
+const postableAge = 24 * time.Hour
+
+// tooOld は拒否された投稿が、Mackerel が受け付ける範囲より古いメトリックのみからなるかを返す
+// エラーの内容は API の仕様として定められていないため、メトリックの時刻で判断する
+func tooOld(err error, metrics []*mackerel.MetricValue, now time.Time) bool {
+	var apiErr *mackerel.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || len(metrics) == 0 {
+		return false
+	}
+	deadline := now.Add(-postableAge).Unix()
+	for _, m := range metrics {
+		if m.Time >= deadline {
+			return false
+		}
+	}
+	return true
+}
+
+// rejected は Mackerel が投稿の内容を理由に拒否したかを返す
+func rejected(err error) bool {
+	var apiErr *mackerel.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.StatusCode == http.StatusBadRequest || apiErr.StatusCode == http.StatusRequestEntityTooLarge
+}
+
+// 起動からの投稿失敗回数
+func (q *Sender) Failures() uint64 {
+	return q.failures.Load()
+}
+
 func (*Sender) Reload(conf *config.CollectorConfig) {
 	// no support
 }
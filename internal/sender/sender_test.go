@@ -2,27 +2,38 @@ package sender
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/mackerelio/mackerel-client-go"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/sendqueue"
 )
 
 type mockQueue struct {
 	sync.Mutex
-	count int
+	count   int
+	acked   int
+	metrics []*mackerel.MetricValue
 }
 
-func (m *mockQueue) Dequeue() (hostid string, metrics []*mackerel.MetricValue, ok bool) {
+func (m *mockQueue) DequeueBatch(int) []sendqueue.Item {
 	m.Lock()
 	defer m.Unlock()
 	if m.count > 0 {
 		m.count--
-		return "hostid", nil, true
+		return []sendqueue.Item{{HostID: "hostid", Metrics: m.metrics}}
 	}
-	return "", nil, false
+	return nil
 }
 
 func (m *mockQueue) Len() int {
@@ -31,12 +42,18 @@ func (m *mockQueue) Len() int {
 	return m.count
 }
 
-func (m *mockQueue) ReEnqueue(string, []*mackerel.MetricValue) {
+func (m *mockQueue) ReEnqueue(sendqueue.Item) {
 	m.Lock()
 	defer m.Unlock()
 	m.count++
 }
 
+func (m *mockQueue) Ack(items ...sendqueue.Item) {
+	m.Lock()
+	defer m.Unlock()
+	m.acked += len(items)
+}
+
 type mockSender struct {
 	sync.Mutex
 	count int
@@ -78,4 +95,246 @@ func TestServe(t *testing.T) {
 	if s.count != 30 {
 		t.Error("invalid")
 	}
+	if m.acked != 30 {
+		t.Errorf("invalid acked: %d", m.acked)
+	}
+}
+
+type tooOldSender struct{}
+
+func (tooOldSender) Send(_ context.Context, _ string, _ []*mackerel.MetricValue) error {
+	return &mackerel.APIError{StatusCode: http.StatusBadRequest, Message: "bad request"}
+}
+
+func TestServeTooOld(t *testing.T) {
+	m := &mockQueue{count: 3, metrics: []*mackerel.MetricValue{{Name: "name", Time: 1, Value: float64(1)}}}
+	h := New(tooOldSender{}, m)
+	e := sendqueue.NewExpiry(0)
+	h.SetExpiry(e)
+
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		if err := h.Serve(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	if err := h.Shutdown(t.Context()); err != nil {
+		t.Error(err)
+	}
+
+	wg.Wait()
+
+	// 再投稿せずに破棄する
+	if m.count != 0 || m.acked != 3 {
+		t.Errorf("invalid count: %d, acked: %d", m.count, m.acked)
+	}
+	if h.Failures() != 0 {
+		t.Errorf("invalid failures: %d", h.Failures())
+	}
+}
+
+func TestTooOld(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	badRequest := &mackerel.APIError{StatusCode: http.StatusBadRequest, Message: "bad request"}
+	metrics := func(times ...time.Time) []*mackerel.MetricValue {
+		var metrics []*mackerel.MetricValue
+		for _, t := range times {
+			metrics = append(metrics, &mackerel.MetricValue{Name: "name", Time: t.Unix(), Value: float64(1)})
+		}
+		return metrics
+	}
+	old := now.Add(-postableAge - time.Minute)
+
+	tests := []struct {
+		name     string
+		err      error
+		metrics  []*mackerel.MetricValue
+		expected bool
+	}{
+		{name: "success", err: nil, metrics: metrics(old), expected: false},
+		{name: "not api error", err: errors.New("bad request"), metrics: metrics(old), expected: false},
+		{name: "old", err: badRequest, metrics: metrics(old, old), expected: true},
+		{name: "wrapped", err: fmt.Errorf("wrap: %w", badRequest), metrics: metrics(old), expected: true},
+		{name: "partly old", err: badRequest, metrics: metrics(old, now), expected: false},
+		{name: "recent", err: badRequest, metrics: metrics(now), expected: false},
+		{name: "no metrics", err: badRequest, metrics: nil, expected: false},
+		{name: "server error", err: &mackerel.APIError{StatusCode: http.StatusInternalServerError}, metrics: metrics(old), expected: false},
+	}
+	for _, tc := range tests {
+		if actual := tooOld(tc.err, tc.metrics, now); actual != tc.expected {
+			t.Errorf("%s: tooOld() = %v, expected %v", tc.name, actual, tc.expected)
+		}
+	}
+}
+
+type recordQueue struct {
+	mockQueue
+	reEnqueued []string
+	ackedNames []string
+}
+
+func (m *recordQueue) ReEnqueue(item sendqueue.Item) {
+	m.reEnqueued = append(m.reEnqueued, item.Metrics[0].Name)
+}
+
+func (m *recordQueue) Ack(items ...sendqueue.Item) {
+	for _, item := range items {
+		m.ackedNames = append(m.ackedNames, item.Metrics[0].Name)
+	}
+}
+
+// rejectSender は name が bad のメトリックを含む投稿を拒否する
+type rejectSender struct {
+	posts []int
+	err   error
+}
+
+func (m *rejectSender) Send(_ context.Context, _ string, metrics []*mackerel.MetricValue) error {
+	m.posts = append(m.posts, len(metrics))
+	if m.err != nil {
+		return m.err
+	}
+	for _, metric := range metrics {
+		if metric.Name == "bad" {
+			return &mackerel.APIError{StatusCode: http.StatusBadRequest, Message: "invalid metric"}
+		}
+	}
+	return nil
+}
+
+func TestPost(t *testing.T) {
+	batch := func(names ...string) []sendqueue.Item {
+		var items []sendqueue.Item
+		for _, name := range names {
+			items = append(items, sendqueue.Item{HostID: "hostid", Metrics: []*mackerel.MetricValue{{Name: name, Time: time.Now().Unix()}}})
+		}
+		return items
+	}
+
+	t.Run("merged", func(t *testing.T) {
+		q, s := &recordQueue{}, &rejectSender{}
+		h := New(s, q)
+		if err := h.post(batch("a", "b", "c")); err != nil {
+			t.Error(err)
+		}
+		if diff := cmp.Diff(s.posts, []int{3}); diff != "" {
+			t.Errorf("value is mismatch (-actual +expected):%s", diff)
+		}
+		if diff := cmp.Diff(q.ackedNames, []string{"a", "b", "c"}); diff != "" {
+			t.Errorf("value is mismatch (-actual +expected):%s", diff)
+		}
+	})
+
+	t.Run("rejected batch is posted one by one", func(t *testing.T) {
+		q, s := &recordQueue{}, &rejectSender{}
+		h := New(s, q)
+		if err := h.post(batch("a", "bad", "c")); err == nil {
+			t.Error("error is expected")
+		}
+		if diff := cmp.Diff(s.posts, []int{3, 1, 1, 1}); diff != "" {
+			t.Errorf("value is mismatch (-actual +expected):%s", diff)
+		}
+		if diff := cmp.Diff(q.ackedNames, []string{"a", "c"}); diff != "" {
+			t.Errorf("value is mismatch (-actual +expected):%s", diff)
+		}
+		if diff := cmp.Diff(q.reEnqueued, []string{"bad"}); diff != "" {
+			t.Errorf("value is mismatch (-actual +expected):%s", diff)
+		}
+	})
+
+	t.Run("failure keeps order", func(t *testing.T) {
+		q, s := &recordQueue{}, &rejectSender{err: errors.New("timeout")}
+		h := New(s, q)
+		if err := h.post(batch("a", "b", "c")); err == nil {
+			t.Error("error is expected")
+		}
+		// 先頭に戻すため後ろから戻す
+		if diff := cmp.Diff(q.reEnqueued, []string{"c", "b", "a"}); diff != "" {
+			t.Errorf("value is mismatch (-actual +expected):%s", diff)
+		}
+		if h.Failures() != 1 {
+			t.Errorf("invalid failures: %d", h.Failures())
+		}
+	})
+}
+
+type clientSendFunc struct {
+	client *mackerel.Client
+}
+
+func (c clientSendFunc) Send(ctx context.Context, hostID string, metrics []*mackerel.MetricValue) error {
+	return c.client.PostHostMetricValuesByHostIDContext(ctx, hostID, metrics)
+}
+
+// ackCounter は投稿を終えた値の件数を数える
+type ackCounter struct {
+	*sendqueue.Queue
+	acked atomic.Int64
+}
+
+func (q *ackCounter) Ack(items ...sendqueue.Item) {
+	q.acked.Add(int64(len(items)))
+	q.Queue.Ack(items...)
+}
+
+// BenchmarkDrain は通信断から復帰した後に、24時間分のバックログをローカルの擬似 API に投稿し終えるまでの時間を計測する
+func BenchmarkDrain(b *testing.B) {
+	const (
+		hosts          = 10
+		ticks          = 24 * 60
+		metricsPerTick = 30
+	)
+
+	var posts atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts.Add(1)
+		io.Copy(io.Discard, r.Body)         // nolint
+		w.Write([]byte(`{"success":true}`)) // nolint
+	}))
+	defer srv.Close()
+	client, err := mackerel.NewClientWithOptions("apikey", srv.URL, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, size := range []int{defaultBatchSize, 500} {
+		b.Run(fmt.Sprintf("batch=%d", size), func(b *testing.B) {
+			posts.Store(0)
+			for range b.N {
+				b.StopTimer()
+				q := &ackCounter{Queue: sendqueue.New()}
+				started := time.Now().Add(-ticks * time.Minute)
+				for tick := range ticks {
+					for host := range hosts {
+						metrics := make([]*mackerel.MetricValue, metricsPerTick)
+						for idx := range metrics {
+							metrics[idx] = &mackerel.MetricValue{
+								Name:  fmt.Sprintf("interface.eth%d.rxBytes.delta", idx),
+								Time:  started.Add(time.Duration(tick) * time.Minute).Unix(),
+								Value: float64(idx),
+							}
+						}
+						q.Enqueue(fmt.Sprintf("host%d", host), metrics)
+					}
+				}
+				total := int64(q.Len())
+				h := New(clientSendFunc{client: client}, q)
+				h.SetBatchSize(size)
+				b.StartTimer()
+
+				go h.Serve() // nolint
+				for q.acked.Load() < total {
+					time.Sleep(time.Millisecond)
+				}
+
+				b.StopTimer()
+				if err := h.Shutdown(b.Context()); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(posts.Load())/float64(b.N), "posts/op")
+		})
+	}
 }
@@ -0,0 +1,77 @@
+package sendqueue
+
+import (
+	"container/list"
+	"time"
+)
+
+// 同じホストの値を探す範囲。バックログが大きい場合にロックを長く保持しないよう制限する
+const mergeWindow = 1000
+
+// PopBatch は l の先頭の値と、それに続く同じホストの値を、メトリックの合計が maxMetrics を超えない範囲で取り出す
+// 先頭の値は maxMetrics を超えていても取り出す
+func PopBatch(l *list.List, maxMetrics int) []Item {
+	e := l.Front()
+	if e == nil {
+		return nil
+	}
+	first := e.Value.(Item)
+	batch := []Item{first}
+	metrics := len(first.Metrics)
+
+	next := e.Next()
+	l.Remove(e)
+	for range mergeWindow {
+		e = next
+		if e == nil || metrics >= maxMetrics {
+			break
+		}
+		next = e.Next()
+
+		item := e.Value.(Item)
+		if item.HostID != first.HostID {
+			continue
+		}
+		// 同じホストの値は追加した順に投稿する
+		if metrics+len(item.Metrics) > maxMetrics {
+			break
+		}
+		batch = append(batch, item)
+		metrics += len(item.Metrics)
+		l.Remove(e)
+	}
+	return batch
+}
+
+// DequeueBatch は同じホストの値をまとめて取り出す。古いメトリックは除く
+func (q *Queue) DequeueBatch(maxMetrics int) []Item {
+	now := time.Now()
+	for {
+		popped := q.popBatch(maxMetrics)
+		if len(popped) == 0 {
+			return nil
+		}
+		batch := make([]Item, 0, len(popped))
+		for _, item := range popped {
+			if filtered, ok := q.expiry.Filter(item, now); ok {
+				batch = append(batch, filtered)
+			} else {
+				q.Ack(item)
+			}
+		}
+		if len(batch) > 0 {
+			return batch
+		}
+	}
+}
+
+func (q *Queue) popBatch(maxMetrics int) []Item {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	batch := PopBatch(q.buffers, maxMetrics)
+	for _, item := range batch {
+		q.bytes -= item.size()
+	}
+	return batch
+}
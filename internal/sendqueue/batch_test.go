@@ -0,0 +1,87 @@
+package sendqueue
+
+import (
+	"container/list"
+	"testing"
+	"time"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+func TestPopBatch(t *testing.T) {
+	item := func(hostID string, n int) Item {
+		metrics := make([]*mackerel.MetricValue, n)
+		for idx := range metrics {
+			metrics[idx] = &mackerel.MetricValue{Name: "name", Time: time.Now().Unix(), Value: float64(idx)}
+		}
+		return Item{HostID: hostID, Metrics: metrics}
+	}
+	newList := func(items ...Item) *list.List {
+		l := list.New()
+		for _, item := range items {
+			l.PushBack(item)
+		}
+		return l
+	}
+	summary := func(items []Item) (hostIDs []string, metrics int) {
+		for _, item := range items {
+			hostIDs = append(hostIDs, item.HostID)
+			metrics += len(item.Metrics)
+		}
+		return
+	}
+
+	t.Run("merge same host", func(t *testing.T) {
+		l := newList(item("a", 50), item("b", 50), item("a", 50), item("a", 30))
+		hostIDs, metrics := summary(PopBatch(l, 120))
+		// 上限を超える値の手前までまとめる
+		if len(hostIDs) != 2 || hostIDs[0] != "a" || hostIDs[1] != "a" || metrics != 100 {
+			t.Errorf("invalid batch: %v, %d", hostIDs, metrics)
+		}
+		// 超えた値より後ろの同じホストの値は、順序を保つため取り出さない
+		if l.Len() != 2 || l.Front().Value.(Item).HostID != "b" {
+			t.Errorf("invalid remain: %d", l.Len())
+		}
+	})
+
+	t.Run("first item is always popped", func(t *testing.T) {
+		l := newList(item("a", 50), item("a", 50))
+		if hostIDs, _ := summary(PopBatch(l, 0)); len(hostIDs) != 1 {
+			t.Errorf("invalid batch: %v", hostIDs)
+		}
+		if l.Len() != 1 {
+			t.Errorf("invalid remain: %d", l.Len())
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		if batch := PopBatch(list.New(), 100); batch != nil {
+			t.Errorf("invalid batch: %v", batch)
+		}
+	})
+
+	t.Run("dequeue batch", func(t *testing.T) {
+		q := New()
+		q.SetLimit(nil)
+		for range 3 {
+			q.Enqueue("a", item("a", 80).Metrics)
+			q.Enqueue("b", item("b", 10).Metrics)
+		}
+		// 80 メトリックは 50 と 30 に分割されている
+		hostIDs, metrics := summary(q.DequeueBatch(200))
+		if len(hostIDs) != 4 || metrics != 160 {
+			t.Errorf("invalid batch: %v, %d", hostIDs, metrics)
+		}
+		hostIDs, metrics = summary(q.DequeueBatch(200))
+		if len(hostIDs) != 3 || hostIDs[0] != "b" || metrics != 30 {
+			t.Errorf("invalid batch: %v, %d", hostIDs, metrics)
+		}
+		hostIDs, metrics = summary(q.DequeueBatch(200))
+		if len(hostIDs) != 2 || hostIDs[0] != "a" || metrics != 80 {
+			t.Errorf("invalid batch: %v, %d", hostIDs, metrics)
+		}
+		if q.Len() != 0 || q.bytes != 0 {
+			t.Errorf("invalid len: %d, bytes: %d", q.Len(), q.bytes)
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package sendqueue
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// Expiry は一定時間より古いメトリックを破棄し、破棄した件数を数える
+// nil の場合は破棄も集計もしない
+type Expiry struct {
+	// 0 の場合は時間では破棄しない
+	maxAge time.Duration
+
+	// 起動からの累計
+	discarded atomic.Uint64
+}
+
+func NewExpiry(maxAge time.Duration) *Expiry {
+	return &Expiry{maxAge: maxAge}
+}
+
+// Filter は item から古いメトリックを除く。すべて除かれた場合は false を返す
+func (e *Expiry) Filter(item Item, now time.Time) (Item, bool) {
+	if e == nil || e.maxAge <= 0 {
+		return item, true
+	}
+	deadline := now.Add(-e.maxAge).Unix()
+
+	var expired int
+	for _, m := range item.Metrics {
+		if m.Time < deadline {
+			expired++
+		}
+	}
+	if expired == 0 {
+		return item, true
+	}
+	e.discarded.Add(uint64(expired))
+	if expired == len(item.Metrics) {
+		return Item{}, false
+	}
+
+	metrics := make([]*mackerel.MetricValue, 0, len(item.Metrics)-expired)
+	for _, m := range item.Metrics {
+		if m.Time >= deadline {
+			metrics = append(metrics, m)
+		}
+	}
+	item.Metrics = metrics
+	return item, true
+}
+
+// Discard は投稿できなかったメトリックを破棄した件数に加える
+func (e *Expiry) Discard(n int) {
+	if e == nil {
+		return
+	}
+	e.discarded.Add(uint64(n))
+}
+
+// 起動から破棄したメトリックの件数
+func (e *Expiry) Discarded() uint64 {
+	if e == nil {
+		return 0
+	}
+	return e.discarded.Load()
+}
@@ -0,0 +1,90 @@
+package sendqueue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+func TestExpiry(t *testing.T) {
+	now := time.Now()
+	fresh := &mackerel.MetricValue{Name: "fresh", Time: now.Unix(), Value: 1}
+	old := &mackerel.MetricValue{Name: "old", Time: now.Add(-2 * time.Hour).Unix(), Value: 2}
+
+	t.Run("partial", func(t *testing.T) {
+		e := NewExpiry(time.Hour)
+		item, ok := e.Filter(Item{HostID: "host", Metrics: []*mackerel.MetricValue{old, fresh}}, now)
+		if !ok {
+			t.Fatal("item is dropped")
+		}
+		if diff := cmp.Diff(item, Item{HostID: "host", Metrics: []*mackerel.MetricValue{fresh}}); diff != "" {
+			t.Errorf("value is mismatch (-actual +expected):%s", diff)
+		}
+		if e.Discarded() != 1 {
+			t.Errorf("invalid discarded: %d", e.Discarded())
+		}
+	})
+
+	t.Run("all", func(t *testing.T) {
+		e := NewExpiry(time.Hour)
+		if _, ok := e.Filter(Item{Metrics: []*mackerel.MetricValue{old, old}}, now); ok {
+			t.Error("item is not dropped")
+		}
+		if e.Discarded() != 2 {
+			t.Errorf("invalid discarded: %d", e.Discarded())
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		for _, e := range []*Expiry{nil, NewExpiry(0)} {
+			item, ok := e.Filter(Item{Metrics: []*mackerel.MetricValue{old}}, now)
+			if !ok || len(item.Metrics) != 1 {
+				t.Errorf("item is filtered: %v", item)
+			}
+			if e.Discarded() != 0 {
+				t.Errorf("invalid discarded: %d", e.Discarded())
+			}
+		}
+	})
+
+	t.Run("enqueue", func(t *testing.T) {
+		q := New()
+		e := NewExpiry(time.Hour)
+		q.SetExpiry(e)
+		q.Enqueue("host", []*mackerel.MetricValue{fresh})
+		q.Enqueue("host", []*mackerel.MetricValue{old})
+		q.ReEnqueue(Item{HostID: "host", Metrics: []*mackerel.MetricValue{old}})
+
+		if q.Len() != 1 {
+			t.Errorf("invalid length: %d", q.Len())
+		}
+		if e.Discarded() != 2 {
+			t.Errorf("invalid discarded: %d", e.Discarded())
+		}
+	})
+
+	t.Run("dequeue", func(t *testing.T) {
+		q := New()
+		q.Enqueue("host", []*mackerel.MetricValue{old})
+		q.Enqueue("host", []*mackerel.MetricValue{fresh})
+		// キューで待機している間に古くなったものは取り出さない
+		e := NewExpiry(time.Hour)
+		q.SetExpiry(e)
+
+		item, ok := q.Dequeue()
+		if !ok {
+			t.Fatal("queue is empty")
+		}
+		if diff := cmp.Diff(item, Item{HostID: "host", Metrics: []*mackerel.MetricValue{fresh}}); diff != "" {
+			t.Errorf("value is mismatch (-actual +expected):%s", diff)
+		}
+		if _, ok := q.Dequeue(); ok {
+			t.Error("queue is not empty")
+		}
+		if e.Discarded() != 1 {
+			t.Errorf("invalid discarded: %d", e.Discarded())
+		}
+	})
+}
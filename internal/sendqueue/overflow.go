@@ -0,0 +1,97 @@
+package sendqueue
+
+import (
+	"log/slog"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/config"
+)
+
+// 1メトリックあたりの、名前以外の大きさの概算
+const metricOverhead = 64
+
+type spiller interface {
+	Spill(items []Item) error
+}
+
+// SetLimit は送信キューの上限を設定する。投稿を開始する前に呼び出すこと
+func (q *Queue) SetLimit(conf *config.SendQueue) {
+	q.limit = conf
+}
+
+// SetSpiller は spill-to-disk で溢れた値の書き出し先を設定する
+// 設定しない場合は古い値から破棄する
+func (q *Queue) SetSpiller(s spiller) {
+	q.spiller = s
+}
+
+// 上限により破棄したメトリックの件数
+func (q *Queue) Dropped() uint64 {
+	return q.dropped.Load()
+}
+
+func (i Item) size() int64 {
+	var size int64
+	for _, m := range i.Metrics {
+		size += int64(len(m.Name)) + metricOverhead
+	}
+	return size
+}
+
+// full は item を追加すると上限を超えるかを返す
+func (q *Queue) full(item Item) bool {
+	return q.exceeds(q.buffers.Len()+1, q.bytes+item.size(), 1)
+}
+
+// exceeds は items, bytes が上限の 1/divisor を超えるかを返す
+func (q *Queue) exceeds(items int, bytes int64, divisor int) bool {
+	if q.limit.MaxItems > 0 && items > q.limit.MaxItems/divisor {
+		return true
+	}
+	return q.limit.MaxBytes > 0 && bytes > q.limit.MaxBytes/int64(divisor)
+}
+
+// evict は上限を超えた分を古い値から取り出す
+// spill-to-disk では書き出す回数を減らすため、上限の半分まで取り出す
+func (q *Queue) evict() []Item {
+	if !q.exceeds(q.buffers.Len(), q.bytes, 1) {
+		return nil
+	}
+	divisor := 1
+	if q.limit.Overflow == config.OverflowSpillToDisk && q.spiller != nil {
+		divisor = 2
+	}
+
+	var evicted []Item
+	for q.exceeds(q.buffers.Len(), q.bytes, divisor) {
+		item, ok := q.popFront()
+		if !ok {
+			break
+		}
+		evicted = append(evicted, item)
+	}
+	return evicted
+}
+
+// overflow は上限により取り出した値を書き出すか破棄する
+func (q *Queue) overflow(evicted []Item) {
+	if len(evicted) == 0 {
+		return
+	}
+
+	if q.limit.Overflow == config.OverflowSpillToDisk && q.spiller != nil {
+		err := q.spiller.Spill(evicted)
+		if err == nil {
+			q.Ack(evicted...)
+			return
+		}
+		slog.Warn("failed spill send queue", slog.String("error", err.Error()))
+	}
+
+	var metrics int
+	for _, item := range evicted {
+		metrics += len(item.Metrics)
+	}
+	q.dropped.Add(uint64(metrics))
+	q.Ack(evicted...)
+	slog.Warn("drop metrics because send queue is full", slog.String("overflow", string(q.limit.Overflow)), slog.Int("items", len(evicted)), slog.Int("metrics", metrics))
+}
@@ -0,0 +1,123 @@
+package sendqueue
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mackerelio/mackerel-client-go"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/config"
+)
+
+type mockSpiller struct {
+	spilled []Item
+	err     error
+}
+
+func (m *mockSpiller) Spill(items []Item) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.spilled = append(m.spilled, items...)
+	return nil
+}
+
+func hostIDs(items []Item) []string {
+	var ids []string
+	for _, item := range items {
+		ids = append(ids, item.HostID)
+	}
+	return ids
+}
+
+func TestOverflow(t *testing.T) {
+	metrics := []*mackerel.MetricValue{{Name: "name", Time: time.Now().Unix(), Value: 1}}
+	enqueue := func(q *Queue, ids ...string) {
+		for _, id := range ids {
+			q.Enqueue(id, metrics)
+		}
+	}
+
+	t.Run("unlimited", func(t *testing.T) {
+		q := New()
+		q.SetLimit(nil)
+		enqueue(q, "a", "b", "c")
+		if q.Len() != 3 || q.Dropped() != 0 {
+			t.Errorf("invalid len: %d, dropped: %d", q.Len(), q.Dropped())
+		}
+	})
+
+	t.Run("drop-oldest", func(t *testing.T) {
+		q := New()
+		q.SetLimit(&config.SendQueue{MaxItems: 2, Overflow: config.OverflowDropOldest})
+		enqueue(q, "a", "b", "c")
+		if ids := hostIDs(q.FrontN(10)); len(ids) != 2 || ids[0] != "b" || ids[1] != "c" {
+			t.Errorf("invalid items: %v", ids)
+		}
+		if q.Dropped() != 1 {
+			t.Errorf("invalid dropped: %d", q.Dropped())
+		}
+	})
+
+	t.Run("drop-newest", func(t *testing.T) {
+		q := New()
+		q.SetLimit(&config.SendQueue{MaxItems: 2, Overflow: config.OverflowDropNewest})
+		enqueue(q, "a", "b", "c")
+		if ids := hostIDs(q.FrontN(10)); len(ids) != 2 || ids[0] != "a" || ids[1] != "b" {
+			t.Errorf("invalid items: %v", ids)
+		}
+		if q.Dropped() != 1 {
+			t.Errorf("invalid dropped: %d", q.Dropped())
+		}
+	})
+
+	t.Run("max-bytes", func(t *testing.T) {
+		q := New()
+		q.SetLimit(&config.SendQueue{MaxBytes: 2 * (int64(len("name")) + metricOverhead), Overflow: config.OverflowDropOldest})
+		enqueue(q, "a", "b", "c")
+		if q.Len() != 2 || q.Dropped() != 1 {
+			t.Errorf("invalid len: %d, dropped: %d", q.Len(), q.Dropped())
+		}
+		// 取り出した分は上限から外れる
+		q.Dequeue()
+		enqueue(q, "d")
+		if q.Len() != 2 || q.Dropped() != 1 {
+			t.Errorf("invalid len: %d, dropped: %d", q.Len(), q.Dropped())
+		}
+	})
+
+	t.Run("spill-to-disk", func(t *testing.T) {
+		q := New()
+		s := &mockSpiller{}
+		q.SetLimit(&config.SendQueue{MaxItems: 4, Overflow: config.OverflowSpillToDisk})
+		q.SetSpiller(s)
+		enqueue(q, "a", "b", "c", "d", "e")
+		// 上限の半分まで書き出す
+		if ids := hostIDs(s.spilled); len(ids) != 3 || ids[0] != "a" || ids[2] != "c" {
+			t.Errorf("invalid spilled: %v", ids)
+		}
+		if q.Len() != 2 || q.Dropped() != 0 {
+			t.Errorf("invalid len: %d, dropped: %d", q.Len(), q.Dropped())
+		}
+	})
+
+	t.Run("spill failure", func(t *testing.T) {
+		q := New()
+		q.SetLimit(&config.SendQueue{MaxItems: 4, Overflow: config.OverflowSpillToDisk})
+		q.SetSpiller(&mockSpiller{err: errors.New("disk full")})
+		enqueue(q, "a", "b", "c", "d", "e")
+		if q.Len() != 2 || q.Dropped() != 3 {
+			t.Errorf("invalid len: %d, dropped: %d", q.Len(), q.Dropped())
+		}
+	})
+
+	t.Run("spill without disk-cache", func(t *testing.T) {
+		q := New()
+		q.SetLimit(&config.SendQueue{MaxItems: 4, Overflow: config.OverflowSpillToDisk})
+		enqueue(q, "a", "b", "c", "d", "e")
+		if q.Len() != 4 || q.Dropped() != 1 {
+			t.Errorf("invalid len: %d, dropped: %d", q.Len(), q.Dropped())
+		}
+	})
+}
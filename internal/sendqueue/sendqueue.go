@@ -2,16 +2,38 @@ package sendqueue
 
 import (
 	"container/list"
+	"log/slog"
 	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/mackerelio/mackerel-client-go"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/config"
 )
 
+type journal interface {
+	Append(items []Item) ([]uint64, error)
+	Ack(seq uint64)
+}
+
 type Queue struct {
 	mu sync.Mutex
 
 	buffers *list.List
+	// buffers に積まれた値の大きさの概算
+	bytes int64
+	// 無効な場合は nil
+	journal journal
+	expiry  *Expiry
+
+	// nil の場合は上限なし
+	limit *config.SendQueue
+	// 無効な場合は nil
+	spiller spiller
+	// 上限により破棄したメトリックの件数。起動からの累計
+	dropped atomic.Uint64
 }
 
 func New() *Queue {
@@ -20,6 +42,24 @@ func New() *Queue {
 	}
 }
 
+// NewJournaled は追加された値を journal に記録する Queue を返す
+// replay は前回の起動時に投稿が完了しなかった値で、journal には記録済み
+func NewJournaled(j journal, replay []Item) *Queue {
+	q := &Queue{
+		buffers: list.New(),
+		journal: j,
+	}
+	for _, item := range replay {
+		q.pushBack(item)
+	}
+	return q
+}
+
+// SetExpiry は古いメトリックを破棄するよう設定する。投稿を開始する前に呼び出すこと
+func (q *Queue) SetExpiry(e *Expiry) {
+	q.expiry = e
+}
+
 func (q *Queue) Len() int {
 	q.mu.Lock()
 	defer q.mu.Unlock()
@@ -27,37 +67,82 @@ func (q *Queue) Len() int {
 }
 
 type Item struct {
+	// journal での番号。記録していない場合は 0
+	Seq     uint64 `json:"-"`
 	HostID  string
 	Metrics []*mackerel.MetricValue
 }
 
 func (q *Queue) Enqueue(hostID string, rawMetrics []*mackerel.MetricValue) {
-	q.mu.Lock()
-	defer q.mu.Unlock()
 	// When a large item cannot be sent, the error never goes away.
 	// Therefore, divide it into appropriate numbers.
+	now := time.Now()
+	var items []Item
 	for chunk := range slices.Chunk(rawMetrics, 50) {
-		q.buffers.PushBack(Item{HostID: hostID, Metrics: chunk})
+		if item, ok := q.expiry.Filter(Item{HostID: hostID, Metrics: chunk}, now); ok {
+			items = append(items, item)
+		}
 	}
-}
-func (q *Queue) ReEnqueue(hostID string, rawMetrics []*mackerel.MetricValue) {
+
+	if q.journal != nil && len(items) > 0 {
+		seqs, err := q.journal.Append(items)
+		if err != nil {
+			// 記録に失敗しても、メモリ上では送信を続ける
+			slog.Warn("failed append journal", slog.String("error", err.Error()))
+		} else {
+			for idx := range items {
+				items[idx].Seq = seqs[idx]
+			}
+		}
+	}
+
 	q.mu.Lock()
-	defer q.mu.Unlock()
-	q.buffers.PushFront(Item{HostID: hostID, Metrics: rawMetrics})
+	var evicted []Item
+	for _, item := range items {
+		if q.limit != nil && q.limit.Overflow == config.OverflowDropNewest && q.full(item) {
+			evicted = append(evicted, item)
+			continue
+		}
+		q.pushBack(item)
+	}
+	if q.limit != nil && q.limit.Overflow != config.OverflowDropNewest {
+		evicted = q.evict()
+	}
+	q.mu.Unlock()
+
+	q.overflow(evicted)
 }
 
-func (q *Queue) Dequeue() (hostID string, rawMetrics []*mackerel.MetricValue, ok bool) {
+func (q *Queue) ReEnqueue(item Item) {
+	filtered, ok := q.expiry.Filter(item, time.Now())
+	if !ok {
+		q.Ack(item)
+		return
+	}
+	// 取り出した値を戻すだけなので、上限は確認しない
 	q.mu.Lock()
 	defer q.mu.Unlock()
+	q.pushFront(filtered)
+}
 
-	e := q.buffers.Front()
-	if e == nil {
-		return "", nil, false
+func (q *Queue) Dequeue() (Item, bool) {
+	batch := q.DequeueBatch(0)
+	if len(batch) == 0 {
+		return Item{}, false
 	}
-	q.buffers.Remove(e)
+	return batch[0], true
+}
 
-	value := e.Value.(Item)
-	return value.HostID, value.Metrics, true
+// Ack は投稿が完了した、またはディスクキャッシュに書き出した値を journal から外す
+func (q *Queue) Ack(items ...Item) {
+	if q.journal == nil {
+		return
+	}
+	for _, item := range items {
+		if item.Seq != 0 {
+			q.journal.Ack(item.Seq)
+		}
+	}
 }
 
 func (q *Queue) FrontN(length int) (items []Item) {
@@ -65,13 +150,36 @@ func (q *Queue) FrontN(length int) (items []Item) {
 	defer q.mu.Unlock()
 
 	for range length {
-		e := q.buffers.Front()
-		if e == nil {
-			continue
+		item, ok := q.popFront()
+		if !ok {
+			break
 		}
-		items = append(items, e.Value.(Item))
-		q.buffers.Remove(e)
+		items = append(items, item)
 	}
 
 	return
 }
+
+// 以下は q.mu を取得した状態で呼び出す
+
+func (q *Queue) pushBack(item Item) {
+	q.buffers.PushBack(item)
+	q.bytes += item.size()
+}
+
+func (q *Queue) pushFront(item Item) {
+	q.buffers.PushFront(item)
+	q.bytes += item.size()
+}
+
+func (q *Queue) popFront() (Item, bool) {
+	e := q.buffers.Front()
+	if e == nil {
+		return Item{}, false
+	}
+	q.buffers.Remove(e)
+
+	item := e.Value.(Item)
+	q.bytes -= item.size()
+	return item, true
+}
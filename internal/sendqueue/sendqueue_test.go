@@ -73,3 +73,42 @@ func TestSendQueue(t *testing.T) {
 		}
 	})
 }
+
+type mockJournal struct {
+	seq   uint64
+	acked []uint64
+}
+
+func (m *mockJournal) Append(items []Item) ([]uint64, error) {
+	var seqs []uint64
+	for range items {
+		m.seq++
+		seqs = append(seqs, m.seq)
+	}
+	return seqs, nil
+}
+
+func (m *mockJournal) Ack(seq uint64) {
+	m.acked = append(m.acked, seq)
+}
+
+func TestJournaledQueue(t *testing.T) {
+	j := &mockJournal{seq: 10}
+	q := NewJournaled(j, []Item{{Seq: 10, HostID: "replay"}})
+
+	q.Enqueue("a", []*mackerel.MetricValue{{Name: "name", Time: 1, Value: 1}})
+
+	replay, _ := q.Dequeue()
+	item, _ := q.Dequeue()
+	if replay.Seq != 10 || replay.HostID != "replay" || item.Seq != 11 || item.HostID != "a" {
+		t.Errorf("invalid items: %+v, %+v", replay, item)
+	}
+
+	// 再投入しても番号は変わらない
+	q.ReEnqueue(item)
+	item, _ = q.Dequeue()
+	q.Ack(replay, item, Item{HostID: "not journaled"})
+	if diff := cmp.Diff(j.acked, []uint64{10, 11}); diff != "" {
+		t.Errorf("value is mismatch (-actual +expected):%s", diff)
+	}
+}
@@ -0,0 +1,230 @@
+package snmp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/gosnmp/gosnmp"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/config"
+)
+
+// host:port ごとの *pool
+var pools sync.Map
+
+type pool struct {
+	mu sync.Mutex
+	// 空きスロット。上限が変わった場合は作り直し、確保済みのスロットは元のチャネルに返却する
+	sem   chan struct{}
+	limit int
+	idle  []*idleHandler
+	// この接続先を使う collector。Register されたことがなければ nil
+	registrations map[*registration]struct{}
+}
+
+type registration struct {
+	key         string
+	maxSessions int
+}
+
+type idleHandler struct {
+	key     string
+	handler Handler
+}
+
+type session struct {
+	pool    *pool
+	sem     chan struct{}
+	key     string
+	handler Handler
+	tracker *trackingHandler
+}
+
+func devicePool(param config.CollectorSNMPConfig) *pool {
+	name := net.JoinHostPort(param.Host, fmt.Sprint(param.Port))
+	p, _ := pools.LoadOrStore(name, &pool{})
+	return p.(*pool)
+}
+
+// Register は collector が接続先を使うことを登録し、登録を解除する関数を返す
+// 同じ host:port への同時セッション数の上限は、登録されている collector の max-sessions の最大値とする
+// 登録を解除すると、ほかの collector が使わない空きハンドラを閉じる
+func Register(param config.CollectorSNMPConfig) (unregister func()) {
+	p := devicePool(param)
+	r := &registration{key: handlerKey(param), maxSessions: param.MaxSessions}
+
+	p.mu.Lock()
+	if p.registrations == nil {
+		p.registrations = make(map[*registration]struct{})
+	}
+	p.registrations[r] = struct{}{}
+	p.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { p.unregister(r) })
+	}
+}
+
+func (p *pool) unregister(r *registration) {
+	p.mu.Lock()
+	delete(p.registrations, r)
+	var closing []Handler
+	idle := p.idle[:0]
+	for _, h := range p.idle {
+		if p.used(h.key) {
+			idle = append(idle, h)
+		} else {
+			closing = append(closing, h.handler)
+		}
+	}
+	p.idle = idle
+	p.mu.Unlock()
+
+	for _, h := range closing {
+		h.Close() // nolint
+	}
+}
+
+// used は key のハンドラを使う collector が登録されているかを返す。p.mu を確保して呼び出す
+func (p *pool) used(key string) bool {
+	for r := range p.registrations {
+		if r.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// 同じ接続先でも認証情報などが異なるハンドラは再利用しない
+func handlerKey(param config.CollectorSNMPConfig) string {
+	key := fmt.Sprintf("%s|%d|%s|%d", param.Host, param.Port, param.Timeout, param.Retry)
+	if param.V2c != nil {
+		key += "|v2c|" + param.V2c.Community
+	}
+	if param.V3 != nil {
+		key += fmt.Sprintf("|v3|%+v", *param.V3)
+	}
+	return key
+}
+
+// collector ごとに上限が異なっても作り直しを繰り返さないよう、登録されている上限の最大値を使う
+// 登録されていない場合は limit を使う
+func (p *pool) semaphore(limit int) chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.registrations) > 0 {
+		limit = 0
+		for r := range p.registrations {
+			limit = max(limit, r.maxSessions)
+		}
+	}
+	limit = max(limit, 1)
+	if p.sem == nil || p.limit != limit {
+		p.sem = make(chan struct{}, limit)
+		p.limit = limit
+	}
+	return p.sem
+}
+
+func (p *pool) acquire(ctx context.Context, param config.CollectorSNMPConfig) (*session, error) {
+	sem := p.semaphore(param.MaxSessions)
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	key := handlerKey(param)
+	handler := p.takeIdle(key)
+	if handler == nil {
+		handler = NewHandler(param)
+		handler.SetContext(ctx)
+		if err := handler.Connect(); err != nil {
+			<-sem
+			return nil, err
+		}
+	} else {
+		handler.SetContext(ctx)
+	}
+
+	return &session{
+		pool:    p,
+		sem:     sem,
+		key:     key,
+		handler: handler,
+		tracker: &trackingHandler{Handler: handler},
+	}, nil
+}
+
+func (p *pool) takeIdle(key string) Handler {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := len(p.idle) - 1; i >= 0; i-- {
+		if p.idle[i].key == key {
+			h := p.idle[i].handler
+			p.idle = append(p.idle[:i], p.idle[i+1:]...)
+			return h
+		}
+	}
+	return nil
+}
+
+func (s *session) release() error {
+	defer func() { <-s.sem }()
+
+	if s.tracker.failed {
+		return s.handler.Close()
+	}
+	// 返却後に前回の context が参照されないようにする
+	s.handler.SetContext(context.Background())
+
+	p := s.pool
+	p.mu.Lock()
+	if p.registrations != nil && !p.used(s.key) {
+		// 使用中に collector が削除された
+		p.mu.Unlock()
+		return s.handler.Close()
+	}
+	p.idle = append(p.idle, &idleHandler{key: s.key, handler: s.handler})
+	var evicted []Handler
+	for len(p.idle) > p.limit {
+		evicted = append(evicted, p.idle[0].handler)
+		p.idle = p.idle[1:]
+	}
+	p.mu.Unlock()
+
+	var err error
+	for _, h := range evicted {
+		if e := h.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// trackingHandler はエラーの発生を記録し、壊れた可能性のあるハンドラを再利用しないようにする
+type trackingHandler struct {
+	Handler
+	failed bool
+}
+
+func (h *trackingHandler) Get(oids []string) (*gosnmp.SnmpPacket, error) {
+	result, err := h.Handler.Get(oids)
+	if err != nil {
+		h.failed = true
+	}
+	return result, err
+}
+
+func (h *trackingHandler) BulkWalk(rootOid string, walkFn gosnmp.WalkFunc) error {
+	err := h.Handler.BulkWalk(rootOid, walkFn)
+	if err != nil {
+		h.failed = true
+	}
+	return err
+}
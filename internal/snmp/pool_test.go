@@ -0,0 +1,222 @@
+package snmp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/config"
+)
+
+// agent は応答に delay だけかかる機器の代わりとなる SNMP エージェント
+type agent struct {
+	conn  *net.UDPConn
+	delay time.Duration
+
+	inflight    atomic.Int32
+	maxInflight atomic.Int32
+}
+
+func startAgent(tb testing.TB, delay time.Duration) *agent {
+	tb.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	a := &agent{conn: conn, delay: delay}
+	tb.Cleanup(func() { conn.Close() }) // nolint
+
+	go func() {
+		for {
+			buf := make([]byte, 65535)
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			go a.respond(buf[:n], addr)
+		}
+	}()
+	return a
+}
+
+func (a *agent) respond(req []byte, addr *net.UDPAddr) {
+	current := a.inflight.Add(1)
+	for {
+		prev := a.maxInflight.Load()
+		if current <= prev || a.maxInflight.CompareAndSwap(prev, current) {
+			break
+		}
+	}
+
+	packet, err := gosnmp.Default.SnmpDecodePacket(req)
+	time.Sleep(a.delay)
+	// 応答を受け取ったクライアントが次の要求を送るより前に減らしておく
+	a.inflight.Add(-1)
+	if err != nil {
+		return
+	}
+
+	packet.PDUType = gosnmp.GetResponse
+	for i := range packet.Variables {
+		packet.Variables[i].Type = gosnmp.Counter32
+		packet.Variables[i].Value = uint32(i)
+	}
+	res, err := packet.MarshalMsg()
+	if err != nil {
+		return
+	}
+	a.conn.WriteToUDP(res, addr) // nolint
+}
+
+func (a *agent) param(tb testing.TB, maxSessions int) config.CollectorSNMPConfig {
+	tb.Helper()
+	addr := a.conn.LocalAddr().(*net.UDPAddr)
+	filename := filepath.Join(tb.TempDir(), "config.yaml")
+	err := os.WriteFile(filename, []byte(fmt.Sprintf(`
+x-api-key: dummy
+collector:
+- host-id: dummy
+  community: public
+  host: 127.0.0.1
+  port: %d
+  timeout: 2s
+  retry: 1
+  max-sessions: %d
+`, addr.Port, maxSessions)), 0o600)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	conf, err := config.Init(filename)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return conf.Collector[0].SNMP
+}
+
+func poll(ctx context.Context, param config.CollectorSNMPConfig) error {
+	client, err := Connect(ctx, param)
+	if err != nil {
+		return err
+	}
+	defer client.Close() // nolint
+	_, err = client.GetValues([]string{MIBifNumber})
+	return err
+}
+
+func TestConnectLimitsSessions(t *testing.T) {
+	for _, limit := range []int{1, 3} {
+		t.Run(fmt.Sprintf("max-sessions=%d", limit), func(t *testing.T) {
+			a := startAgent(t, 20*time.Millisecond)
+			param := a.param(t, limit)
+			defer Register(param)()
+
+			var wg sync.WaitGroup
+			for range 8 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := poll(context.Background(), param); err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if got := int(a.maxInflight.Load()); got > limit {
+				t.Errorf("concurrent requests = %d, want <= %d", got, limit)
+			}
+			p := devicePool(param)
+			if got := len(p.idle); got < 1 || got > limit {
+				t.Errorf("idle handlers = %d, want 1..%d", got, limit)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	a := startAgent(t, 20*time.Millisecond)
+	params := []config.CollectorSNMPConfig{a.param(t, 1), a.param(t, 3)}
+	unregister := []func(){Register(params[0]), Register(params[1])}
+
+	p := devicePool(params[0])
+	sem := p.semaphore(params[0].MaxSessions)
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := poll(context.Background(), params[i%2]); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// max-sessions の異なる collector があっても、最大値の上限を共有する
+	if got := int(a.maxInflight.Load()); got > 3 {
+		t.Errorf("concurrent requests = %d, want <= 3", got)
+	}
+	if p.semaphore(params[1].MaxSessions) != sem || p.limit != 3 {
+		t.Errorf("semaphore is recreated, limit = %d", p.limit)
+	}
+
+	unregister[0]()
+	if len(p.idle) == 0 {
+		t.Error("idle handlers used by another collector are closed")
+	}
+	unregister[1]()
+	if len(p.idle) != 0 {
+		t.Errorf("idle handlers = %d, want 0 after all collectors are removed", len(p.idle))
+	}
+}
+
+func TestConnectCanceled(t *testing.T) {
+	a := startAgent(t, 0)
+	param := a.param(t, 1)
+
+	held, err := Connect(context.Background(), param)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close() // nolint
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := Connect(ctx, param); err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// 応答に 5ms かかる機器に対して、4つの処理が同時にポーリングする場合の所要時間
+func BenchmarkPoll(b *testing.B) {
+	for _, limit := range []int{1, 4} {
+		b.Run(fmt.Sprintf("max-sessions=%d", limit), func(b *testing.B) {
+			a := startAgent(b, 5*time.Millisecond)
+			param := a.param(b, limit)
+			defer Register(param)()
+
+			b.ResetTimer()
+			for range b.N {
+				var wg sync.WaitGroup
+				for range 4 {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						if err := poll(context.Background(), param); err != nil {
+							b.Error(err)
+						}
+					}()
+				}
+				wg.Wait()
+			}
+		})
+	}
+}
@@ -7,37 +7,24 @@ import (
 	"net"
 	"strconv"
 	"strings"
-	"sync"
 
 	"github.com/gosnmp/gosnmp"
 	"github.com/mackerelio-labs/sabatrafficd/internal/config"
 )
 
 const (
+	MIBsysUpTime      = "1.3.6.1.2.1.1.3.0"
 	MIBifNumber       = "1.3.6.1.2.1.2.1.0"
 	MIBifDescr        = "1.3.6.1.2.1.2.2.1.2"
+	MIBifSpeed        = "1.3.6.1.2.1.2.2.1.5"
 	MIBifPhysAddress  = "1.3.6.1.2.1.2.2.1.6"
 	MIBifOperStatus   = "1.3.6.1.2.1.2.2.1.8"
+	MIBifHighSpeed    = "1.3.6.1.2.1.31.1.1.1.15"
+	MIBifAlias        = "1.3.6.1.2.1.31.1.1.1.18"
 	MIBipAdEntIfIndex = "1.3.6.1.2.1.4.20.1.2"
-)
-
-var locks sync.Map
-
-func lock(conn string) {
-	lock_, _ := locks.LoadOrStore(conn, &sync.Mutex{})
-	lock := lock_.(*sync.Mutex)
-	lock.Lock()
-}
-
-func unlock(conn string) {
-	lock_, ok := locks.Load(conn)
-	if !ok {
-		panic("attempt to unlock non-existing lock")
-	}
 
-	lock := lock_.(*sync.Mutex)
-	lock.Unlock()
-}
+	MIBifCounterDiscontinuityTime = "1.3.6.1.2.1.31.1.1.1.19"
+)
 
 type Handler interface {
 	Get(oids []string) (result *gosnmp.SnmpPacket, err error)
@@ -49,29 +36,25 @@ type Handler interface {
 }
 
 type SNMP struct {
-	handler  Handler
-	lockName string
+	handler Handler
+	session *session
 }
 
-func Connect(ctx context.Context, param config.CollectorSNMPConfig, handler Handler) (*SNMP, error) {
-	lockName := net.JoinHostPort(param.Host, fmt.Sprint(param.Port))
-	lock(lockName)
-
-	handler.SetContext(ctx)
-	if err := handler.Connect(); err != nil {
-		unlock(lockName)
+// Connect は機器ごとの同時セッション数の上限内でセッションを確保する
+// 接続済みのハンドラが空いていれば再利用する
+func Connect(ctx context.Context, param config.CollectorSNMPConfig) (*SNMP, error) {
+	p := devicePool(param)
+	sess, err := p.acquire(ctx, param)
+	if err != nil {
 		return nil, err
 	}
 
-	return &SNMP{
-		handler:  handler,
-		lockName: lockName,
-	}, nil
+	return &SNMP{handler: sess.tracker, session: sess}, nil
 }
 
+// Close はセッションを返却する。エラーが発生したハンドラは再利用せずに閉じる
 func (s *SNMP) Close() error {
-	defer unlock(s.lockName)
-	return s.handler.Close()
+	return s.session.release()
 }
 
 var (
@@ -95,9 +78,31 @@ func (s *SNMP) GetInterfaceNumber() (uint64, error) {
 	}
 }
 
-func (s *SNMP) BulkWalkGetInterfaceName(length uint64) (map[uint64]string, error) {
+// GetInterfaceNumberAndUpTime は ifNumber と sysUpTime (1/100秒単位) を1回の要求で取得する
+func (s *SNMP) GetInterfaceNumberAndUpTime() (uint64, uint64, error) {
+	result, err := s.handler.Get([]string{MIBifNumber, MIBsysUpTime})
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(result.Variables) != 2 {
+		return 0, 0, errGetInterfaceNumber
+	}
+	var values [2]uint64
+	for idx, variable := range result.Variables {
+		switch variable.Type {
+		case gosnmp.OctetString, gosnmp.NoSuchObject, gosnmp.NoSuchInstance:
+			return 0, 0, errGetInterfaceNumber
+		default:
+			values[idx] = gosnmp.ToBigInt(variable.Value).Uint64()
+		}
+	}
+	return values[0], values[1], nil
+}
+
+// BulkWalkGetInterfaceName は ifDescr や ifName など、インターフェイス名を表す oid を取得する
+func (s *SNMP) BulkWalkGetInterfaceName(oid string, length uint64) (map[uint64]string, error) {
 	kv := make(map[uint64]string, length)
-	err := s.handler.BulkWalk(MIBifDescr, func(pdu gosnmp.SnmpPDU) error {
+	err := s.handler.BulkWalk(oid, func(pdu gosnmp.SnmpPDU) error {
 		index, err := captureIfIndex(pdu.Name)
 		if err != nil {
 			return err
@@ -147,6 +152,35 @@ func (s *SNMP) BulkWalkGetInterfaceState(length uint64) (map[uint64]bool, error)
 	return kv, nil
 }
 
+// BulkWalkGetInterfaceSpeed はインターフェイスの速度を bps で返す
+// ifHighSpeed (Mbps) を優先し、取得できないか 0 のインターフェイスは ifSpeed を使う
+func (s *SNMP) BulkWalkGetInterfaceSpeed(length uint64) (map[uint64]uint64, error) {
+	highSpeed, err := s.BulkWalk(MIBifHighSpeed, length)
+	if err != nil {
+		return nil, err
+	}
+	kv := make(map[uint64]uint64, length)
+	for ifIndex, mbps := range highSpeed {
+		if mbps > 0 {
+			kv[ifIndex] = mbps * 1_000_000
+		}
+	}
+	if uint64(len(kv)) >= length {
+		return kv, nil
+	}
+
+	speed, err := s.BulkWalk(MIBifSpeed, length)
+	if err != nil {
+		return nil, err
+	}
+	for ifIndex, bps := range speed {
+		if _, exists := kv[ifIndex]; !exists && bps > 0 {
+			kv[ifIndex] = bps
+		}
+	}
+	return kv, nil
+}
+
 func (s *SNMP) BulkWalk(oid string, length uint64) (map[uint64]uint64, error) {
 	kv := make(map[uint64]uint64, length)
 	err := s.handler.BulkWalk(oid, func(pdu gosnmp.SnmpPDU) error {
@@ -168,6 +202,52 @@ func (s *SNMP) BulkWalk(oid string, length uint64) (map[uint64]uint64, error) {
 	return kv, nil
 }
 
+// BulkWalkValues は oid 配下の値を、oid 以降のインデックス部分をキーとして返す
+func (s *SNMP) BulkWalkValues(oid string) (map[string]Value, error) {
+	kv := make(map[string]Value)
+	err := s.handler.BulkWalk(oid, func(pdu gosnmp.SnmpPDU) error {
+		v, err := pduValue(pdu)
+		if err != nil {
+			return err
+		}
+		kv[captureIndex(pdu.Name, oid)] = v
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return kv, nil
+}
+
+// BulkWalkStrings は oid 配下の値を文字列として、oid 以降のインデックス部分をキーとして返す
+func (s *SNMP) BulkWalkStrings(oid string) (map[string]string, error) {
+	kv := make(map[string]string)
+	err := s.handler.BulkWalk(oid, func(pdu gosnmp.SnmpPDU) error {
+		index := captureIndex(pdu.Name, oid)
+		switch pdu.Type {
+		case gosnmp.OctetString:
+			value, ok := pdu.Value.([]byte)
+			if !ok {
+				return errParseError
+			}
+			kv[index] = string(value)
+		default:
+			kv[index] = gosnmp.ToBigInt(pdu.Value).String()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return kv, nil
+}
+
+func captureIndex(name, oid string) string {
+	name = strings.TrimPrefix(name, ".")
+	oid = strings.TrimPrefix(oid, ".")
+	return strings.TrimPrefix(name, oid+".")
+}
+
 func captureIfIndex(name string) (uint64, error) {
 	sl := strings.Split(name, ".")
 	return strconv.ParseUint(sl[len(sl)-1], 10, 64)
@@ -231,29 +311,51 @@ func (s *SNMP) BulkWalkGetInterfacePhysAddress(length uint64) (map[uint64]string
 	return kv, nil
 }
 
-func (s *SNMP) GetValues(mibs []string) ([]float64, error) {
+func (s *SNMP) GetValues(mibs []string) ([]Value, error) {
 	result, err := s.handler.Get(mibs)
 	if err != nil {
 		return nil, err
 	}
-	var values []float64
+	var values []Value
 	for _, variable := range result.Variables {
-		switch variable.Type {
-		case gosnmp.OctetString:
-			value, ok := variable.Value.([]byte)
-			if !ok {
-				return nil, fmt.Errorf("value cant parse : %v", variable.Value)
-			}
-			v, err := strconv.ParseFloat(string(value), 64)
-			if err != nil {
-				return nil, err
-			}
-			values = append(values, v)
-
-		default:
-			v, _ := gosnmp.ToBigInt(variable.Value).Float64()
-			values = append(values, v)
+		v, err := pduValue(variable)
+		if err != nil {
+			return nil, err
 		}
+		values = append(values, v)
 	}
 	return values, nil
 }
+
+// Value は custom-mibs で取得した値
+type Value struct {
+	Float float64
+	// Counter32, Counter64 の値。float64 では 2^53 を超えると精度が落ちるため整数のまま保持する
+	Counter uint64
+	// Counter32 は 32、Counter64 は 64。counter 型でない場合は 0
+	CounterBits int
+}
+
+func pduValue(pdu gosnmp.SnmpPDU) (Value, error) {
+	switch pdu.Type {
+	case gosnmp.OctetString:
+		value, ok := pdu.Value.([]byte)
+		if !ok {
+			return Value{}, fmt.Errorf("value cant parse : %v", pdu.Value)
+		}
+		v, err := strconv.ParseFloat(string(value), 64)
+		if err != nil {
+			return Value{}, err
+		}
+		return Value{Float: v}, nil
+	case gosnmp.Counter32:
+		c := gosnmp.ToBigInt(pdu.Value).Uint64()
+		return Value{Float: float64(c), Counter: c, CounterBits: 32}, nil
+	case gosnmp.Counter64:
+		c := gosnmp.ToBigInt(pdu.Value).Uint64()
+		return Value{Float: float64(c), Counter: c, CounterBits: 64}, nil
+	default:
+		v, _ := gosnmp.ToBigInt(pdu.Value).Float64()
+		return Value{Float: v}, nil
+	}
+}
@@ -14,6 +14,8 @@ type mockHandler struct {
 	rootOid string
 	result  *gosnmp.SnmpPacket
 	pdus    []gosnmp.SnmpPDU
+	// rootOid ごとの pdus
+	walks map[string][]gosnmp.SnmpPDU
 }
 
 func (m *mockHandler) Get(oids []string) (result *gosnmp.SnmpPacket, err error) {
@@ -23,8 +25,12 @@ func (m *mockHandler) Get(oids []string) (result *gosnmp.SnmpPacket, err error)
 
 func (m *mockHandler) BulkWalk(rootOid string, walkFn gosnmp.WalkFunc) error {
 	m.rootOid = rootOid
-	for i := range m.pdus {
-		if err := walkFn(m.pdus[i]); err != nil {
+	pdus := m.pdus
+	if m.walks != nil {
+		pdus = m.walks[rootOid]
+	}
+	for i := range pdus {
+		if err := walkFn(pdus[i]); err != nil {
 			return err
 		}
 	}
@@ -66,6 +72,35 @@ func TestGetInterfaceNumber(t *testing.T) {
 	}
 }
 
+func TestGetInterfaceNumberAndUpTime(t *testing.T) {
+	m := mockHandler{
+		result: &gosnmp.SnmpPacket{
+			Variables: []gosnmp.SnmpPDU{
+				{
+					Type:  gosnmp.Integer,
+					Value: 3,
+				},
+				{
+					Type:  gosnmp.TimeTicks,
+					Value: uint32(123456),
+				},
+			},
+		},
+	}
+	s := &SNMP{handler: &m}
+
+	ifNumber, sysUpTime, err := s.GetInterfaceNumberAndUpTime()
+	if err != nil {
+		t.Error("failed raised error")
+	}
+	if ifNumber != 3 || sysUpTime != 123456 {
+		t.Errorf("invalid result: ifNumber=%d sysUpTime=%d", ifNumber, sysUpTime)
+	}
+	if !reflect.DeepEqual(m.oids, []string{MIBifNumber, MIBsysUpTime}) {
+		t.Error("invalid argument")
+	}
+}
+
 func TestBulkWalkGetInterfaceName(t *testing.T) {
 	m := mockHandler{
 		pdus: []gosnmp.SnmpPDU{
@@ -83,7 +118,7 @@ func TestBulkWalkGetInterfaceName(t *testing.T) {
 	}
 	s := &SNMP{handler: &m}
 
-	actual, err := s.BulkWalkGetInterfaceName(2)
+	actual, err := s.BulkWalkGetInterfaceName(MIBifDescr, 2)
 	expected := map[uint64]string{
 		1: "lo0",
 		2: "eth0",
@@ -161,6 +196,35 @@ func TestBulkWalk(t *testing.T) {
 	}
 }
 
+func TestBulkWalkGetInterfaceSpeed(t *testing.T) {
+	m := mockHandler{
+		walks: map[string][]gosnmp.SnmpPDU{
+			MIBifHighSpeed: {
+				{Name: MIBifHighSpeed + ".1", Type: gosnmp.Gauge32, Value: uint(10000)},
+				{Name: MIBifHighSpeed + ".2", Type: gosnmp.Gauge32, Value: uint(0)},
+			},
+			MIBifSpeed: {
+				{Name: MIBifSpeed + ".1", Type: gosnmp.Gauge32, Value: uint(4294967295)},
+				{Name: MIBifSpeed + ".2", Type: gosnmp.Gauge32, Value: uint(10000000)},
+				{Name: MIBifSpeed + ".3", Type: gosnmp.Gauge32, Value: uint(0)},
+			},
+		},
+	}
+	s := &SNMP{handler: &m}
+
+	actual, err := s.BulkWalkGetInterfaceSpeed(3)
+	if err != nil {
+		t.Error("failed raised error")
+	}
+	expected := map[uint64]uint64{
+		1: 10_000_000_000,
+		2: 10_000_000,
+	}
+	if d := cmp.Diff(actual, expected); d != "" {
+		t.Errorf("invalid result %s", d)
+	}
+}
+
 func TestBulkWalkGetInterfaceIPAddress(t *testing.T) {
 	m := mockHandler{
 		pdus: []gosnmp.SnmpPDU{
@@ -247,19 +311,32 @@ func TestGetValues(t *testing.T) {
 					Type:  gosnmp.Integer,
 					Value: 12345,
 				},
+				{
+					Type:  gosnmp.Counter32,
+					Value: uint(4294967295),
+				},
+				{
+					Type:  gosnmp.Counter64,
+					Value: uint64(18446744073709551615),
+				},
 			},
 		},
 	}
 	s := &SNMP{handler: &m}
 
-	mibs := []string{"1.2.3.4.5.678", "1.2.3.4.5.789"}
+	mibs := []string{"1.2.3.4.5.678", "1.2.3.4.5.789", "1.2.3.4.5.890", "1.2.3.4.5.901"}
 
 	actual, err := s.GetValues(mibs)
 	if err != nil {
 		t.Error("failed raised error")
 	}
 
-	expected := []float64{1.234, 12345}
+	expected := []Value{
+		{Float: 1.234},
+		{Float: 12345},
+		{Float: 4294967295, Counter: 4294967295, CounterBits: 32},
+		{Float: 18446744073709551615, Counter: 18446744073709551615, CounterBits: 64},
+	}
 
 	if !reflect.DeepEqual(actual, expected) {
 		t.Error("invalid result")
@@ -268,3 +345,66 @@ func TestGetValues(t *testing.T) {
 		t.Error("invalid argument")
 	}
 }
+
+func TestBulkWalkValues(t *testing.T) {
+	m := mockHandler{
+		pdus: []gosnmp.SnmpPDU{
+			{
+				Name:  ".1.3.6.1.2.1.25.3.3.1.2.196608",
+				Type:  gosnmp.Integer,
+				Value: 12,
+			},
+			{
+				Name:  ".1.3.6.1.2.1.25.3.3.1.2.196609",
+				Type:  gosnmp.OctetString,
+				Value: []byte("3.5"),
+			},
+		},
+	}
+	s := &SNMP{handler: &m}
+
+	actual, err := s.BulkWalkValues("1.3.6.1.2.1.25.3.3.1.2")
+	if err != nil {
+		t.Error("failed raised error")
+	}
+	expected := map[string]Value{
+		"196608": {Float: 12},
+		"196609": {Float: 3.5},
+	}
+	if d := cmp.Diff(actual, expected); d != "" {
+		t.Errorf("invalid result %s", d)
+	}
+	if !reflect.DeepEqual(m.rootOid, "1.3.6.1.2.1.25.3.3.1.2") {
+		t.Error("invalid argument")
+	}
+}
+
+func TestBulkWalkStrings(t *testing.T) {
+	m := mockHandler{
+		pdus: []gosnmp.SnmpPDU{
+			{
+				Name:  ".1.3.6.1.2.1.47.1.1.1.1.7.1.2",
+				Type:  gosnmp.OctetString,
+				Value: []byte("Slot 1"),
+			},
+			{
+				Name:  ".1.3.6.1.2.1.47.1.1.1.1.7.2",
+				Type:  gosnmp.Integer,
+				Value: 3,
+			},
+		},
+	}
+	s := &SNMP{handler: &m}
+
+	actual, err := s.BulkWalkStrings("1.3.6.1.2.1.47.1.1.1.1.7")
+	if err != nil {
+		t.Error("failed raised error")
+	}
+	expected := map[string]string{
+		"1.2": "Slot 1",
+		"2":   "3",
+	}
+	if d := cmp.Diff(actual, expected); d != "" {
+		t.Errorf("invalid result %s", d)
+	}
+}
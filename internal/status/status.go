@@ -0,0 +1,100 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/config"
+)
+
+type Status struct {
+	ConfigChecksum string   `json:"configChecksum"`
+	Queue          Queue    `json:"queue"`
+	Workers        []Worker `json:"workers"`
+}
+
+type Queue struct {
+	Memory int `json:"memory"`
+	// disk-cache が無効な場合は nil
+	Disk      *int   `json:"disk,omitempty"`
+	DiskBytes *int64 `json:"diskBytes,omitempty"`
+	// 古すぎるため破棄したメトリックの起動からの累計
+	Discarded uint64 `json:"discarded"`
+	// send-queue の上限により破棄したメトリックの起動からの累計
+	Dropped uint64 `json:"dropped"`
+}
+
+type Worker struct {
+	CollectorID string     `json:"collectorID"`
+	Name        string     `json:"name"`
+	Alive       bool       `json:"alive"`
+	LastTick    *time.Time `json:"lastTick,omitempty"`
+
+	// 以下は collector の取得結果がある場合のみ
+	LastError        string   `json:"lastError,omitempty"`
+	LastPollDuration *float64 `json:"lastPollDurationSeconds,omitempty"`
+	Metrics          *int     `json:"metrics,omitempty"`
+	Overruns         *uint64  `json:"overruns,omitempty"`
+	QueueLatency     *float64 `json:"lastQueueLatencySeconds,omitempty"`
+}
+
+// Server は稼働状況を JSON で返す
+type Server struct {
+	srv        *http.Server
+	snapshot   func() *Status
+	isShutdown atomic.Bool
+}
+
+func New(addr string, snapshot func() *Status) *Server {
+	s := &Server{snapshot: snapshot}
+	mux := http.NewServeMux()
+	mux.Handle("GET /status", s)
+	s.srv = &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s.snapshot()); err != nil {
+		slog.Warn("failed write status", slog.String("error", err.Error()))
+	}
+}
+
+func (s *Server) Serve() error {
+	slog.Info("listen status", slog.String("addr", s.srv.Addr))
+	if err := s.srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	if !s.isShutdown.CompareAndSwap(false, true) {
+		return nil
+	}
+	return s.srv.Shutdown(ctx)
+}
+
+func (*Server) Reload(conf *config.CollectorConfig) {
+	// no support
+}
+
+func (*Server) CollectorID() string {
+	// no support
+	return ""
+}
+
+func (s *Server) Alive() bool {
+	return !s.isShutdown.Load()
+}
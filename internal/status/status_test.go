@@ -0,0 +1,53 @@
+package status
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestServeHTTP(t *testing.T) {
+	tick := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	duration := 1.5
+	metrics := 10
+	disk := 1000
+	diskBytes := int64(2048)
+
+	expected := &Status{
+		ConfigChecksum: "abcdef",
+		Queue:          Queue{Memory: 3, Disk: &disk, DiskBytes: &diskBytes},
+		Workers: []Worker{
+			{
+				CollectorID:      "host=192.0.2.1,port=161,hostID=xxx",
+				Name:             "metric",
+				Alive:            true,
+				LastTick:         &tick,
+				LastError:        "request timeout",
+				LastPollDuration: &duration,
+				Metrics:          &metrics,
+			},
+			{
+				Name:  "diskcache",
+				Alive: false,
+			},
+		},
+	}
+	s := New("", func() *Status { return expected })
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/status", nil))
+
+	if ct := rec.Result().Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("invalid content-type: %s", ct)
+	}
+	var actual Status
+	if err := json.NewDecoder(rec.Result().Body).Decode(&actual); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&actual, expected); diff != "" {
+		t.Errorf("value is mismatch (-actual +expected):%s", diff)
+	}
+}
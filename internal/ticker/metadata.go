@@ -4,16 +4,18 @@ import (
 	"cmp"
 	"context"
 	"log/slog"
+	"maps"
 	"reflect"
 	"sync"
 	"time"
 
 	"github.com/mackerelio-labs/sabatrafficd/internal/collector"
 	"github.com/mackerelio-labs/sabatrafficd/internal/config"
+	"github.com/mackerelio-labs/sabatrafficd/internal/metric"
 )
 
 type updateHost interface {
-	UpdateHost(ctx context.Context, hostID, hostAddr string, hostname string, ifs []collector.Interface) error
+	UpdateHost(ctx context.Context, hostID, hostAddr string, hostname string, ifs []collector.Interface, displayNames map[string]string) error
 }
 
 type MetadataTicker struct {
@@ -23,7 +25,8 @@ type MetadataTicker struct {
 	client updateHost
 
 	// cache
-	interfaces []collector.Interface
+	interfaces   []collector.Interface
+	displayNames map[string]string
 }
 
 func MetadataNew(conf *config.CollectorConfig, m updateHost) *MetadataTicker {
@@ -39,22 +42,49 @@ func (t *MetadataTicker) Tick(ctx context.Context) {
 	ctx, stop := context.WithTimeout(ctx, time.Minute)
 	defer stop()
 
+	var displayNames map[string]string
+	if t.conf.InterfaceAlias {
+		displayNames = t.interfaceDisplayNames(ctx)
+	}
+
 	interfaces, err := collector.New(t.conf).DoInterfaceIPAddress(ctx)
 	if err != nil {
 		slog.WarnContext(ctx, "failed getting interfaces", slog.String("error", err.Error()))
 	}
 
-	if reflect.DeepEqual(t.interfaces, interfaces) {
+	if reflect.DeepEqual(t.interfaces, interfaces) && maps.Equal(t.displayNames, displayNames) {
 		slog.InfoContext(ctx, "skip update metadata")
 		return
 	}
 	t.interfaces = interfaces
+	t.displayNames = displayNames
 
-	if err := t.client.UpdateHost(ctx, t.conf.HostID, t.conf.SNMP.Host, cmp.Or(t.conf.HostName, t.conf.SNMP.Host), interfaces); err != nil {
+	if err := t.client.UpdateHost(ctx, t.conf.HostID, t.conf.SNMP.Host, cmp.Or(t.conf.HostName, t.conf.SNMP.Host), interfaces, displayNames); err != nil {
 		slog.WarnContext(ctx, "failed UpdateHost", slog.String("error", err.Error()))
 	}
 }
 
+// interfaceDisplayNames はメトリック名に使うインターフェイス名:ifAlias を返す
+// メトリック名は ifAlias を書き換えても変わらないよう interface.name で決め、グラフの凡例にのみ ifAlias を使う
+func (t *MetadataTicker) interfaceDisplayNames(ctx context.Context) map[string]string {
+	ifNames, aliases, err := collector.New(t.conf).DoInterfaceAliases(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "failed getting interface aliases", slog.String("error", err.Error()))
+		// 取得できなかった場合は前回の凡例を使い続ける
+		return t.displayNames
+	}
+	names := metric.InterfaceMetricNames(ifNames)
+	displayNames := make(map[string]string, len(aliases))
+	for ifIndex, alias := range aliases {
+		displayNames[names[ifIndex]] = alias
+	}
+	return displayNames
+}
+
+func (*MetadataTicker) Name() string {
+	return "metadata"
+}
+
 func (t *MetadataTicker) Reload(conf *config.CollectorConfig) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -62,6 +92,13 @@ func (t *MetadataTicker) Reload(conf *config.CollectorConfig) {
 	t.conf = conf
 }
 
+func (t *MetadataTicker) Interval() time.Duration {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.conf.MetadataInterval
+}
+
 func (t *MetadataTicker) CollectorID() string {
 	return t.conf.CollectorID()
 }
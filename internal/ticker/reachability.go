@@ -0,0 +1,96 @@
+package ticker
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+const (
+	reachabilityCheckName = "snmp.reachability"
+	// 状態が変わらなくても、この間隔で投稿しなおす
+	reachabilityRepostInterval = 10 * time.Minute
+	reachabilityPostTimeout    = 10 * time.Second
+)
+
+type checkReporter interface {
+	PostCheckReports(ctx context.Context, reports []*mackerel.CheckReport) error
+}
+
+// reachability は Poll の成否をチェック監視として投稿する
+type reachability struct {
+	client checkReporter
+
+	// 投稿の間は保持する
+	mu sync.Mutex
+	// 投稿が次の Tick より遅れた場合に、古い結果で上書きしないよう最新の Poll の時刻を持つ
+	latest time.Time
+	// resolve の後は投稿しない
+	resolved bool
+
+	// 最後に投稿に成功した内容
+	status     mackerel.CheckStatus
+	message    string
+	lastPosted time.Time
+}
+
+func newReachability(client checkReporter) *reachability {
+	return &reachability{client: client}
+}
+
+func (r *reachability) report(ctx context.Context, hostID string, now time.Time, pollErr error) {
+	status, message := mackerel.CheckStatusOK, "SNMP agent is reachable"
+	if pollErr != nil {
+		status, message = mackerel.CheckStatusCritical, pollErr.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.resolved || now.Before(r.latest) {
+		return
+	}
+	r.latest = now
+	if status == r.status && message == r.message && now.Sub(r.lastPosted) < reachabilityRepostInterval {
+		return
+	}
+	r.post(ctx, hostID, now, status, message)
+}
+
+// resolve は reachability-check をやめる際に呼び、アラートが残らないよう OK を投稿する
+func (r *reachability) resolve(ctx context.Context, hostID string, now time.Time, message string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.resolved {
+		return
+	}
+	r.resolved = true
+	if r.status == "" || r.status == mackerel.CheckStatusOK {
+		return
+	}
+	r.post(ctx, hostID, now, mackerel.CheckStatusOK, message)
+}
+
+// post は r.mu を保持して呼ぶ
+// Poll がタイムアウトした場合や停止中でも投稿できるよう、ctx のキャンセルは引き継がない
+func (r *reachability) post(ctx context.Context, hostID string, now time.Time, status mackerel.CheckStatus, message string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), reachabilityPostTimeout)
+	defer cancel()
+	err := r.client.PostCheckReports(ctx, []*mackerel.CheckReport{
+		{
+			Source:     mackerel.NewCheckSourceHost(hostID),
+			Name:       reachabilityCheckName,
+			Status:     status,
+			Message:    message,
+			OccurredAt: now.Unix(),
+		},
+	})
+	if err != nil {
+		// 次回の Tick で投稿しなおす
+		slog.WarnContext(ctx, "failed post reachability check", slog.String("error", err.Error()))
+		return
+	}
+	r.status, r.message, r.lastPosted = status, message, now
+}
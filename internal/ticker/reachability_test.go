@@ -0,0 +1,145 @@
+package ticker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mackerelio/mackerel-client-go"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/collector"
+	"github.com/mackerelio-labs/sabatrafficd/internal/config"
+	"github.com/mackerelio-labs/sabatrafficd/internal/metric"
+)
+
+type mockCheckReporter struct {
+	reports []*mackerel.CheckReport
+	err     error
+}
+
+func (m *mockCheckReporter) PostCheckReports(ctx context.Context, reports []*mackerel.CheckReport) error {
+	if m.err != nil {
+		return m.err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.reports = append(m.reports, reports...)
+	return nil
+}
+
+func TestReachability(t *testing.T) {
+	client := &mockCheckReporter{}
+	r := newReachability(client)
+	now := time.Unix(1700000000, 0)
+	timeout := errors.New("request timeout (after 3 retries)")
+
+	steps := []struct {
+		name     string
+		after    time.Duration
+		err      error
+		postErr  error
+		expected mackerel.CheckStatus // 空の場合は投稿されない
+	}{
+		{name: "first", after: 0, expected: mackerel.CheckStatusOK},
+		{name: "unchanged", after: time.Minute},
+		{name: "down", after: time.Minute, err: timeout, expected: mackerel.CheckStatusCritical},
+		{name: "still down", after: time.Minute, err: timeout},
+		{name: "failed post", after: time.Minute, postErr: errors.New("api error")},
+		{name: "retry", after: time.Minute, expected: mackerel.CheckStatusOK},
+		{name: "repost", after: reachabilityRepostInterval, expected: mackerel.CheckStatusOK},
+	}
+	for _, step := range steps {
+		now = now.Add(step.after)
+		client.err = step.postErr
+		before := len(client.reports)
+
+		r.report(context.Background(), "host", now, step.err)
+
+		if step.expected == "" {
+			if len(client.reports) != before {
+				t.Errorf("%s: unexpected report: %+v", step.name, client.reports[len(client.reports)-1])
+			}
+			continue
+		}
+		if len(client.reports) != before+1 {
+			t.Fatalf("%s: report is not posted", step.name)
+		}
+		report := client.reports[len(client.reports)-1]
+		if report.Status != step.expected || report.Name != reachabilityCheckName || report.OccurredAt != now.Unix() {
+			t.Errorf("%s: invalid report: %+v", step.name, report)
+		}
+		if step.err != nil && report.Message != step.err.Error() {
+			t.Errorf("%s: invalid message: %s", step.name, report.Message)
+		}
+	}
+}
+
+func TestReachabilityResolve(t *testing.T) {
+	client := &mockCheckReporter{}
+	r := newReachability(client)
+	now := time.Unix(1700000000, 0)
+
+	r.report(context.Background(), "host", now, errors.New("request timeout (after 3 retries)"))
+	// 遅れて届いた古い結果は投稿しない
+	r.report(context.Background(), "host", now.Add(-time.Minute), nil)
+	r.resolve(context.Background(), "host", now.Add(time.Minute), "collector is removed")
+	// resolve の後は投稿しない
+	r.report(context.Background(), "host", now.Add(2*time.Minute), errors.New("request timeout (after 3 retries)"))
+
+	if len(client.reports) != 2 {
+		t.Fatalf("invalid reports: %d", len(client.reports))
+	}
+	if report := client.reports[1]; report.Status != mackerel.CheckStatusOK || report.Message != "collector is removed" {
+		t.Errorf("invalid report: %+v", report)
+	}
+
+	// OK のままであれば投稿しない
+	client = &mockCheckReporter{}
+	r = newReachability(client)
+	r.report(context.Background(), "host", now, nil)
+	r.resolve(context.Background(), "host", now.Add(time.Minute), "collector is removed")
+	if len(client.reports) != 1 {
+		t.Errorf("invalid reports: %d", len(client.reports))
+	}
+}
+
+type blockingCollector struct{}
+
+func (blockingCollector) Poll(ctx context.Context) (*collector.Result, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+type nopRecorder struct{}
+
+func (nopRecorder) ObservePoll(string, string, string, uint16, time.Time, time.Duration, int, int, error) {
+}
+
+func TestTickReportsDeadlineExceeded(t *testing.T) {
+	client := &mockCheckReporter{}
+	conf := &config.CollectorConfig{HostID: "host", Interval: time.Minute}
+	tick := &Ticker{
+		hostID:          conf.HostID,
+		interval:        conf.Interval,
+		collector:       blockingCollector{},
+		converter:       metric.NewConverter(false),
+		customConverter: newCustomConverter(conf),
+		recorder:        nopRecorder{},
+		reachability:    newReachability(client),
+	}
+
+	// 投稿する時点では Tick に渡した ctx も期限切れになっている
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	tick.Tick(ctx)
+	tick.posting.Wait()
+
+	if len(client.reports) != 1 {
+		t.Fatalf("invalid reports: %d", len(client.reports))
+	}
+	if report := client.reports[0]; report.Status != mackerel.CheckStatusCritical || report.Message != context.DeadlineExceeded.Error() {
+		t.Errorf("invalid report: %+v", report)
+	}
+}
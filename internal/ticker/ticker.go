@@ -2,7 +2,9 @@ package ticker
 
 import (
 	"context"
+	"errors"
 	"log/slog"
+	"slices"
 	"sync"
 	"time"
 
@@ -11,23 +13,36 @@ import (
 	"github.com/mackerelio-labs/sabatrafficd/internal/collector"
 	"github.com/mackerelio-labs/sabatrafficd/internal/config"
 	"github.com/mackerelio-labs/sabatrafficd/internal/metric"
+	"github.com/mackerelio-labs/sabatrafficd/internal/snmp"
 )
 
 type enqueuer interface {
 	Enqueue(hostID string, rawMetrics []*mackerel.MetricValue)
 }
 type customConverter interface {
-	Convert(resp map[string]float64) []*mackerel.MetricValue
+	Convert(resp map[string]snmp.Value, now time.Time) []*mackerel.MetricValue
+	ConvertTable(rows []collector.CustomTableDutum, now time.Time) []*mackerel.MetricValue
+	Reset()
+	ResetScalars()
+	ResetTables()
 }
 type converter interface {
-	Convert(rawMetrics []collector.MetricsDutum, now time.Time) []*mackerel.MetricValue
+	Convert(rawMetrics []collector.MetricsDutum, ifNames map[uint64]string, now time.Time) []*mackerel.MetricValue
 	Reset()
 }
 
+type observer interface {
+	UpdateInterfaces(collectorID, host string, metrics []collector.MetricsDutum)
+	UpdateCustomMIBs(collectorID, host string, values map[string]snmp.Value, counters map[string]bool)
+	UpdateCustomMIBTables(collectorID, host string, rows []collector.CustomTableDutum, counters map[string]bool)
+}
+
+type recorder interface {
+	ObservePoll(collectorID, hostID, host string, port uint16, started time.Time, d time.Duration, interfaces, metrics int, err error)
+}
+
 type collectorIface interface {
-	Do(ctx context.Context) ([]collector.MetricsDutum, error)
-	DoCustomMIBs(ctx context.Context) (map[string]float64, error)
-	DoInterfaceIPAddress(ctx context.Context) ([]collector.Interface, error)
+	Poll(ctx context.Context) (*collector.Result, error)
 }
 
 type Ticker struct {
@@ -35,67 +50,256 @@ type Ticker struct {
 
 	collectorID     string
 	hostID          string
+	host            string
+	port            uint16
 	queue           enqueuer
+	observer        observer
+	recorder        recorder
 	customConverter customConverter
-	converter       converter
-	collector       collectorIface
+	// type: counter が指定された mib
+	counterMIBs map[string]bool
+	converter   converter
+	collector   collectorIface
+	// 接続先の登録を解除する
+	unregister    func()
+	checkReporter checkReporter
+	// reachability-check が無効の場合は nil
+	reachability *reachability
+	// 投稿中の reachability
+	posting sync.WaitGroup
+
+	interval  time.Duration
+	perSecond bool
+	// 前回の sysUpTime。折り返し以外で巻き戻った場合は機器が再起動したとみなす
+	sysUpTime upTime
 }
 
-func New(conf *config.CollectorConfig, q enqueuer) *Ticker {
-	return &Ticker{
+func New(conf *config.CollectorConfig, q enqueuer, c checkReporter, o observer, r recorder) *Ticker {
+	t := &Ticker{
 		collectorID:     conf.CollectorID(),
 		hostID:          conf.HostID,
+		host:            conf.SNMP.Host,
+		port:            conf.SNMP.Port,
 		queue:           q,
-		customConverter: metric.NewCustom(conf.CustomMIBmetricNameMappedMIBs),
-		converter:       metric.NewConverter(),
+		observer:        o,
+		recorder:        r,
+		customConverter: newCustomConverter(conf),
+		counterMIBs:     counterMIBs(conf),
+		converter:       metric.NewConverter(conf.CounterPerSecond),
+		interval:        conf.Interval,
+		perSecond:       conf.CounterPerSecond,
 		collector:       collector.New(conf),
+		unregister:      snmp.Register(conf.SNMP),
+		checkReporter:   c,
 	}
+	if conf.ReachabilityCheck {
+		t.reachability = newReachability(c)
+	}
+	return t
 }
 
 func (t *Ticker) Tick(ctx context.Context) {
 	now := time.Now()
 
-	ctx, stop := context.WithDeadline(ctx, now.Add(time.Minute))
-	defer stop()
+	t.mu.RLock()
+	pollErr := t.poll(ctx, now)
+	reachability, hostID := t.reachability, t.hostID
+	if reachability != nil {
+		t.posting.Add(1)
+	}
+	t.mu.RUnlock()
 
-	t.do(ctx, now)
-	t.doCustomMIBs(ctx)
+	if reachability != nil {
+		// 投稿を待つ間も他の collector が Poll できるよう、同時実行数の枠を空けてから投稿する
+		go func() {
+			defer t.posting.Done()
+			reachability.report(ctx, hostID, now, pollErr)
+		}()
+	}
 }
 
-func (t *Ticker) do(ctx context.Context, now time.Time) {
-	t.mu.RLock()
-	defer t.mu.RUnlock()
-	metrics, err := t.collector.Do(ctx)
+// poll は t.mu の RLock を保持して呼ぶ。reachability-check に使う Poll のエラーを返す
+func (t *Ticker) poll(ctx context.Context, now time.Time) error {
+	// 次の Tick までに終える
+	ctx, stop := context.WithDeadline(ctx, now.Add(t.interval))
+	defer stop()
+
+	var interfaces, produced int
+	result, err := t.collector.Poll(ctx)
+	pollErr := err
 	if err != nil {
-		slog.WarnContext(ctx, "failed exec collector.Do()", slog.String("error", err.Error()))
+		slog.WarnContext(ctx, "failed exec collector.Poll()", slog.String("error", err.Error()))
 		t.converter.Reset()
-		return
+		t.customConverter.Reset()
+	} else {
+		if result.SysUpTimeErr == nil {
+			if prev, rebooted := t.sysUpTime.update(result.SysUpTime, result.Time); rebooted {
+				slog.InfoContext(ctx, "sysUpTime went backwards, counters are rebaselined",
+					slog.Uint64("previous", prev), slog.Uint64("current", result.SysUpTime))
+				t.converter.Reset()
+				t.customConverter.Reset()
+			}
+		}
+
+		var customProduced, tableProduced int
+		var metricsErr, customErr, tableErr error
+		interfaces, produced, metricsErr = t.do(ctx, result)
+		customProduced, customErr = t.doCustomMIBs(ctx, result)
+		tableProduced, tableErr = t.doCustomMIBTables(ctx, result)
+		produced += customProduced + tableProduced
+		err = errors.Join(metricsErr, customErr, tableErr)
 	}
-	if m := t.converter.Convert(metrics, now); m != nil {
+
+	t.recorder.ObservePoll(t.collectorID, t.hostID, t.host, t.port, now, time.Since(now), interfaces, produced, err)
+
+	// 一部の MIB の取得に失敗した場合でも、機器は応答しているので Poll の成否のみで判断する
+	return pollErr
+}
+
+// 取得したインターフェイス数と、投稿するメトリック数を返す
+func (t *Ticker) do(ctx context.Context, result *collector.Result) (int, int, error) {
+	if result.MetricsErr != nil {
+		slog.WarnContext(ctx, "failed collect interface metrics", slog.String("error", result.MetricsErr.Error()))
+		t.converter.Reset()
+		return 0, 0, result.MetricsErr
+	}
+	metrics := result.Metrics
+	t.observer.UpdateInterfaces(t.collectorID, t.host, metrics)
+
+	ifIndexes := make(map[uint64]struct{})
+	for _, m := range metrics {
+		ifIndexes[m.IfIndex] = struct{}{}
+	}
+
+	m := t.converter.Convert(metrics, result.IfNames, result.Time)
+	if m != nil {
 		t.queue.Enqueue(t.hostID, m)
 	}
+	return len(ifIndexes), len(m), nil
 }
 
-func (t *Ticker) doCustomMIBs(ctx context.Context) {
-	t.mu.RLock()
-	defer t.mu.RUnlock()
-	customMetrics, err := t.collector.DoCustomMIBs(ctx)
-	if err != nil {
-		slog.WarnContext(ctx, "failed exec collector.DoCustomMIBs()", slog.String("error", err.Error()))
-		return
+func (t *Ticker) doCustomMIBs(ctx context.Context, result *collector.Result) (int, error) {
+	if result.CustomErr != nil {
+		slog.WarnContext(ctx, "failed collect custom mibs", slog.String("error", result.CustomErr.Error()))
+		t.customConverter.ResetScalars()
+		return 0, result.CustomErr
 	}
-	if m := t.customConverter.Convert(customMetrics); m != nil {
+	t.observer.UpdateCustomMIBs(t.collectorID, t.host, result.Custom, t.counterMIBs)
+	m := t.customConverter.Convert(result.Custom, result.Time)
+	if m != nil {
 		t.queue.Enqueue(t.hostID, m)
 	}
+	return len(m), nil
+}
+
+func (t *Ticker) doCustomMIBTables(ctx context.Context, result *collector.Result) (int, error) {
+	if result.TablesErr != nil {
+		slog.WarnContext(ctx, "failed collect custom mib tables", slog.String("error", result.TablesErr.Error()))
+		// custom-mibs の前回値は残し、table の取得失敗で他の counter の値が欠けないようにする
+		t.customConverter.ResetTables()
+		return 0, result.TablesErr
+	}
+	t.observer.UpdateCustomMIBTables(t.collectorID, t.host, result.Tables, t.counterMIBs)
+	m := t.customConverter.ConvertTable(result.Tables, result.Time)
+	if m != nil {
+		t.queue.Enqueue(t.hostID, m)
+	}
+	return len(m), nil
+}
+
+func newCustomConverter(conf *config.CollectorConfig) *metric.Custom {
+	// metricNamePrefix:columns
+	tables := make(map[string]metric.TableColumns, len(conf.CustomMIBTables))
+	counters := slices.Clone(conf.CustomMIBCounters)
+	for _, table := range conf.CustomMIBTables {
+		tables[table.MetricNamePrefix] = metric.TableColumns{MIB: table.MIB, NameMIB: table.NameMIB}
+		if table.Counter {
+			counters = append(counters, table.MetricNamePrefix)
+		}
+	}
+	return metric.NewCustom(conf.CustomMIBmetricNameMappedMIBs, tables, counters)
+}
+
+func counterMIBs(conf *config.CollectorConfig) map[string]bool {
+	counters := make(map[string]bool)
+	for _, name := range conf.CustomMIBCounters {
+		counters[conf.CustomMIBmetricNameMappedMIBs[name]] = true
+	}
+	for _, table := range conf.CustomMIBTables {
+		if table.Counter {
+			counters[table.MIB] = true
+		}
+	}
+	return counters
+}
+
+func (*Ticker) Name() string {
+	return "metric"
 }
 
 func (t *Ticker) Reload(conf *config.CollectorConfig) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
+	prev, prevHostID := t.reachability, t.hostID
+	switch {
+	case !conf.ReachabilityCheck:
+		t.reachability = nil
+	case t.reachability == nil || t.hostID != conf.HostID:
+		t.reachability = newReachability(t.checkReporter)
+	}
+	if prev != nil && prev != t.reachability {
+		t.posting.Add(1)
+		go func() {
+			defer t.posting.Done()
+			prev.resolve(context.Background(), prevHostID, time.Now(), "reachability check is stopped")
+		}()
+	}
 	t.hostID = conf.HostID
-	t.customConverter = metric.NewCustom(conf.CustomMIBmetricNameMappedMIBs)
+	t.host = conf.SNMP.Host
+	t.port = conf.SNMP.Port
+	t.interval = conf.Interval
+	t.customConverter = newCustomConverter(conf)
+	t.counterMIBs = counterMIBs(conf)
 	t.collector = collector.New(conf)
+	// 接続先が変わらなければ空きハンドラを引き継げるよう、新しい登録の後に解除する
+	unregister := t.unregister
+	t.unregister = snmp.Register(conf.SNMP)
+	unregister()
+	if t.perSecond != conf.CounterPerSecond {
+		t.converter = metric.NewConverter(conf.CounterPerSecond)
+		t.perSecond = conf.CounterPerSecond
+	}
+}
+
+// Close は collector の停止時に呼ばれ、投稿中の reachability を待って接続先の登録を解除する
+func (t *Ticker) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.posting.Wait()
+	t.unregister()
+	return nil
+}
+
+// Remove は設定から collector が削除された際に Close の後に呼ばれ、reachability のアラートを閉じる
+// 停止時にも閉じると、機器が応答しないまま再起動した場合にアラートが閉じてしまうため Close とは分ける
+func (t *Ticker) Remove(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.posting.Wait()
+	if t.reachability != nil {
+		t.reachability.resolve(ctx, t.hostID, time.Now(), "collector is removed")
+	}
+	return nil
+}
+
+func (t *Ticker) Interval() time.Duration {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.interval
 }
 
 func (t *Ticker) CollectorID() string {
@@ -0,0 +1,38 @@
+package ticker
+
+import (
+	"sync"
+	"time"
+)
+
+// sysUpTime (TimeTicks) は 1/100 秒単位の 32bit の値で、約497日で折り返す
+const sysUpTimeWrap = 1 << 32
+
+// upTime は前回取得した sysUpTime を記録し、機器の再起動を判定する
+// Tick は読み取りロックのまま実行されるため、専用のロックで保護する
+type upTime struct {
+	mu    sync.Mutex
+	value uint64
+	at    time.Time
+}
+
+// update は sysUpTime を記録し、前回の値と、前回から機器が再起動したかを返す
+func (u *upTime) update(value uint64, at time.Time) (uint64, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	prev, prevAt := u.value, u.at
+	u.value, u.at = value, at
+	if prevAt.IsZero() || value >= prev {
+		return prev, false
+	}
+	return prev, !wrapped(prev, value, at.Sub(prevAt))
+}
+
+// wrapped は sysUpTime が戻った場合に、経過時間から折り返しと見込めるかを返す
+// 取得の遅れを考慮して、経過時間の2倍までを許容する
+func wrapped(prev, current uint64, elapsed time.Duration) bool {
+	ticks := uint64(max(elapsed, 0) / (10 * time.Millisecond))
+	slack := 2 * ticks
+	return prev+slack >= sysUpTimeWrap && current <= slack
+}
@@ -0,0 +1,32 @@
+package ticker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUpTime(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		value    uint64
+		at       time.Time
+		rebooted bool
+	}{
+		{name: "first", value: sysUpTimeWrap - 3000, at: now},
+		{name: "increased", value: sysUpTimeWrap - 1000, at: now.Add(20 * time.Second)},
+		// 1分後に 2^32 を超えて 5000 になった
+		{name: "wrapped", value: 5000, at: now.Add(80 * time.Second)},
+		{name: "increased after wrap", value: 11000, at: now.Add(140 * time.Second)},
+		{name: "rebooted", value: 300, at: now.Add(200 * time.Second), rebooted: true},
+		// 折り返しまで遠い値から戻った場合は再起動とみなす
+		{name: "rebooted again", value: 200, at: now.Add(260 * time.Second), rebooted: true},
+	}
+
+	var u upTime
+	for _, tc := range tests {
+		if _, rebooted := u.update(tc.value, tc.at); rebooted != tc.rebooted {
+			t.Errorf("%s: rebooted = %v, want %v", tc.name, rebooted, tc.rebooted)
+		}
+	}
+}
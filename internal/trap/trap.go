@@ -0,0 +1,335 @@
+package trap
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/mackerelio/mackerel-client-go"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/config"
+)
+
+const (
+	oidSnmpTrapOID     = "1.3.6.1.6.3.1.1.4.1.0"
+	oidSnmpTrapAddress = "1.3.6.1.6.3.18.1.3.0"
+
+	oidColdStart = "1.3.6.1.6.3.1.1.5.1"
+	oidWarmStart = "1.3.6.1.6.3.1.1.5.2"
+	oidLinkDown  = "1.3.6.1.6.3.1.1.5.3"
+	oidLinkUp    = "1.3.6.1.6.3.1.1.5.4"
+
+	oidIfIndex = "1.3.6.1.2.1.2.2.1.1."
+	oidIfDescr = "1.3.6.1.2.1.2.2.1.2."
+	oidIfName  = "1.3.6.1.2.1.31.1.1.1.1."
+)
+
+// 投稿待ちのチェック結果の上限。超えた分は破棄する
+const queueSize = 1000
+
+type poster interface {
+	PostCheckReports(ctx context.Context, reports []*mackerel.CheckReport) error
+}
+
+// Receiver はトラップを受信し、送信元の機器に対応するホストのチェック監視として投稿する
+type Receiver struct {
+	conf     *config.TrapConfig
+	client   poster
+	listener *gosnmp.TrapListener
+
+	// 送信元IP:ホストID
+	targets atomic.Pointer[map[string]string]
+
+	queue      chan *mackerel.CheckReport
+	stop       chan struct{}
+	stopped    chan struct{}
+	isShutdown atomic.Bool
+}
+
+func New(conf *config.TrapConfig, client poster) *Receiver {
+	params := &gosnmp.GoSNMP{
+		Version: gosnmp.Version2c,
+	}
+	if conf.V3 != nil {
+		params = &gosnmp.GoSNMP{
+			Version:            gosnmp.Version3,
+			SecurityModel:      gosnmp.UserSecurityModel,
+			MsgFlags:           conf.V3.MsgFlags(),
+			SecurityParameters: conf.V3.SecurityParameters(),
+		}
+	}
+
+	r := &Receiver{
+		conf:     conf,
+		client:   client,
+		listener: gosnmp.NewTrapListener(),
+		queue:    make(chan *mackerel.CheckReport, queueSize),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	r.listener.Params = params
+	r.listener.OnNewTrap = r.handle
+	r.targets.Store(&map[string]string{})
+	return r
+}
+
+// SetTargets は collector の接続先アドレスとホストIDの対応を更新する
+// 接続先がホスト名の場合は、名前解決したアドレスを使う
+func (r *Receiver) SetTargets(ctx context.Context, collectors []*config.CollectorConfig) {
+	targets := make(map[string]string, len(collectors))
+	for _, c := range collectors {
+		if ip := net.ParseIP(c.SNMP.Host); ip != nil {
+			targets[ip.String()] = c.HostID
+			continue
+		}
+		lookupCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		addrs, err := net.DefaultResolver.LookupHost(lookupCtx, c.SNMP.Host)
+		cancel()
+		if err != nil {
+			slog.WarnContext(ctx, "failed resolve host for trap", slog.String("host", c.SNMP.Host), slog.String("error", err.Error()))
+			continue
+		}
+		for _, addr := range addrs {
+			targets[addr] = c.HostID
+		}
+	}
+	r.targets.Store(&targets)
+}
+
+func (r *Receiver) handle(packet *gosnmp.SnmpPacket, addr *net.UDPAddr) {
+	switch packet.Version {
+	case gosnmp.Version2c:
+		if r.conf.Community == "" || packet.Community != r.conf.Community {
+			slog.Debug("drop trap because community is mismatched", slog.String("addr", addr.String()))
+			return
+		}
+	case gosnmp.Version3:
+		if r.conf.V3 == nil {
+			return
+		}
+	default:
+		return
+	}
+
+	source := sourceAddress(packet, addr)
+	hostID, ok := (*r.targets.Load())[source]
+	if !ok {
+		slog.Debug("drop trap from unknown device", slog.String("addr", source))
+		return
+	}
+
+	report := r.report(packet, hostID, time.Now())
+	if report == nil {
+		return
+	}
+	select {
+	case r.queue <- report:
+	default:
+		slog.Warn("drop trap because queue is full", slog.String("addr", source), slog.String("name", report.Name))
+	}
+}
+
+// snmpTrapAddress があればトラップを中継した機器ではなく元の機器のアドレスを使う
+func sourceAddress(packet *gosnmp.SnmpPacket, addr *net.UDPAddr) string {
+	for _, v := range packet.Variables {
+		if strings.TrimPrefix(v.Name, ".") != oidSnmpTrapAddress {
+			continue
+		}
+		if s, ok := v.Value.(string); ok {
+			if ip := net.ParseIP(s); ip != nil {
+				return ip.String()
+			}
+		}
+	}
+	return addr.IP.String()
+}
+
+// report はトラップをチェック監視の結果に変換する。通知対象でなければ nil を返す
+func (r *Receiver) report(packet *gosnmp.SnmpPacket, hostID string, now time.Time) *mackerel.CheckReport {
+	var (
+		trapOID string
+		ifIndex string
+		ifName  string
+	)
+	for _, v := range packet.Variables {
+		name := strings.TrimPrefix(v.Name, ".")
+		switch {
+		case name == oidSnmpTrapOID:
+			if s, ok := v.Value.(string); ok {
+				trapOID = strings.TrimPrefix(s, ".")
+			}
+		case strings.HasPrefix(name, oidIfIndex):
+			ifIndex = gosnmp.ToBigInt(v.Value).String()
+		case strings.HasPrefix(name, oidIfName), strings.HasPrefix(name, oidIfDescr) && ifName == "":
+			if b, ok := v.Value.([]byte); ok {
+				ifName = string(b)
+			}
+		}
+	}
+
+	report := &mackerel.CheckReport{
+		Source:     mackerel.NewCheckSourceHost(hostID),
+		OccurredAt: now.Unix(),
+	}
+	switch trapOID {
+	case oidLinkDown, oidLinkUp:
+		if ifIndex == "" {
+			return nil
+		}
+		event, status := "linkDown", mackerel.CheckStatusCritical
+		if trapOID == oidLinkUp {
+			event, status = "linkUp", mackerel.CheckStatusOK
+		}
+		// 同じインターフェイスの linkDown と linkUp が同じアラートになるよう、名前は ifIndex で決める
+		report.Name = fmt.Sprintf("trap.link.%s", ifIndex)
+		report.Status = status
+		report.Message = fmt.Sprintf("%s: ifIndex=%s", event, ifIndex)
+		if ifName != "" {
+			report.Message += fmt.Sprintf(" (%s)", ifName)
+		}
+	case oidColdStart:
+		report.Name, report.Status, report.Message = "trap.coldStart", mackerel.CheckStatusWarning, "coldStart"
+	case oidWarmStart:
+		report.Name, report.Status, report.Message = "trap.warmStart", mackerel.CheckStatusWarning, "warmStart"
+	default:
+		var matched bool
+		for _, rule := range r.conf.Rules {
+			if rule.OID == trapOID {
+				report.Name, report.Status, report.Message = rule.Name, rule.Status, formatVariables(packet.Variables)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil
+		}
+	}
+	return report
+}
+
+func formatVariables(variables []gosnmp.SnmpPDU) string {
+	var parts []string
+	for _, v := range variables {
+		name := strings.TrimPrefix(v.Name, ".")
+		if name == oidSnmpTrapOID || name == "1.3.6.1.2.1.1.3.0" {
+			continue
+		}
+		value := v.Value
+		if b, ok := value.([]byte); ok {
+			value = string(b)
+		}
+		parts = append(parts, fmt.Sprintf("%s=%v", name, value))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (r *Receiver) send() {
+	defer close(r.stopped)
+	for {
+		var reports []*mackerel.CheckReport
+		select {
+		case report := <-r.queue:
+			reports = append(reports, report)
+		case <-r.stop:
+			return
+		}
+	drain:
+		for len(reports) < 100 {
+			select {
+			case report := <-r.queue:
+				reports = append(reports, report)
+			default:
+				break drain
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := r.client.PostCheckReports(ctx, reports); err != nil {
+			slog.Warn("failed post check reports", slog.Int("reports", len(reports)), slog.String("error", err.Error()))
+			cancel()
+			continue
+		}
+		// 対になる回復のトラップがないものは、続けて OK を投稿してアラートを閉じる
+		if recoveries := r.recoveries(reports, time.Now()); len(recoveries) > 0 {
+			if err := r.client.PostCheckReports(ctx, recoveries); err != nil {
+				slog.Warn("failed post check reports", slog.Int("reports", len(recoveries)), slog.String("error", err.Error()))
+			}
+		}
+		cancel()
+	}
+}
+
+// recoveries は一度きりのトラップを閉じるための OK のチェック結果を返す
+// linkDown は linkUp で、OK の rule と同じ名前の rule はその rule で閉じるため対象外
+func (r *Receiver) recoveries(reports []*mackerel.CheckReport, now time.Time) []*mackerel.CheckReport {
+	var recoveries []*mackerel.CheckReport
+	for _, report := range reports {
+		if report.Status == mackerel.CheckStatusOK || strings.HasPrefix(report.Name, "trap.link.") || r.paired(report.Name) {
+			continue
+		}
+		recoveries = append(recoveries, &mackerel.CheckReport{
+			Source:  report.Source,
+			Name:    report.Name,
+			Status:  mackerel.CheckStatusOK,
+			Message: report.Message,
+			// 元のチェック結果より後の時刻にする
+			OccurredAt: max(now.Unix(), report.OccurredAt+1),
+		})
+	}
+	return recoveries
+}
+
+func (r *Receiver) paired(name string) bool {
+	for _, rule := range r.conf.Rules {
+		if rule.Name == name && rule.Status == mackerel.CheckStatusOK {
+			return true
+		}
+	}
+	return false
+}
+
+// Config は受信に使っている設定を返す。listen などの変更は再起動するまで反映されない
+func (r *Receiver) Config() *config.TrapConfig {
+	return r.conf
+}
+
+func (r *Receiver) Serve() error {
+	go r.send()
+	slog.Info("listen trap receiver", slog.String("addr", r.conf.Listen))
+	if err := r.listener.Listen(r.conf.Listen); err != nil && !r.isShutdown.Load() {
+		return err
+	}
+	return nil
+}
+
+func (r *Receiver) Shutdown(ctx context.Context) error {
+	if !r.isShutdown.CompareAndSwap(false, true) {
+		return nil
+	}
+	r.listener.Close()
+	close(r.stop)
+	select {
+	case <-r.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (*Receiver) Reload(conf *config.CollectorConfig) {
+	// no support
+}
+
+func (*Receiver) CollectorID() string {
+	// no support
+	return ""
+}
+
+func (r *Receiver) Alive() bool {
+	return !r.isShutdown.Load()
+}
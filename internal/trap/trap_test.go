@@ -0,0 +1,238 @@
+package trap
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/gosnmp/gosnmp"
+	"github.com/mackerelio/mackerel-client-go"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/config"
+)
+
+type mockPoster struct {
+	mu      sync.Mutex
+	reports []*mackerel.CheckReport
+}
+
+func (m *mockPoster) PostCheckReports(ctx context.Context, reports []*mackerel.CheckReport) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reports = append(m.reports, reports...)
+	return nil
+}
+
+func (m *mockPoster) get() []*mackerel.CheckReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.reports
+}
+
+func trapOID(oid string) gosnmp.SnmpPDU {
+	return gosnmp.SnmpPDU{Name: ".1.3.6.1.6.3.1.1.4.1.0", Type: gosnmp.ObjectIdentifier, Value: oid}
+}
+
+func TestReport(t *testing.T) {
+	r := New(&config.TrapConfig{
+		Community: "public",
+		Rules: []*config.TrapRule{
+			{OID: "1.3.6.1.4.1.9.9.13.3.0.5", Name: "fan", Status: mackerel.CheckStatusCritical},
+		},
+	}, &mockPoster{})
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name      string
+		variables []gosnmp.SnmpPDU
+		expected  *mackerel.CheckReport
+	}{
+		{
+			name: "linkDown",
+			variables: []gosnmp.SnmpPDU{
+				{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(100)},
+				trapOID(".1.3.6.1.6.3.1.1.5.3"),
+				{Name: ".1.3.6.1.2.1.2.2.1.1.3", Type: gosnmp.Integer, Value: 3},
+				{Name: ".1.3.6.1.2.1.2.2.1.2.3", Type: gosnmp.OctetString, Value: []byte("GigabitEthernet0/3")},
+			},
+			expected: &mackerel.CheckReport{
+				Source:     mackerel.NewCheckSourceHost("host"),
+				Name:       "trap.link.3",
+				Status:     mackerel.CheckStatusCritical,
+				Message:    "linkDown: ifIndex=3 (GigabitEthernet0/3)",
+				OccurredAt: now.Unix(),
+			},
+		},
+		{
+			name: "linkUp",
+			variables: []gosnmp.SnmpPDU{
+				trapOID(".1.3.6.1.6.3.1.1.5.4"),
+				{Name: ".1.3.6.1.2.1.2.2.1.1.3", Type: gosnmp.Integer, Value: 3},
+			},
+			expected: &mackerel.CheckReport{
+				Source:     mackerel.NewCheckSourceHost("host"),
+				Name:       "trap.link.3",
+				Status:     mackerel.CheckStatusOK,
+				Message:    "linkUp: ifIndex=3",
+				OccurredAt: now.Unix(),
+			},
+		},
+		{
+			name:      "coldStart",
+			variables: []gosnmp.SnmpPDU{trapOID(".1.3.6.1.6.3.1.1.5.1")},
+			expected: &mackerel.CheckReport{
+				Source:     mackerel.NewCheckSourceHost("host"),
+				Name:       "trap.coldStart",
+				Status:     mackerel.CheckStatusWarning,
+				Message:    "coldStart",
+				OccurredAt: now.Unix(),
+			},
+		},
+		{
+			name: "rule",
+			variables: []gosnmp.SnmpPDU{
+				trapOID(".1.3.6.1.4.1.9.9.13.3.0.5"),
+				{Name: ".1.3.6.1.4.1.9.9.13.1.4.1.2.1", Type: gosnmp.OctetString, Value: []byte("Fan 1")},
+			},
+			expected: &mackerel.CheckReport{
+				Source:     mackerel.NewCheckSourceHost("host"),
+				Name:       "fan",
+				Status:     mackerel.CheckStatusCritical,
+				Message:    "1.3.6.1.4.1.9.9.13.1.4.1.2.1=Fan 1",
+				OccurredAt: now.Unix(),
+			},
+		},
+		{
+			name:      "unknown trap",
+			variables: []gosnmp.SnmpPDU{trapOID(".1.3.6.1.4.1.9.9.41.2.0.1")},
+			expected:  nil,
+		},
+		{
+			name:      "linkDown without ifIndex",
+			variables: []gosnmp.SnmpPDU{trapOID(".1.3.6.1.6.3.1.1.5.3")},
+			expected:  nil,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := r.report(&gosnmp.SnmpPacket{Variables: tc.variables}, "host", now)
+			if diff := cmp.Diff(actual, tc.expected); diff != "" {
+				t.Errorf("value is mismatch (-actual +expected):%s", diff)
+			}
+		})
+	}
+}
+
+func TestRecoveries(t *testing.T) {
+	r := New(&config.TrapConfig{
+		Community: "public",
+		Rules: []*config.TrapRule{
+			{OID: "1.3.6.1.4.1.9.9.13.3.0.5", Name: "fan", Status: mackerel.CheckStatusCritical},
+			{OID: "1.3.6.1.4.1.9.9.13.3.0.6", Name: "power", Status: mackerel.CheckStatusCritical},
+			{OID: "1.3.6.1.4.1.9.9.13.3.0.7", Name: "power", Status: mackerel.CheckStatusOK},
+		},
+	}, &mockPoster{})
+	now := time.Unix(1700000000, 0)
+	source := mackerel.NewCheckSourceHost("host")
+
+	reports := []*mackerel.CheckReport{
+		{Source: source, Name: "trap.link.3", Status: mackerel.CheckStatusCritical, Message: "linkDown: ifIndex=3", OccurredAt: now.Unix()},
+		{Source: source, Name: "trap.coldStart", Status: mackerel.CheckStatusWarning, Message: "coldStart", OccurredAt: now.Unix()},
+		{Source: source, Name: "fan", Status: mackerel.CheckStatusCritical, Message: "fan", OccurredAt: now.Unix()},
+		{Source: source, Name: "power", Status: mackerel.CheckStatusCritical, Message: "power", OccurredAt: now.Unix()},
+	}
+	expected := []*mackerel.CheckReport{
+		{Source: source, Name: "trap.coldStart", Status: mackerel.CheckStatusOK, Message: "coldStart", OccurredAt: now.Unix() + 1},
+		{Source: source, Name: "fan", Status: mackerel.CheckStatusOK, Message: "fan", OccurredAt: now.Unix() + 1},
+	}
+	if diff := cmp.Diff(r.recoveries(reports, now), expected); diff != "" {
+		t.Errorf("value is mismatch (-actual +expected):%s", diff)
+	}
+}
+
+func TestSourceAddress(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 162}
+
+	if actual := sourceAddress(&gosnmp.SnmpPacket{}, addr); actual != "192.0.2.1" {
+		t.Errorf("invalid actual: %s", actual)
+	}
+
+	packet := &gosnmp.SnmpPacket{Variables: []gosnmp.SnmpPDU{
+		{Name: ".1.3.6.1.6.3.18.1.3.0", Type: gosnmp.IPAddress, Value: "192.0.2.10"},
+	}}
+	if actual := sourceAddress(packet, addr); actual != "192.0.2.10" {
+		t.Errorf("invalid actual: %s", actual)
+	}
+}
+
+func TestReceiver(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen := conn.LocalAddr().String()
+	conn.Close()
+
+	poster := &mockPoster{}
+	r := New(&config.TrapConfig{Listen: listen, Community: "public"}, poster)
+	r.SetTargets(context.Background(), []*config.CollectorConfig{
+		{HostID: "host", SNMP: config.CollectorSNMPConfig{Host: "127.0.0.1"}},
+	})
+
+	go func() {
+		if err := r.Serve(); err != nil {
+			t.Error(err)
+		}
+	}()
+	defer r.Shutdown(context.Background()) // nolint
+	select {
+	case <-r.listener.Listening():
+	case <-time.After(5 * time.Second):
+		t.Fatal("listener is not started")
+	}
+
+	host, port, _ := net.SplitHostPort(listen)
+	send := func(community string) {
+		t.Helper()
+		p, _ := net.LookupPort("udp", port)
+		g := &gosnmp.GoSNMP{
+			Target:    host,
+			Port:      uint16(p),
+			Community: community,
+			Version:   gosnmp.Version2c,
+			Timeout:   time.Second,
+		}
+		if err := g.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		defer g.Conn.Close()
+		_, err := g.SendTrap(gosnmp.SnmpTrap{Variables: []gosnmp.SnmpPDU{
+			trapOID(".1.3.6.1.6.3.1.1.5.3"),
+			{Name: ".1.3.6.1.2.1.2.2.1.1.1", Type: gosnmp.Integer, Value: 1},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// コミュニティ名が異なるトラップは無視する
+	send("private")
+	send("public")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(poster.get()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	reports := poster.get()
+	if len(reports) != 1 {
+		t.Fatalf("invalid reports: %d", len(reports))
+	}
+	if reports[0].Name != "trap.link.1" || reports[0].Status != mackerel.CheckStatusCritical {
+		t.Errorf("invalid report: %+v", reports[0])
+	}
+}
@@ -0,0 +1,133 @@
+package worker
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"time"
+)
+
+type observer interface {
+	ObserveOverrun(collectorID string)
+	ObserveQueueLatency(collectorID string, d time.Duration)
+}
+
+// Scheduler は collector ごとの取得開始時刻を、間隔の境界から collector ごとに決まった時間だけずらした時刻に揃える
+// 毎回同じ時刻に取得するので Mackerel 上の点が揃い、collector 間では開始時刻が分散する
+// また、metric と metadata の取得をあわせて同時実行数を制限する
+type Scheduler struct {
+	// 同時に取得する collector 数の上限。上限がない場合は nil
+	sem      chan struct{}
+	observer observer
+}
+
+func NewScheduler(maxConcurrent int, o observer) *Scheduler {
+	s := &Scheduler{observer: o}
+	if maxConcurrent > 0 {
+		s.sem = make(chan struct{}, maxConcurrent)
+	}
+	return s
+}
+
+// New は Scheduler に従って Tick を実行する worker を返す
+func (s *Scheduler) New(tick ticker, d time.Duration) *worker {
+	w := New(tick, d)
+	w.scheduler = s
+	w.aligned = true
+	return w
+}
+
+// NewLimited は開始時刻を揃えず、同時実行数の制限のみ受ける worker を返す
+func (s *Scheduler) NewLimited(tick ticker, d time.Duration) *worker {
+	w := New(tick, d)
+	w.scheduler = s
+	return w
+}
+
+// slot は now 以降で最初の開始時刻を返す
+func slot(now time.Time, d time.Duration, key string) time.Time {
+	h := fnv.New64a()
+	h.Write([]byte(key)) // nolint
+	offset := time.Duration(h.Sum64() % uint64(d))
+
+	start := now.Truncate(d).Add(offset)
+	if start.Before(now) {
+		start = start.Add(d)
+	}
+	return start
+}
+
+func (s *Scheduler) acquire(ctx context.Context) bool {
+	if s.sem == nil {
+		return true
+	}
+	select {
+	case s.sem <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *Scheduler) release() {
+	if s.sem != nil {
+		<-s.sem
+	}
+}
+
+// serveScheduled は開始時刻まで待ってから Tick を実行する
+// Tick が次の開始時刻までに終わらなかった場合は、その回を飛ばして次の開始時刻を待つ
+func (w *worker) serveScheduled(ctx context.Context, quit chan struct{}) error {
+	for {
+		d := time.Duration(w.d.Load())
+		start := slot(time.Now(), d, w.tick.CollectorID())
+
+		timer := time.NewTimer(time.Until(start))
+		select {
+		case <-timer.C:
+		case <-w.reset:
+			// 新しい間隔で開始時刻を決めなおす
+			timer.Stop()
+			continue
+		case <-quit:
+			timer.Stop()
+			return nil
+		}
+
+		if !w.run(ctx) {
+			return nil
+		}
+
+		if elapsed := time.Since(start); elapsed > d {
+			slog.Warn("poll overran its slot",
+				slog.String("collectorID", w.tick.CollectorID()),
+				slog.Duration("elapsed", elapsed), slog.Duration("interval", d))
+			if w.scheduler.observer != nil {
+				w.scheduler.observer.ObserveOverrun(w.tick.CollectorID())
+			}
+		}
+	}
+}
+
+// run は同時実行数の制限を受けて Tick を実行する。停止された場合は false を返す
+func (w *worker) run(ctx context.Context) bool {
+	if w.scheduler == nil {
+		w.lastTick.Store(time.Now().UnixNano())
+		w.tick.Tick(ctx)
+		return true
+	}
+
+	queued := time.Now()
+	if !w.scheduler.acquire(ctx) {
+		return false
+	}
+	defer w.scheduler.release()
+
+	// metadata の待ち時間は metric と区別できないため、metric のみ記録する
+	if latency := time.Since(queued); w.aligned && w.scheduler.observer != nil {
+		w.scheduler.observer.ObserveQueueLatency(w.tick.CollectorID(), latency)
+	}
+	w.lastTick.Store(time.Now().UnixNano())
+	w.tick.Tick(ctx)
+	return true
+}
@@ -0,0 +1,116 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/config"
+)
+
+func TestSlot(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, key := range []string{"a", "b", "host=192.0.2.1,port=161,hostID=xxx"} {
+		start := slot(now, time.Minute, key)
+		if start.Before(now) || !start.Before(now.Add(time.Minute)) {
+			t.Errorf("%s: slot is out of range: %s", key, start)
+		}
+		// 同じ collector は毎回同じ位置に揃う
+		next := slot(start.Add(time.Second), time.Minute, key)
+		if next.Sub(start) != time.Minute {
+			t.Errorf("%s: slot is not aligned: %s, %s", key, start, next)
+		}
+		if actual := slot(start, time.Minute, key); !actual.Equal(start) {
+			t.Errorf("%s: invalid slot: %s", key, actual)
+		}
+	}
+	if slot(now, time.Minute, "a").Equal(slot(now, time.Minute, "b")) {
+		t.Error("slots should be spread")
+	}
+}
+
+type blockingTicker struct {
+	id string
+
+	mu       *sync.Mutex
+	inflight *int
+	peak     *int
+	ticks    atomic.Int64
+	d        time.Duration
+}
+
+func (b *blockingTicker) Tick(context.Context) {
+	b.mu.Lock()
+	*b.inflight++
+	*b.peak = max(*b.peak, *b.inflight)
+	b.mu.Unlock()
+
+	time.Sleep(b.d)
+
+	b.mu.Lock()
+	*b.inflight--
+	b.mu.Unlock()
+	b.ticks.Add(1)
+}
+
+func (*blockingTicker) Reload(*config.CollectorConfig) {}
+
+func (b *blockingTicker) CollectorID() string {
+	return b.id
+}
+
+type mockObserver struct {
+	overruns  atomic.Int64
+	latencies atomic.Int64
+}
+
+func (m *mockObserver) ObserveOverrun(string) {
+	m.overruns.Add(1)
+}
+
+func (m *mockObserver) ObserveQueueLatency(string, time.Duration) {
+	m.latencies.Add(1)
+}
+
+func TestScheduler(t *testing.T) {
+	var (
+		mu             sync.Mutex
+		inflight, peak int
+	)
+	observer := &mockObserver{}
+	s := NewScheduler(1, observer)
+
+	// 同時に1つしか実行できないため、後続は待たされて開始時刻を過ぎる
+	var tickers []*blockingTicker
+	for _, id := range []string{"a", "b", "c"} {
+		tick := &blockingTicker{id: id, mu: &mu, inflight: &inflight, peak: &peak, d: 30 * time.Millisecond}
+		tickers = append(tickers, tick)
+		w := s.New(tick, 50*time.Millisecond)
+		go w.Serve()                           // nolint
+		defer w.Shutdown(context.Background()) // nolint
+	}
+	// metadata も同じ上限を受ける
+	metadata := &blockingTicker{id: "a", mu: &mu, inflight: &inflight, peak: &peak, d: 30 * time.Millisecond}
+	w := s.NewLimited(metadata, 20*time.Millisecond)
+	go w.Serve()                           // nolint
+	defer w.Shutdown(context.Background()) // nolint
+
+	deadline := time.Now().Add(5 * time.Second)
+	for observer.overruns.Load() == 0 || tickers[0].ticks.Load() < 2 || metadata.ticks.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("overrun is not observed: %d", observer.overruns.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if peak != 1 {
+		t.Errorf("concurrent polls exceeded the limit: %d", peak)
+	}
+	if observer.latencies.Load() == 0 {
+		t.Error("queue latency is not observed")
+	}
+}
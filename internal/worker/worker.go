@@ -2,6 +2,7 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"sync"
 	"sync/atomic"
@@ -17,25 +18,55 @@ type ticker interface {
 	CollectorID() string
 }
 
+type namer interface {
+	Name() string
+}
+
+// 設定の再読み込みで間隔が変わる ticker が実装する
+type intervaler interface {
+	Interval() time.Duration
+}
+
+// 停止時に資源を解放する ticker が実装する
+type closer interface {
+	Close() error
+}
+
+// 設定から削除された際に後始末をする ticker が実装する
+type remover interface {
+	Remove(ctx context.Context) error
+}
+
 type worker struct {
 	wg         sync.WaitGroup
 	shutdown   chan struct{}
 	isShutdown atomic.Bool
 
 	tick ticker
-	d    time.Duration
+	d    atomic.Int64
+	// Reload で変更された間隔
+	reset chan time.Duration
+	// nil の場合は同時実行数を制限しない
+	scheduler *Scheduler
+	// 開始時刻を Scheduler に揃える。false の場合は Serve の開始から一定間隔で実行する
+	aligned bool
+
+	// unix nano
+	lastTick atomic.Int64
 }
 
 func New(tick ticker, d time.Duration) *worker {
-	return &worker{
+	w := &worker{
 		shutdown: make(chan struct{}),
 
-		tick: tick,
-		d:    d,
+		tick:  tick,
+		reset: make(chan time.Duration, 1),
 	}
+	w.d.Store(int64(d))
+	return w
 }
 func (w *worker) Serve() error {
-	ticker := time.NewTicker(w.d)
+	ticker := time.NewTicker(time.Duration(w.d.Load()))
 	defer ticker.Stop()
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -55,14 +86,30 @@ func (w *worker) Serve() error {
 
 	w.wg.Add(1)
 	defer w.wg.Done()
+	if w.aligned {
+		return w.serveScheduled(ctx, quit)
+	}
 	for {
-		w.tick.Tick(ctx)
+		if !w.run(ctx) {
+			return nil
+		}
+
+		if !w.wait(ticker, quit) {
+			return nil
+		}
+	}
+}
 
+// wait は次の Tick まで待つ。停止された場合は false を返す
+func (w *worker) wait(ticker *time.Ticker, quit chan struct{}) bool {
+	for {
 		select {
 		case <-ticker.C:
-			continue
+			return true
+		case d := <-w.reset:
+			ticker.Reset(d)
 		case <-quit:
-			return nil
+			return false
 		}
 	}
 }
@@ -74,11 +121,38 @@ func (w *worker) Shutdown(_ context.Context) error {
 
 	close(w.shutdown)
 	w.wg.Wait()
+	if c, ok := w.tick.(closer); ok {
+		return c.Close()
+	}
 	return nil
 }
 
+// Remove は設定から削除された collector を停止する
+func (w *worker) Remove(ctx context.Context) error {
+	err := w.Shutdown(ctx)
+	if r, ok := w.tick.(remover); ok {
+		err = errors.Join(err, r.Remove(ctx))
+	}
+	return err
+}
+
 func (w *worker) Reload(conf *config.CollectorConfig) {
 	w.tick.Reload(conf)
+
+	i, ok := w.tick.(intervaler)
+	if !ok {
+		return
+	}
+	d := i.Interval()
+	if d <= 0 || w.d.Swap(int64(d)) == int64(d) {
+		return
+	}
+	// 未処理の変更は新しい値で置き換える
+	select {
+	case <-w.reset:
+	default:
+	}
+	w.reset <- d
 }
 
 func (w *worker) CollectorID() string {
@@ -88,3 +162,19 @@ func (w *worker) CollectorID() string {
 func (w *worker) Alive() bool {
 	return !w.isShutdown.Load()
 }
+
+// 最後に Tick を開始した時刻。まだ実行していなければゼロ値を返す
+func (w *worker) LastTick() time.Time {
+	v := w.lastTick.Load()
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, v)
+}
+
+func (w *worker) Name() string {
+	if n, ok := w.tick.(namer); ok {
+		return n.Name()
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mackerelio-labs/sabatrafficd/internal/config"
+)
+
+type mockTicker struct {
+	ticks    atomic.Int64
+	interval atomic.Int64
+	closed   atomic.Bool
+	removed  atomic.Bool
+}
+
+func (m *mockTicker) Tick(context.Context) {
+	m.ticks.Add(1)
+}
+
+func (m *mockTicker) Reload(conf *config.CollectorConfig) {
+	m.interval.Store(int64(conf.Interval))
+}
+
+func (*mockTicker) CollectorID() string {
+	return ""
+}
+
+func (m *mockTicker) Interval() time.Duration {
+	return time.Duration(m.interval.Load())
+}
+
+func (m *mockTicker) Close() error {
+	m.closed.Store(true)
+	return nil
+}
+
+func (m *mockTicker) Remove(context.Context) error {
+	if !m.closed.Load() {
+		return errors.New("removed before closed")
+	}
+	m.removed.Store(true)
+	return nil
+}
+
+func TestReloadInterval(t *testing.T) {
+	tick := &mockTicker{}
+	w := New(tick, time.Hour)
+	go w.Serve()                           // nolint
+	defer w.Shutdown(context.Background()) // nolint
+
+	w.Reload(&config.CollectorConfig{Interval: 10 * time.Millisecond})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for tick.ticks.Load() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("interval is not changed: %d ticks", tick.ticks.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestShutdownClosesTicker(t *testing.T) {
+	tick := &mockTicker{}
+	w := New(tick, time.Hour)
+	go w.Serve() // nolint
+
+	if err := w.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !tick.closed.Load() {
+		t.Error("ticker is not closed")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	tick := &mockTicker{}
+	w := New(tick, time.Hour)
+	go w.Serve() // nolint
+
+	if err := w.Remove(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !tick.closed.Load() || !tick.removed.Load() {
+		t.Errorf("ticker is not removed: closed=%v removed=%v", tick.closed.Load(), tick.removed.Load())
+	}
+}